@@ -219,7 +219,7 @@ func (a *Account) TextString() string {
 }
 
 func (a *Account) Add(toAdd Account) {
-	newAccount := joinAccounts(*a, toAdd)
+	newAccount := JoinAccounts(*a, toAdd)
 	*a = *newAccount
 }
 
@@ -281,7 +281,8 @@ func (a *Account) FiatNStableBalance() Balance {
 	return Balance{}
 }
 
-func joinAccounts(account ...Account) *Account {
+// sum balances of accounts, like accounts of different platforms
+func JoinAccounts(account ...Account) *Account {
 	r := NewEmptyAccount()
 
 	// spot
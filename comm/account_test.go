@@ -60,3 +60,18 @@ func TestBalance_Add(t *testing.T) {
 	blc.Add(blc)
 	fmt.Println(blc)
 }
+
+func TestJoinAccounts(t *testing.T) {
+	a := NewEmptyAccount()
+	a.SetSpot("BTC", decimals.NewFromInt(1), decimals.Zero)
+	b := NewEmptyAccount()
+	b.SetSpot("BTC", decimals.NewFromInt(2), decimals.NewFromInt(1))
+	b.SetMargin("USDT", decimals.NewFromInt(100), decimals.Zero, decimals.NewFromInt(50))
+	r := JoinAccounts(*a, *b)
+	if !r.Spot["BTC"].Free.Equal(decimals.NewFromInt(3)) || !r.Spot["BTC"].Locked.Equal(decimals.NewFromInt(1)) {
+		t.Errorf("spot balances not joined")
+	}
+	if !r.Margin["USDT"].Borrowed.Equal(decimals.NewFromInt(50)) {
+		t.Errorf("margin balances not joined")
+	}
+}
@@ -0,0 +1,166 @@
+package comm
+
+import (
+	"strconv"
+	"strings"
+)
+
+type (
+	// ExMethod is the name of a method of ex.Ex or of its extension interfaces
+	ExMethod string
+
+	// Capabilities describes what an exchange adapter supports, other calls fail with ErrFunctionNotSupported.
+	// Lists are sorted.
+	Capabilities struct {
+		Methods      []ExMethod
+		Markets      []Market
+		TradeTypes   []TradeTypeSide // of Trade
+		OrderTypes   []OrderType     // of TradeWithOptions
+		TimeInForces []TimeInForce   // of TradeWithOptions
+		Periods      []Period        // of GetKline
+		MaxDepth     int             // max limit of GetDepth
+		MaxBatchSize int             // max requests of BatchTrade and BatchCancel in one call, 0 if not batched natively
+	}
+)
+
+const (
+	ExMethodGetMarketInfo ExMethod = "GetMarketInfo"
+	ExMethodGetAccount    ExMethod = "GetAccount"
+	ExMethodGetDepth      ExMethod = "GetDepth"
+	ExMethodGetTicks      ExMethod = "GetTicks"
+	ExMethodGetKline      ExMethod = "GetKline"
+	ExMethodGetFills      ExMethod = "GetFills"
+	ExMethodGetBorrowable ExMethod = "GetBorrowable"
+	ExMethodBorrow        ExMethod = "Borrow"
+	ExMethodRepay         ExMethod = "Repay"
+	ExMethodTransfer      ExMethod = "Transfer"
+	ExMethodTrade         ExMethod = "Trade"
+	ExMethodGetAllOrders  ExMethod = "GetAllOrders"
+	ExMethodGetOpenOrders ExMethod = "GetOpenOrders"
+	ExMethodGetOrder      ExMethod = "GetOrder"
+	ExMethodCancelOrder   ExMethod = "CancelOrder"
+
+	ExMethodTradeWithOptions   ExMethod = "TradeWithOptions"
+	ExMethodBatchTrade         ExMethod = "BatchTrade"
+	ExMethodBatchCancel        ExMethod = "BatchCancel"
+	ExMethodCancelAllOrders    ExMethod = "CancelAllOrders"
+	ExMethodGetAllOpenOrders   ExMethod = "GetAllOpenOrders"
+	ExMethodGetOrderByClientId ExMethod = "GetOrderByClientId"
+	ExMethodGetFillsByTime     ExMethod = "GetFillsByTime"
+	ExMethodGetServerTime      ExMethod = "GetServerTime"
+	ExMethodGetPositions       ExMethod = "GetPositions"
+	ExMethodSetLeverage        ExMethod = "SetLeverage"
+	ExMethodSetMarginMode      ExMethod = "SetMarginMode"
+	ExMethodGetFundingRate     ExMethod = "GetFundingRate"
+	ExMethodGetFundingHistory  ExMethod = "GetFundingHistory"
+	ExMethodGetMarkPrice       ExMethod = "GetMarkPrice"
+	ExMethodGetDepositAddress  ExMethod = "GetDepositAddress"
+	ExMethodWithdraw           ExMethod = "Withdraw"
+	ExMethodGetDeposits        ExMethod = "GetDeposits"
+	ExMethodGetWithdrawals     ExMethod = "GetWithdrawals"
+	ExMethodGetTransfers       ExMethod = "GetTransfers"
+)
+
+func (m ExMethod) String() string {
+	return string(m)
+}
+
+func (c Capabilities) HasMethod(method ExMethod) bool {
+	for _, v := range c.Methods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) HasMarket(market Market) bool {
+	for _, v := range c.Markets {
+		if v == market {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) HasTradeType(t TradeTypeSide) bool {
+	for _, v := range c.TradeTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) HasOrderType(t OrderType) bool {
+	for _, v := range c.OrderTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) HasTimeInForce(tif TimeInForce) bool {
+	for _, v := range c.TimeInForces {
+		if v == tif {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) HasPeriod(period Period) bool {
+	for _, v := range c.Periods {
+		if v == period {
+			return true
+		}
+	}
+	return false
+}
+
+// Require checks c covers required, every listed item of required must be supported,
+// MaxDepth and MaxBatchSize of required are minimums. All missing items are told in one ErrorKindNotSupported error.
+func (c Capabilities) Require(required Capabilities) error {
+	var missing []string
+	for _, v := range required.Methods {
+		if !c.HasMethod(v) {
+			missing = append(missing, "method "+v.String())
+		}
+	}
+	for _, v := range required.Markets {
+		if !c.HasMarket(v) {
+			missing = append(missing, "market "+string(v))
+		}
+	}
+	for _, v := range required.TradeTypes {
+		if !c.HasTradeType(v) {
+			missing = append(missing, "trade type "+string(v))
+		}
+	}
+	for _, v := range required.OrderTypes {
+		if !c.HasOrderType(v) {
+			missing = append(missing, "order type "+v.String())
+		}
+	}
+	for _, v := range required.TimeInForces {
+		if !c.HasTimeInForce(v) {
+			missing = append(missing, "time in force "+string(v))
+		}
+	}
+	for _, v := range required.Periods {
+		if !c.HasPeriod(v) {
+			missing = append(missing, "period "+v.String())
+		}
+	}
+	if c.MaxDepth < required.MaxDepth {
+		missing = append(missing, "depth limit "+strconv.Itoa(required.MaxDepth))
+	}
+	if c.MaxBatchSize < required.MaxBatchSize {
+		missing = append(missing, "batch size "+strconv.Itoa(required.MaxBatchSize))
+	}
+	if len(missing) > 0 {
+		return NewExError(ErrorKindNotSupported, "unsupported %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
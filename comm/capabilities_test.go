@@ -0,0 +1,39 @@
+package comm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCapabilities_Require(t *testing.T) {
+	c := Capabilities{
+		Methods:    []ExMethod{ExMethodGetKline, ExMethodTrade},
+		Markets:    []Market{MarketSpot},
+		OrderTypes: []OrderType{OrderTypeLimit},
+		Periods:    []Period{Period1Min},
+		MaxDepth:   100,
+	}
+	if err := c.Require(Capabilities{Methods: []ExMethod{ExMethodTrade}, Markets: []Market{MarketSpot}, MaxDepth: 50}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	err := c.Require(Capabilities{
+		Methods:      []ExMethod{ExMethodTrade, ExMethodBatchTrade},
+		Markets:      []Market{MarketPerp},
+		Periods:      []Period{Period1Min, Period1Hour},
+		MaxDepth:     500,
+		MaxBatchSize: 5,
+	})
+	if !errors.Is(err, ErrFunctionNotSupported) {
+		t.Errorf("expected not supported error, got %v", err)
+		return
+	}
+	for _, s := range []string{"method BatchTrade", "market perp", "period 1hour", "depth limit 500", "batch size 5"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%s missing in error %s", s, err.Error())
+			return
+		}
+	}
+}
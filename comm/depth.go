@@ -134,12 +134,12 @@ func (drd *DepthRawData) Equal(cmp *DepthRawData) bool {
 		return false
 	}
 	for k, v := range drd.Buys {
-		if v.Price != cmp.Buys[k].Price || v.Amount != cmp.Buys[k].Amount {
+		if !v.Price.Equal(cmp.Buys[k].Price) || !v.Amount.Equal(cmp.Buys[k].Amount) {
 			return false
 		}
 	}
 	for k, v := range drd.Sells {
-		if v.Price != cmp.Sells[k].Price || v.Amount != cmp.Sells[k].Amount {
+		if !v.Price.Equal(cmp.Sells[k].Price) || !v.Amount.Equal(cmp.Sells[k].Amount) {
 			return false
 		}
 	}
@@ -279,3 +279,15 @@ func TestDepth_Sort(t *testing.T) {
 		test.PrintlnExit(t, "2 depths should equal")
 	}
 }
+
+func TestDepthRawData_Equal(t *testing.T) {
+	a := newTestDepth(true)
+	b := newTestDepth(true)
+	if !a.DepthRawData.Equal(&b.DepthRawData) {
+		test.PrintlnExit(t, "depths with same price and amount should equal")
+	}
+	b.Sells[0].Amount = decimals.NewFromInt(2)
+	if a.DepthRawData.Equal(&b.DepthRawData) {
+		test.PrintlnExit(t, "depths with different amount should not equal")
+	}
+}
@@ -0,0 +1,110 @@
+package comm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	ErrorKindUnknown             ErrorKind = ""
+	ErrorKindNotSupported        ErrorKind = "not_supported"
+	ErrorKindInsufficientBalance ErrorKind = "insufficient_balance"
+	ErrorKindOrderNotFound       ErrorKind = "order_not_found"
+	ErrorKindRateLimited         ErrorKind = "rate_limited"
+	ErrorKindInvalidLot          ErrorKind = "invalid_lot" // amount/price breaks lot step, lot min or precision
+	ErrorKindMarketClosed        ErrorKind = "market_closed"
+	ErrorKindAuthFailed          ErrorKind = "auth_failed"
+	ErrorKindNetworkTransient    ErrorKind = "network_transient"
+	ErrorKindCircuitOpen         ErrorKind = "circuit_open"
+	ErrorKindRiskRejected        ErrorKind = "risk_rejected" // rejected by pre-trade risk check before sent to exchange
+	ErrorKindClockSkew           ErrorKind = "clock_skew"    // request timestamp out of window of exchange time
+)
+
+type (
+	ErrorKind string
+
+	// ExError is an exchange error classified by Kind.
+	// errors.Is(err, ErrXXX) matches any ExError of the same Kind, errors.As(err, &exErr) gets the detail.
+	ExError struct {
+		Kind ErrorKind
+		Msg  string
+		Err  error // cause, optional
+	}
+)
+
+var (
+	ErrFunctionNotSupported error = &ExError{Kind: ErrorKindNotSupported, Msg: "function not supported"}
+	ErrInsufficientBalance  error = &ExError{Kind: ErrorKindInsufficientBalance, Msg: "insufficient balance"}
+	ErrOrderNotFound        error = &ExError{Kind: ErrorKindOrderNotFound, Msg: "order not found"}
+	ErrRateLimited          error = &ExError{Kind: ErrorKindRateLimited, Msg: "rate limited"}
+	ErrInvalidLot           error = &ExError{Kind: ErrorKindInvalidLot, Msg: "invalid lot"}
+	ErrMarketClosed         error = &ExError{Kind: ErrorKindMarketClosed, Msg: "market closed"}
+	ErrAuthFailed           error = &ExError{Kind: ErrorKindAuthFailed, Msg: "auth failed"}
+	ErrNetworkTransient     error = &ExError{Kind: ErrorKindNetworkTransient, Msg: "network transient"}
+	ErrCircuitOpen          error = &ExError{Kind: ErrorKindCircuitOpen, Msg: "circuit open"}
+	ErrRiskRejected         error = &ExError{Kind: ErrorKindRiskRejected, Msg: "risk rejected"}
+	ErrClockSkew            error = &ExError{Kind: ErrorKindClockSkew, Msg: "clock skew"}
+)
+
+func NewExError(kind ErrorKind, format string, args ...interface{}) error {
+	return &ExError{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+// classify a raw error, like an error returned by http client
+func WrapExError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExError{Kind: kind, Msg: err.Error(), Err: err}
+}
+
+func (e *ExError) Error() string {
+	if e.Msg == "" {
+		return strings.Replace(string(e.Kind), "_", " ", -1)
+	}
+	return e.Msg
+}
+
+func (e *ExError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ExError) Is(target error) bool {
+	t, ok := target.(*ExError)
+	return ok && t.Kind == e.Kind
+}
+
+func (k ErrorKind) String() string {
+	return string(k)
+}
+
+// transient errors may disappear if the same request is sent again later
+func (k ErrorKind) Transient() bool {
+	return k == ErrorKindRateLimited || k == ErrorKindNetworkTransient
+}
+
+// Kind of ExError in err chain, network errors of standard library are taken as ErrorKindNetworkTransient
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ErrorKindUnknown
+	}
+	var exErr *ExError
+	if errors.As(err, &exErr) {
+		return exErr.Kind
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorKindNetworkTransient
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ErrorKindNetworkTransient
+	}
+	return ErrorKindUnknown
+}
+
+func IsTransientError(err error) bool {
+	return ErrorKindOf(err).Transient()
+}
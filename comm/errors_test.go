@@ -0,0 +1,24 @@
+package comm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExError_Is(t *testing.T) {
+	err := NewExError(ErrorKindInsufficientBalance, "free USDT(1) less than 2")
+	if !errors.Is(err, ErrInsufficientBalance) || errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("errors.Is mismatch")
+		return
+	}
+
+	var exErr *ExError
+	if !errors.As(WrapExError(ErrorKindNetworkTransient, errors.New("EOF")), &exErr) || !exErr.Kind.Transient() {
+		t.Errorf("errors.As mismatch")
+		return
+	}
+	if ErrorKindOf(ErrFunctionNotSupported) != ErrorKindNotSupported || IsTransientError(ErrAuthFailed) {
+		t.Errorf("ErrorKindOf mismatch")
+		return
+	}
+}
@@ -1,7 +1,6 @@
 package comm
 
 import (
-	"github.com/pkg/errors"
 	"github.com/shawnwyckoff/commpkg/dsa/decimals"
 	"github.com/shawnwyckoff/commpkg/sys/clock"
 	"time"
@@ -12,8 +11,7 @@ note: all timestamps are UTC + 0 timezone
 */
 
 var (
-	ErrFunctionNotSupported = errors.Errorf("function not supported")
-	AllSupportedExs         = []Platform{Binance}
+	AllSupportedExs = []Platform{Binance}
 )
 
 type (
@@ -43,6 +41,44 @@ type (
 	}
 )
 
+// deep copy, maps are not shared with the original one
+func (cc ExConfig) Clone() ExConfig {
+	r := cc
+	r.PairDelimiterLeftTail = append([]string(nil), cc.PairDelimiterLeftTail...)
+	r.PairDelimiterRightHead = append([]string(nil), cc.PairDelimiterRightHead...)
+	if cc.Periods != nil {
+		r.Periods = map[Period]string{}
+		for k, v := range cc.Periods {
+			r.Periods[k] = v
+		}
+	}
+	if cc.TradeStatus != nil {
+		r.TradeStatus = map[TradeStatus]string{}
+		for k, v := range cc.TradeStatus {
+			r.TradeStatus[k] = v
+		}
+	}
+	if cc.TradeTypes != nil {
+		r.TradeTypes = map[TradeTypeSide]string{}
+		for k, v := range cc.TradeTypes {
+			r.TradeTypes[k] = v
+		}
+	}
+	if cc.WithdrawalFees != nil {
+		r.WithdrawalFees = map[string]decimals.Decimal{}
+		for k, v := range cc.WithdrawalFees {
+			r.WithdrawalFees[k] = v
+		}
+	}
+	if cc.MarketEnabled != nil {
+		r.MarketEnabled = map[Market]bool{}
+		for k, v := range cc.MarketEnabled {
+			r.MarketEnabled[k] = v
+		}
+	}
+	return r
+}
+
 func (cc ExConfig) SupportedPeriods() []Period {
 	var r []Period
 	for p := range cc.Periods {
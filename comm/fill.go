@@ -46,3 +46,25 @@ func (fo FillOption) VerifyBinance() error {
 	}
 	return nil
 }
+
+// verify by rules of platform, only time and id ranges are checked for platforms without known limits
+func (fo FillOption) Verify(platform Platform) error {
+	if platform == Binance {
+		return fo.VerifyBinance()
+	}
+	if clock.BeforeEqual(fo.BeginTime, clock.ZeroTime) && fo.BeginId <= 0 {
+		return errorz.Errorf("both beginTime(%s) and beginId[%d] are invalid", fo.BeginTime.String(), fo.BeginId)
+	}
+	if fo.BeginTime.After(clock.ZeroTime) && fo.TimeDuration <= 0 {
+		return errorz.Errorf("invalid time duration(%s)", fo.TimeDuration)
+	}
+	if fo.BeginId > 0 && fo.IdLimit < 0 {
+		return errorz.Errorf("invalid IdLimit[%d]", fo.IdLimit)
+	}
+	return nil
+}
+
+// time mode walks fills by time windows of TimeDuration, otherwise by id
+func (fo FillOption) ByTime() bool {
+	return fo.BeginTime.After(clock.ZeroTime)
+}
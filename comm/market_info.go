@@ -4,6 +4,7 @@ import (
 	"github.com/shawnwyckoff/commpkg/apputil/errorz"
 	"github.com/shawnwyckoff/commpkg/dsa/decimals"
 	"github.com/shawnwyckoff/commpkg/dsa/stringz"
+	"math"
 )
 
 type (
@@ -128,6 +129,25 @@ func (mi *MarketInfo) SupportMargin(pair Pair) bool {
 	return ok && info.Enabled
 }
 
+// truncate unit amount by lot step, or by unit precision if lot step is unknown
+func (pi PairInfo) RoundAmount(amount decimals.Decimal) decimals.Decimal {
+	if pi.LotStep.IsPositive() {
+		return amount.Trunc(pi.UnitPrecision, pi.LotStep.Float64())
+	}
+	if pi.UnitPrecision > 0 {
+		return amount.Trunc(pi.UnitPrecision, math.Pow10(-pi.UnitPrecision))
+	}
+	return amount
+}
+
+// truncate price by quote precision
+func (pi PairInfo) RoundPrice(price decimals.Decimal) decimals.Decimal {
+	if pi.QuotePrecision <= 0 {
+		return price
+	}
+	return price.Trunc(pi.QuotePrecision, math.Pow10(-pi.QuotePrecision))
+}
+
 func (mi *MarketInfo) Verify() error {
 	for pe := range mi.Infos {
 		if pe.HasPeriod() || pe.HasPlatform() || pe.HasMarket() == false {
@@ -17,6 +17,8 @@ stop-loss/stop-profit
 */
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/shawnwyckoff/commpkg/dsa/decimals"
@@ -54,6 +56,8 @@ type (
 const (
 	OrderIdDelimiter = ":"
 
+	MaxClientOrderIdLen = 36 // limit of Binance, shorter than most exchanges'
+
 	TradeStatusError           TradeStatus = ""
 	TradeStatusNew             TradeStatus = "new"
 	TradeStatusPartiallyFilled TradeStatus = "partially_filled"
@@ -92,21 +96,59 @@ func NewOrderId(market Market, pair Pair, strId string) OrderId {
 	return OrderId(fmt.Sprintf("%s%s%s%s%s", market, OrderIdDelimiter, pair.String(), OrderIdDelimiter, strId))
 }
 
-func (id OrderId) Market() Market {
+// OrderId "market:pair:strId:clientId" of order with client assigned id, same as NewOrderId if clientId is empty
+func NewOrderIdWithClientId(market Market, pair Pair, strId, clientId string) OrderId {
+	if clientId == "" {
+		return NewOrderId(market, pair, strId)
+	}
+	return OrderId(fmt.Sprintf("%s%s%s", NewOrderId(market, pair, strId), OrderIdDelimiter, clientId))
+}
+
+// random client order id, it is unique without any coordination and accepted by exchanges
+func NewClientOrderId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "ft" + hex.EncodeToString(b)
+}
+
+func VerifyClientOrderId(clientId string) error {
+	if clientId == "" || len(clientId) > MaxClientOrderIdLen {
+		return errors.Errorf(`invalid client order id(%s), length should be 1 to %d`, clientId, MaxClientOrderIdLen)
+	}
+	for _, c := range clientId {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return errors.Errorf(`invalid client order id(%s), only letters, digits and "-_." are allowed`, clientId)
+		}
+	}
+	return nil
+}
+
+// client order id is optional
+func (id OrderId) split() []string {
 	ss := strings.Split(string(id), OrderIdDelimiter)
-	if len(ss) != 3 {
+	if len(ss) != 3 && len(ss) != 4 {
+		return nil
+	}
+	return ss
+}
+
+func (id OrderId) Market() Market {
+	ss := id.split()
+	if ss == nil {
 		return MarketError
 	}
 	accType := Market(ss[0])
-	if accType != MarketSpot && accType != MarketMargin {
+	if accType.Verify() != nil {
 		return MarketError
 	}
 	return accType
 }
 
 func (id OrderId) Pair() Pair {
-	ss := strings.Split(string(id), OrderIdDelimiter)
-	if len(ss) != 3 {
+	ss := id.split()
+	if ss == nil {
 		return PairErr
 	}
 	p, err := ParsePair(ss[1])
@@ -116,14 +158,24 @@ func (id OrderId) Pair() Pair {
 	return p
 }
 
+// id assigned by exchange
 func (id OrderId) StrId() string {
-	ss := strings.Split(string(id), OrderIdDelimiter)
-	if len(ss) != 3 {
+	ss := id.split()
+	if ss == nil {
 		return ""
 	}
 	return ss[2]
 }
 
+// id assigned by client, empty if not given
+func (id OrderId) ClientId() string {
+	ss := id.split()
+	if len(ss) != 4 {
+		return ""
+	}
+	return ss[3]
+}
+
 func (id OrderId) Verify() error {
 	errInvalidOrderId := errors.Errorf(`invalid OrderId(%s)`, string(id))
 	if id.Market() == MarketError {
@@ -135,6 +187,9 @@ func (id OrderId) Verify() error {
 	if id.StrId() == "" {
 		return errInvalidOrderId
 	}
+	if len(id.split()) == 4 && VerifyClientOrderId(id.ClientId()) != nil {
+		return errInvalidOrderId
+	}
 	return nil
 }
 
@@ -154,13 +209,7 @@ func (id *OrderId) UnmarshalJSON(b []byte) error {
 	s = stringz.RemoveTail(s, 1)
 
 	oi := OrderId(s)
-	if oi.Market() == MarketError {
-		return errInvalidOrderId
-	}
-	if oi.Pair() == PairErr {
-		return errInvalidOrderId
-	}
-	if oi.StrId() == "" {
+	if oi.Verify() != nil {
 		return errInvalidOrderId
 	}
 
@@ -0,0 +1,44 @@
+package comm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOrderId_ClientId(t *testing.T) {
+	pair := NewPair("BTC", "USDT")
+	id := NewOrderIdWithClientId(MarketSpot, pair, "123", "my-order_1")
+	if id.Market() != MarketSpot || id.Pair() != pair || id.StrId() != "123" || id.ClientId() != "my-order_1" {
+		t.Errorf("invalid OrderId parsing of %s", id)
+	}
+	if err := id.Verify(); err != nil {
+		t.Error(err)
+	}
+	if NewOrderIdWithClientId(MarketSpot, pair, "123", "") != NewOrderId(MarketSpot, pair, "123") {
+		t.Errorf("empty client id should be omitted")
+	}
+	if NewOrderId(MarketSpot, pair, "123").ClientId() != "" {
+		t.Errorf("OrderId without client id should have empty ClientId")
+	}
+
+	var decoded OrderId
+	b, _ := json.Marshal(id)
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded != id {
+		t.Errorf("json round trip of %s failed, got %s, err %v", id, decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`"spot:BTC/USDT:123:bad id"`), &decoded); err == nil {
+		t.Errorf("invalid client id should be rejected")
+	}
+
+	if err := VerifyClientOrderId(NewClientOrderId()); err != nil {
+		t.Error(err)
+	}
+	if NewClientOrderId() == NewClientOrderId() {
+		t.Errorf("NewClientOrderId should be unique")
+	}
+	for _, bad := range []string{"", "a:b", "0123456789012345678901234567890123456789"} {
+		if VerifyClientOrderId(bad) == nil {
+			t.Errorf("client id(%s) should be invalid", bad)
+		}
+	}
+}
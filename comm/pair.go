@@ -290,7 +290,7 @@ func ParsePairExtString(s string) (Pair, *Period, *Market, *Platform, error) {
 		}
 
 		market, err := ParseMarket(ss[i])
-		if err != nil {
+		if err == nil {
 			if resMarket != nil { // 重复出现了，这是异常
 				return PairErr, nil, nil, nil, defErr
 			} else {
@@ -148,3 +148,29 @@ func TestMkPairAt(t *testing.T) {
 	fmt.Println(pairAt.String())
 }
 */
+
+func TestParsePairExt(t *testing.T) {
+	pe, err := ParsePairExt("BTC/USDT.1min.spot.binance")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pe.Pair() != NewPair("BTC", "USDT") || pe.Period() != Period1Min || pe.Market() != MarketSpot || pe.Platform() != Binance {
+		t.Errorf("ParsePairExt error1 %s", pe.String())
+		return
+	}
+	if !pe.Complete() {
+		t.Errorf("ParsePairExt error2 %s", pe.String())
+		return
+	}
+
+	pe = NewPair("BTC", "USDT").SetMarket(MarketMargin).SetPlatform(Binance)
+	if pe.Market() != MarketMargin || pe.Platform() != Binance || pe.HasPeriod() {
+		t.Errorf("SetPlatform error %s", pe.String())
+		return
+	}
+	if _, err := ParsePairExt("BTC/USDT.spot.margin"); err == nil {
+		t.Errorf("duplicated market should fail")
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package comm
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"github.com/shawnwyckoff/commpkg/dsa/jsons"
+	"time"
+)
+
+type (
+	PositionSide string
+
+	MarginMode string
+
+	// Position of future or perpetual swap contract
+	Position struct {
+		Market           Market
+		Pair             Pair
+		Side             PositionSide     // net position of one-way mode is long or short too
+		Amount           decimals.Decimal // unit amount, always positive, Side tells direction
+		EntryPrice       decimals.Decimal // average open price
+		MarkPrice        decimals.Decimal
+		LiquidationPrice decimals.Decimal // zero if never liquidated, like fully collateralized positions
+		Leverage         int
+		MarginMode       MarginMode
+		Margin           decimals.Decimal // initial margin held by position, in quote
+		UnrealizedProfit decimals.Decimal // in quote, by mark price
+		Time             time.Time        // last update time
+	}
+
+	// FundingRate of perpetual swap, positive rate means long pays short
+	FundingRate struct {
+		Pair Pair
+		Rate decimals.Decimal
+		Time time.Time // funding time, it is the next funding time of current rate which is predicted
+	}
+
+	// MarkPrice is used by exchange to compute unrealized profit and liquidation instead of last price
+	MarkPrice struct {
+		Market     Market
+		Pair       Pair
+		Price      decimals.Decimal
+		IndexPrice decimals.Decimal // average spot price of main exchanges
+		Time       time.Time
+	}
+)
+
+const (
+	PositionSideError PositionSide = ""
+	PositionSideLong  PositionSide = "long"
+	PositionSideShort PositionSide = "short"
+
+	MarginModeError    MarginMode = ""
+	MarginModeCross    MarginMode = "cross"    // all positions share margin balance
+	MarginModeIsolated MarginMode = "isolated" // each position has its own margin
+)
+
+func (ps PositionSide) String() string {
+	return string(ps)
+}
+
+func (ps PositionSide) Verify() error {
+	if ps != PositionSideLong && ps != PositionSideShort {
+		return errorz.Errorf("invalid PositionSide(%s)", ps)
+	}
+	return nil
+}
+
+func (mm MarginMode) String() string {
+	return string(mm)
+}
+
+func (mm MarginMode) Verify() error {
+	if mm != MarginModeCross && mm != MarginModeIsolated {
+		return errorz.Errorf("invalid MarginMode(%s)", mm)
+	}
+	return nil
+}
+
+// future and perpetual swap markets
+func (m Market) IsDerivatives() bool {
+	return m == MarketFuture || m == MarketPerp
+}
+
+// quote value by mark price
+func (p Position) Notional() decimals.Decimal {
+	return p.Amount.Mul(p.MarkPrice)
+}
+
+// unrealized profit by mark price, negative if loss
+func (p Position) Profit() decimals.Decimal {
+	diff := p.MarkPrice.Sub(p.EntryPrice)
+	if p.Side == PositionSideShort {
+		diff = p.EntryPrice.Sub(p.MarkPrice)
+	}
+	return diff.Mul(p.Amount)
+}
+
+func (p Position) Verify() error {
+	if !p.Market.IsDerivatives() {
+		return errorz.Errorf("invalid position market(%s)", p.Market)
+	}
+	if err := p.Pair.Verify(); err != nil {
+		return err
+	}
+	if err := p.Side.Verify(); err != nil {
+		return err
+	}
+	if err := p.MarginMode.Verify(); err != nil {
+		return err
+	}
+	if p.Amount.LessThan(decimals.Zero) {
+		return errorz.Errorf("invalid position amount(%s)", p.Amount.String())
+	}
+	if p.Leverage <= 0 {
+		return errorz.Errorf("invalid position leverage(%d)", p.Leverage)
+	}
+	return nil
+}
+
+func (p Position) String() string {
+	return jsons.MarshalStringDefault(p, false)
+}
@@ -0,0 +1,46 @@
+package comm
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"testing"
+)
+
+func TestPosition(t *testing.T) {
+	p := Position{
+		Market:     MarketPerp,
+		Pair:       NewPair("BTC", "USDT"),
+		Side:       PositionSideShort,
+		Amount:     decimals.NewFromInt(2),
+		EntryPrice: decimals.NewFromInt(100),
+		MarkPrice:  decimals.NewFromInt(90),
+		Leverage:   10,
+		MarginMode: MarginModeCross,
+	}
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+	if !p.Notional().EqualInt(180) {
+		t.Errorf("notional 180 expected, got %s", p.Notional().String())
+	}
+	if !p.Profit().EqualInt(20) {
+		t.Errorf("short profit 20 expected, got %s", p.Profit().String())
+	}
+	p.Side = PositionSideLong
+	if !p.Profit().EqualInt(-20) {
+		t.Errorf("long profit -20 expected, got %s", p.Profit().String())
+	}
+
+	p.Market = MarketSpot
+	if p.Verify() == nil {
+		t.Errorf("spot position should be invalid")
+	}
+	p.Market, p.MarginMode = MarketFuture, MarginModeError
+	if p.Verify() == nil {
+		t.Errorf("position without margin mode should be invalid")
+	}
+
+	id := NewOrderId(MarketPerp, p.Pair, "1")
+	if err := id.Verify(); err != nil || id.Market() != MarketPerp {
+		t.Errorf("perp OrderId should be valid, got %v", err)
+	}
+}
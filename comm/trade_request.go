@@ -0,0 +1,217 @@
+package comm
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+)
+
+type (
+	TradeSide string
+
+	// how order is triggered and executed
+	OrderType string
+
+	TimeInForce string
+
+	// TradeRequest describes an order beyond TradeTypeSide, like stop, take profit, trailing stop and OCO orders.
+	TradeRequest struct {
+		Market         Market
+		Pair           Pair
+		Side           TradeSide
+		Type           OrderType
+		Amount         decimals.Decimal // unit amount, always
+		Price          decimals.Decimal // limit price, limit maker leg of OCO
+		StopPrice      decimals.Decimal // trigger price, stop leg of OCO, optional activation price of trailing stop
+		StopLimitPrice decimals.Decimal // limit price of OCO stop leg, zero means stop market
+		TrailingDelta  decimals.Decimal // trailing stop callback ratio, 0.01 means 1%
+		TimeInForce    TimeInForce      // TimeInForceGTC if empty
+		ReduceOnly     bool             // derivatives only
+		ClientId       string           // optional, resubmission with the same client id never places another order
+	}
+
+	TradeOption func(req *TradeRequest)
+)
+
+const (
+	TradeSideError TradeSide = ""
+	TradeSideBuy   TradeSide = "buy"
+	TradeSideSell  TradeSide = "sell"
+
+	OrderTypeError            OrderType = ""
+	OrderTypeLimit            OrderType = "limit"
+	OrderTypeMarket           OrderType = "market"
+	OrderTypeStopLimit        OrderType = "stop-limit"
+	OrderTypeStopMarket       OrderType = "stop-market"
+	OrderTypeTakeProfitLimit  OrderType = "take-profit-limit"
+	OrderTypeTakeProfitMarket OrderType = "take-profit-market"
+	OrderTypeTrailingStop     OrderType = "trailing-stop"
+	OrderTypeOCO              OrderType = "oco" // one-cancels-the-other, a limit maker order and a stop order
+
+	TimeInForceGTC      TimeInForce = "gtc" // good till canceled
+	TimeInForceIOC      TimeInForce = "ioc" // immediate or cancel
+	TimeInForceFOK      TimeInForce = "fok" // fill or kill
+	TimeInForcePostOnly TimeInForce = "post-only"
+)
+
+// NewTradeRequest makes a basic limit or market request and applies options to it.
+func NewTradeRequest(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal, options ...TradeOption) TradeRequest {
+	r := TradeRequest{Market: market, Pair: target, Amount: amount, Price: price}
+	if t.IsBuy() {
+		r.Side = TradeSideBuy
+	} else if t.IsSell() {
+		r.Side = TradeSideSell
+	}
+	if t.IsLimit() {
+		r.Type = OrderTypeLimit
+	} else if t.IsMarket() {
+		r.Type = OrderTypeMarket
+	}
+	for _, option := range options {
+		option(&r)
+	}
+	return r
+}
+
+func WithTimeInForce(tif TimeInForce) TradeOption {
+	return func(req *TradeRequest) {
+		req.TimeInForce = tif
+	}
+}
+
+// clientId is made by NewClientOrderId usually
+func WithClientId(clientId string) TradeOption {
+	return func(req *TradeRequest) {
+		req.ClientId = clientId
+	}
+}
+
+func WithReduceOnly() TradeOption {
+	return func(req *TradeRequest) {
+		req.ReduceOnly = true
+	}
+}
+
+// turn a limit request into stop-limit or take-profit-limit, market request into stop-market or take-profit-market
+func WithStop(stopPrice decimals.Decimal, takeProfit bool) TradeOption {
+	return func(req *TradeRequest) {
+		req.StopPrice = stopPrice
+		switch {
+		case req.Type == OrderTypeLimit && takeProfit:
+			req.Type = OrderTypeTakeProfitLimit
+		case req.Type == OrderTypeLimit:
+			req.Type = OrderTypeStopLimit
+		case req.Type == OrderTypeMarket && takeProfit:
+			req.Type = OrderTypeTakeProfitMarket
+		case req.Type == OrderTypeMarket:
+			req.Type = OrderTypeStopMarket
+		}
+	}
+}
+
+// activationPrice is optional, zero means activated at once
+func WithTrailingStop(delta, activationPrice decimals.Decimal) TradeOption {
+	return func(req *TradeRequest) {
+		req.Type = OrderTypeTrailingStop
+		req.TrailingDelta = delta
+		req.StopPrice = activationPrice
+	}
+}
+
+// limit request price becomes the limit maker leg, stopLimitPrice zero means stop market leg
+func WithOCO(stopPrice, stopLimitPrice decimals.Decimal) TradeOption {
+	return func(req *TradeRequest) {
+		req.Type = OrderTypeOCO
+		req.StopPrice = stopPrice
+		req.StopLimitPrice = stopLimitPrice
+	}
+}
+
+func (ts TradeSide) String() string {
+	return string(ts)
+}
+
+func (ot OrderType) String() string {
+	return string(ot)
+}
+
+// limit price required
+func (ot OrderType) IsLimit() bool {
+	return ot == OrderTypeLimit || ot == OrderTypeStopLimit || ot == OrderTypeTakeProfitLimit || ot == OrderTypeOCO
+}
+
+// trigger price required
+func (ot OrderType) IsConditional() bool {
+	return ot == OrderTypeStopLimit || ot == OrderTypeStopMarket || ot == OrderTypeTakeProfitLimit ||
+		ot == OrderTypeTakeProfitMarket || ot == OrderTypeOCO
+}
+
+func (tif TimeInForce) String() string {
+	return string(tif)
+}
+
+func (r TradeRequest) Verify() error {
+	if err := r.Market.Verify(); err != nil {
+		return err
+	}
+	if err := r.Pair.Verify(); err != nil {
+		return err
+	}
+	if r.Side != TradeSideBuy && r.Side != TradeSideSell {
+		return errorz.Errorf("invalid TradeSide(%s)", r.Side)
+	}
+	if !r.Amount.IsPositive() {
+		return errorz.Errorf("invalid amount(%s)", r.Amount.String())
+	}
+	switch r.Type {
+	case OrderTypeLimit, OrderTypeMarket, OrderTypeStopLimit, OrderTypeStopMarket,
+		OrderTypeTakeProfitLimit, OrderTypeTakeProfitMarket, OrderTypeTrailingStop, OrderTypeOCO:
+	default:
+		return errorz.Errorf("invalid OrderType(%s)", r.Type)
+	}
+	if r.Type.IsLimit() && !r.Price.IsPositive() {
+		return errorz.Errorf("%s order requires positive price, got %s", r.Type, r.Price.String())
+	}
+	if r.Type.IsConditional() && !r.StopPrice.IsPositive() {
+		return errorz.Errorf("%s order requires positive stop price, got %s", r.Type, r.StopPrice.String())
+	}
+	if r.Type == OrderTypeTrailingStop && !r.TrailingDelta.IsPositive() {
+		return errorz.Errorf("trailing stop order requires positive trailing delta, got %s", r.TrailingDelta.String())
+	}
+	if r.ClientId != "" {
+		if err := VerifyClientOrderId(r.ClientId); err != nil {
+			return err
+		}
+	}
+	switch r.TimeInForce {
+	case "", TimeInForceGTC:
+	case TimeInForceIOC, TimeInForceFOK, TimeInForcePostOnly:
+		if !r.Type.IsLimit() {
+			return errorz.Errorf("time in force %s requires limit price, order type is %s", r.TimeInForce, r.Type)
+		}
+	default:
+		return errorz.Errorf("invalid TimeInForce(%s)", r.TimeInForce)
+	}
+	return nil
+}
+
+// basic request can be sent by Ex.Trade
+func (r TradeRequest) IsBasic() bool {
+	return (r.Type == OrderTypeLimit || r.Type == OrderTypeMarket) &&
+		(r.TimeInForce == "" || r.TimeInForce == TimeInForceGTC) && !r.ReduceOnly && r.ClientId == ""
+}
+
+// TradeTypeSide of limit and market requests, TradeTypeSideError for others
+func (r TradeRequest) TypeSide() TradeTypeSide {
+	switch {
+	case r.Type == OrderTypeLimit && r.Side == TradeSideBuy:
+		return TradeTypeSideLimitBuy
+	case r.Type == OrderTypeLimit && r.Side == TradeSideSell:
+		return TradeTypeSideLimitSell
+	case r.Type == OrderTypeMarket && r.Side == TradeSideBuy:
+		return TradeTypeSideMarketBuy
+	case r.Type == OrderTypeMarket && r.Side == TradeSideSell:
+		return TradeTypeSideMarketSell
+	default:
+		return TradeTypeSideError
+	}
+}
@@ -0,0 +1,39 @@
+package comm
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"testing"
+)
+
+func TestTradeRequest_Verify(t *testing.T) {
+	pair := NewPair("BTC", "USDT")
+	one := decimals.NewFromInt(1)
+
+	req := NewTradeRequest(MarketSpot, pair, TradeTypeSideLimitBuy, one, decimals.NewFromInt(100), WithStop(decimals.NewFromInt(90), false))
+	if req.Type != OrderTypeStopLimit || req.Side != TradeSideBuy || req.IsBasic() {
+		t.Errorf("stop limit request expected, got %+v", req)
+	}
+	if err := req.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	req = NewTradeRequest(MarketSpot, pair, TradeTypeSideMarketSell, one, decimals.Zero, WithStop(decimals.Zero, true))
+	if req.Type != OrderTypeTakeProfitMarket || req.Verify() == nil {
+		t.Errorf("take profit market without stop price should be invalid")
+	}
+
+	req = NewTradeRequest(MarketSpot, pair, TradeTypeSideMarketBuy, one, decimals.Zero, WithTimeInForce(TimeInForcePostOnly))
+	if req.Verify() == nil {
+		t.Errorf("post only market order should be invalid")
+	}
+
+	req = NewTradeRequest(MarketSpot, pair, TradeTypeSideLimitSell, one, decimals.NewFromInt(100), WithTimeInForce(TimeInForceGTC))
+	if !req.IsBasic() || req.TypeSide() != TradeTypeSideLimitSell {
+		t.Errorf("GTC limit sell should be basic")
+	}
+
+	req = NewTradeRequest(MarketSpot, pair, TradeTypeSideMarketSell, one, decimals.Zero, WithTrailingStop(decimals.NewFromFloat64(0.01), decimals.Zero))
+	if err := req.Verify(); err != nil || req.TypeSide() != TradeTypeSideError {
+		t.Errorf("valid trailing stop expected, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package comm
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"github.com/shawnwyckoff/commpkg/dsa/jsons"
+	"time"
+)
+
+type (
+	TransferKind string
+
+	TransferStatus string
+
+	// DepositAddress is where to send asset on a network, also used as withdrawal destination
+	DepositAddress struct {
+		Asset   string
+		Network string // chain, like "ETH", "BSC", "TRX", empty means default network of asset
+		Address string
+		Tag     string // memo required by some networks, like XRP and EOS
+	}
+
+	// AssetTransfer is a movement of asset which is not a trade, like deposit, withdrawal, or transfer between markets
+	AssetTransfer struct {
+		Id      string
+		Kind    TransferKind
+		Asset   string
+		Amount  decimals.Decimal // without fee
+		Fee     decimals.Decimal // withdrawal fee, in Asset
+		Status  TransferStatus
+		Time    time.Time
+		Network string // deposit and withdrawal only
+		Address string // deposit and withdrawal only
+		Tag     string // deposit and withdrawal only
+		TxHash  string // deposit and withdrawal only, empty before broadcast
+		From    Market // internal transfer only
+		To      Market // internal transfer only
+	}
+)
+
+const (
+	TransferKindError      TransferKind = ""
+	TransferKindDeposit    TransferKind = "deposit"
+	TransferKindWithdrawal TransferKind = "withdrawal"
+	TransferKindInternal   TransferKind = "internal" // between markets of the same account
+
+	TransferStatusError    TransferStatus = ""
+	TransferStatusPending  TransferStatus = "pending" // waiting for approval, broadcast or confirmations
+	TransferStatusSuccess  TransferStatus = "success"
+	TransferStatusFailed   TransferStatus = "failed"
+	TransferStatusCanceled TransferStatus = "canceled"
+)
+
+func (tk TransferKind) String() string {
+	return string(tk)
+}
+
+func (ts TransferStatus) String() string {
+	return string(ts)
+}
+
+func (ts TransferStatus) End() bool {
+	return ts == TransferStatusSuccess || ts == TransferStatusFailed || ts == TransferStatusCanceled
+}
+
+func (da DepositAddress) Verify() error {
+	if da.Asset == "" {
+		return errorz.Errorf("empty asset of deposit address")
+	}
+	if da.Address == "" {
+		return errorz.Errorf("empty address of asset(%s)", da.Asset)
+	}
+	return nil
+}
+
+func (at AssetTransfer) String() string {
+	return jsons.MarshalStringDefault(at, false)
+}
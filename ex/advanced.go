@@ -0,0 +1,47 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+)
+
+type (
+	// AdvancedTrader is implemented by Ex which accepts TradeRequest, like stop, trailing stop and OCO orders,
+	// time in force and reduce only flags. Requests it can't place are rejected with ErrFunctionNotSupported.
+	// If client id of req is taken by an existing order, id of that order is returned and nothing is placed.
+	AdvancedTrader interface {
+		TradeWithOptions(req TradeRequest) (*OrderId, error)
+	}
+)
+
+// TradeWithOptions places req by e if e is an AdvancedTrader, basic requests fall back to Ex.Trade,
+// others are rejected with ErrFunctionNotSupported.
+func TradeWithOptions(e Ex, req TradeRequest) (*OrderId, error) {
+	if err := req.Verify(); err != nil {
+		return nil, err
+	}
+	if at, ok := e.(AdvancedTrader); ok {
+		return at.TradeWithOptions(req)
+	}
+	if req.IsBasic() {
+		return e.Trade(req.Market, req.Pair, req.TypeSide(), req.Amount, req.Price)
+	}
+	return nil, NewUnsupportedRequestError(e, req)
+}
+
+// ErrFunctionNotSupported kind error which tells what in req is not supported by e
+func NewUnsupportedRequestError(e Ex, req TradeRequest) error {
+	name := "exchange"
+	if e.Config() != nil {
+		name = e.Config().Name.String()
+	}
+	switch {
+	case req.ClientId != "":
+		return NewExError(ErrorKindNotSupported, "%s doesn't support client order id", name)
+	case req.ReduceOnly:
+		return NewExError(ErrorKindNotSupported, "%s doesn't support reduce only %s order", name, req.Type)
+	case req.TimeInForce != "" && req.TimeInForce != TimeInForceGTC:
+		return NewExError(ErrorKindNotSupported, "%s doesn't support %s order with time in force %s", name, req.Type, req.TimeInForce)
+	default:
+		return NewExError(ErrorKindNotSupported, "%s doesn't support %s order", name, req.Type)
+	}
+}
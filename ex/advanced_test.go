@@ -0,0 +1,61 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func TestTradeWithOptions_Fallback(t *testing.T) {
+	inner := &flakyEx{fakeEx: newFakeEx()}
+	if _, err := TradeWithOptions(inner, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99))); err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls != 1 {
+		t.Fatal("basic request should be sent by Trade")
+	}
+	req := NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99), WithStop(decimals.NewFromInt(98), false))
+	if _, err := TradeWithOptions(inner, req); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}
+
+func TestPaperEx_TimeInForce(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	// decorators keep advanced trading available
+	r, _ := newTestRetryEx(t, p, RetryOption{})
+
+	// sells: 101 x 1, 102 x 2
+	id, err := TradeWithOptions(r, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(2), decimals.NewFromInt(101), WithTimeInForce(TimeInForceIOC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ := p.GetOrder(*id)
+	if order.Status != TradeStatusCanceled || !order.DealAmount.Equal(decimals.One) {
+		t.Fatalf("IOC should fill 1 and cancel the rest, got %s", order.String())
+	}
+
+	id, err = TradeWithOptions(r, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(4), decimals.NewFromInt(102), WithTimeInForce(TimeInForceFOK)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ = p.GetOrder(*id)
+	if order.Status != TradeStatusExpired || order.DealAmount.IsPositive() {
+		t.Fatalf("FOK should be killed, got %s", order.String())
+	}
+	acc, _ := p.GetAccount()
+	if acc.Spot["USDT"].Locked.IsPositive() {
+		t.Fatalf("nothing should be locked, got %s", acc.Spot["USDT"].Locked.String())
+	}
+
+	if _, err := TradeWithOptions(r, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(102), WithTimeInForce(TimeInForcePostOnly))); err == nil {
+		t.Fatal("crossing post only order should be rejected")
+	}
+	if _, err := TradeWithOptions(r, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(95), WithTimeInForce(TimeInForcePostOnly))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TradeWithOptions(r, NewTradeRequest(MarketSpot, testPair, TradeTypeSideMarketSell, decimals.One, decimals.Zero, WithReduceOnly())); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+// Package algo executes large orders by slicing them over time, like TWAP, VWAP and iceberg.
+package algo
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval = time.Second
+)
+
+type (
+	// Parent is an order too large to be sent at once
+	Parent struct {
+		Market Market
+		Pair   Pair
+		Side   TradeTypeSide    // only buy or sell of it matters, type of child orders depends on Price
+		Amount decimals.Decimal // unit amount
+		Price  decimals.Decimal // limit price of child orders, zero means market orders, required by iceberg
+	}
+
+	// Child is an order placed for a slice of parent
+	Child struct {
+		Slice int   // index of slice, or index of clip in iceberg
+		Order Order // last state got by GetOrder
+	}
+
+	Report struct {
+		Parent     Parent
+		Children   []Child
+		DealAmount decimals.Decimal
+		AvgPrice   decimals.Decimal // volume weighted by children, zero if nothing filled
+		Fee        decimals.Decimal
+	}
+
+	Option struct {
+		PollInterval time.Duration // interval of GetOrder on an open child order, ExConfig.RateLimit by default
+	}
+
+	// Executor places child orders of a parent order one by one and tracks them by GetOrder.
+	// It runs one parent order at a time, Stop cancels the open child order and ends the run.
+	Executor struct {
+		e        ex.Ex
+		option   Option
+		now      func() time.Time
+		sleep    func(time.Duration)
+		stop     chan struct{}
+		stopOnce sync.Once
+		mu       sync.Mutex
+		running  bool
+		report   Report
+	}
+)
+
+var (
+	ErrStopped = errorz.Errorf("execution stopped")
+)
+
+func NewExecutor(e ex.Ex, option Option) (*Executor, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to execute orders")
+	}
+	if option.PollInterval <= 0 {
+		option.PollInterval = defaultPollInterval
+		if e.Config().RateLimit > 0 {
+			option.PollInterval = e.Config().RateLimit
+		}
+	}
+	x := &Executor{e: e, option: option, now: time.Now, stop: make(chan struct{})}
+	if c := e.Config().Clock; c != nil {
+		x.now = c.Now
+	}
+	x.sleep = func(d time.Duration) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-x.stop:
+		}
+	}
+	return x, nil
+}
+
+// TWAP places count child orders of equal amount evenly in duration from now
+func (x *Executor) TWAP(parent Parent, duration time.Duration, count int) (*Report, error) {
+	schedule, err := TWAPSchedule(parent.Amount, x.now(), duration, count)
+	if err != nil {
+		return nil, err
+	}
+	return x.Execute(parent, schedule)
+}
+
+// VWAP places a child order every period of profile in duration from now, weighted by volume of profile
+func (x *Executor) VWAP(parent Parent, duration time.Duration, profile *Kline) (*Report, error) {
+	schedule, err := VWAPSchedule(parent.Amount, x.now(), duration, profile)
+	if err != nil {
+		return nil, err
+	}
+	return x.Execute(parent, schedule)
+}
+
+// Execute places a child order at time of each slice.
+// Amount of child is what the schedule requires till the slice minus what is filled, rounded by lot of pair,
+// so amounts unfilled or truncated are carried to later slices. Open limit child order is canceled when its slice
+// ends, the run ends with what is filled after the last slice. A slice without Duration lasts until the next one,
+// the child of the last slice is waited until it ends then.
+func (x *Executor) Execute(parent Parent, schedule []Slice) (*Report, error) {
+	info, err := x.begin(parent)
+	if err != nil {
+		return nil, err
+	}
+	defer x.end()
+
+	planned := decimals.Zero
+	for i, slice := range schedule {
+		planned = planned.Add(slice.Amount)
+		if wait := slice.Time.Sub(x.now()); wait > 0 && !x.wait(wait) {
+			return x.Progress(), ErrStopped
+		}
+		amount := info.RoundAmount(decimals.Min(planned, parent.Amount).Sub(x.dealt()))
+		if !amount.IsPositive() || amount.LessThan(info.LotMin) {
+			continue
+		}
+		deadline := time.Time{}
+		if slice.Duration > 0 {
+			deadline = slice.Time.Add(slice.Duration)
+		} else if i+1 < len(schedule) {
+			deadline = schedule[i+1].Time
+		}
+		if _, err := x.child(i, parent, info, amount, deadline); err != nil {
+			return x.Progress(), err
+		}
+	}
+	return x.Progress(), nil
+}
+
+// Iceberg shows at most clip of parent in book by limit child orders, the next one is placed after the last one filled
+func (x *Executor) Iceberg(parent Parent, clip decimals.Decimal) (*Report, error) {
+	if !parent.Price.IsPositive() {
+		return nil, errorz.Errorf("iceberg requires limit price")
+	}
+	if !clip.IsPositive() {
+		return nil, errorz.Errorf("invalid iceberg clip(%s)", clip.String())
+	}
+	info, err := x.begin(parent)
+	if err != nil {
+		return nil, err
+	}
+	defer x.end()
+
+	for i := 0; ; i++ {
+		amount := info.RoundAmount(decimals.Min(clip, parent.Amount.Sub(x.dealt())))
+		if !amount.IsPositive() || amount.LessThan(info.LotMin) {
+			return x.Progress(), nil
+		}
+		order, err := x.child(i, parent, info, amount, time.Time{})
+		if err != nil {
+			return x.Progress(), err
+		}
+		if order.Status != TradeStatusFilled {
+			return x.Progress(), errorz.Errorf("iceberg child order(%s) ended as %s", order.Id, order.Status)
+		}
+	}
+}
+
+// Stop cancels the open child order and ends the run, later runs return ErrStopped at once
+func (x *Executor) Stop() {
+	x.stopOnce.Do(func() { close(x.stop) })
+}
+
+// Progress of the current or the last run
+func (x *Executor) Progress() *Report {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	r := x.report
+	r.Children = append([]Child(nil), x.report.Children...)
+	return &r
+}
+
+func (x *Executor) begin(parent Parent) (*PairInfo, error) {
+	if err := parent.Side.Verify(); err != nil {
+		return nil, err
+	}
+	if !parent.Amount.IsPositive() {
+		return nil, errorz.Errorf("invalid parent amount(%s)", parent.Amount.String())
+	}
+	mi, err := x.e.GetMarketInfo()
+	if err != nil {
+		return nil, err
+	}
+	info, ok := mi.Infos[parent.Pair.SetMarket(parent.Market)]
+	if !ok || !info.Enabled {
+		return nil, NewExError(ErrorKindMarketClosed, "%s not tradable in %s market", parent.Pair.String(), parent.Market)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.running {
+		return nil, errorz.Errorf("executor is running another parent order")
+	}
+	x.running = true
+	x.report = Report{Parent: parent, DealAmount: decimals.Zero, AvgPrice: decimals.Zero, Fee: decimals.Zero}
+	return &info, nil
+}
+
+func (x *Executor) end() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.running = false
+}
+
+// place a child order and poll it until it ends, it is canceled at deadline unless deadline is zero
+func (x *Executor) child(slice int, parent Parent, info *PairInfo, amount decimals.Decimal, deadline time.Time) (*Order, error) {
+	t, price := TradeTypeSideMarketBuy, decimals.Zero
+	if parent.Side.IsSell() {
+		t = TradeTypeSideMarketSell
+	}
+	if parent.Price.IsPositive() {
+		t, price = TradeTypeSideLimitBuy, info.RoundPrice(parent.Price)
+		if parent.Side.IsSell() {
+			t = TradeTypeSideLimitSell
+		}
+	}
+	id, err := x.e.Trade(parent.Market, parent.Pair, t, amount, price)
+	if err != nil {
+		return nil, err
+	}
+	idx := x.add(Child{Slice: slice, Order: Order{Id: *id, Pair: parent.Pair, TypeSide: t, Price: price, Amount: amount, Status: TradeStatusNew, DealAmount: decimals.Zero}})
+
+	for {
+		order, err := x.e.GetOrder(*id)
+		if err != nil {
+			return nil, err
+		}
+		x.update(idx, *order)
+		if order.Status.End() {
+			return order, nil
+		}
+		wait := x.option.PollInterval
+		if !deadline.IsZero() {
+			left := deadline.Sub(x.now())
+			if left <= 0 {
+				return x.cancel(idx, *id)
+			}
+			if left < wait {
+				wait = left
+			}
+		}
+		if !x.wait(wait) {
+			if _, err := x.cancel(idx, *id); err != nil {
+				return nil, err
+			}
+			return nil, ErrStopped
+		}
+	}
+}
+
+// cancel open child order and get its final state
+func (x *Executor) cancel(idx int, id OrderId) (*Order, error) {
+	cancelErr := x.e.CancelOrder(id)
+	order, err := x.e.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+	x.update(idx, *order)
+	// it may be filled just before canceled
+	if cancelErr != nil && !order.Status.End() {
+		return nil, cancelErr
+	}
+	return order, nil
+}
+
+// false if stopped
+func (x *Executor) wait(d time.Duration) bool {
+	select {
+	case <-x.stop:
+		return false
+	default:
+	}
+	x.sleep(d)
+	select {
+	case <-x.stop:
+		return false
+	default:
+		return true
+	}
+}
+
+func (x *Executor) add(child Child) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.report.Children = append(x.report.Children, child)
+	return len(x.report.Children) - 1
+}
+
+// update child and totals of report
+func (x *Executor) update(idx int, order Order) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.report.Children[idx].Order = order
+	deal, quote, fee := decimals.Zero, decimals.Zero, decimals.Zero
+	for _, child := range x.report.Children {
+		if !child.Order.DealAmount.IsPositive() {
+			continue
+		}
+		price := child.Order.AvgPrice
+		if !price.IsPositive() {
+			price = child.Order.Price
+		}
+		deal = deal.Add(child.Order.DealAmount)
+		quote = quote.Add(child.Order.DealAmount.Mul(price))
+		fee = fee.Add(child.Order.Fee)
+	}
+	x.report.DealAmount, x.report.Fee, x.report.AvgPrice = deal, fee, decimals.Zero
+	if deal.IsPositive() {
+		x.report.AvgPrice = quote.Div(deal)
+	}
+}
+
+func (x *Executor) dealt() decimals.Decimal {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.report.DealAmount
+}
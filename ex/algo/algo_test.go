@@ -0,0 +1,179 @@
+package algo
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type (
+	// market orders are filled at once, limit orders are filled by step on every GetOrder
+	testEx struct {
+		ex.Ex
+		config ExConfig
+		info   MarketInfo
+		step   decimals.Decimal
+		orders map[OrderId]*Order
+	}
+)
+
+var testPair = NewPair("BTC", "USDT")
+
+func newTestExecutor(t *testing.T, step float64) (*Executor, *testEx, *time.Time) {
+	e := &testEx{
+		config: ExConfig{Name: Binance},
+		info:   MarketInfo{Infos: map[PairExt]PairInfo{}},
+		step:   decimals.NewFromFloat64(step),
+		orders: map[OrderId]*Order{},
+	}
+	e.info.Infos[testPair.SetMarket(MarketSpot)] = PairInfo{
+		Enabled:        true,
+		UnitPrecision:  4,
+		QuotePrecision: 2,
+		LotMin:         decimals.NewFromFloat64(0.001),
+		LotStep:        decimals.NewFromFloat64(0.001),
+	}
+	x, err := NewExecutor(e, Option{PollInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	x.now = func() time.Time { return now }
+	x.sleep = func(d time.Duration) { now = now.Add(d) }
+	return x, e, &now
+}
+
+func (e *testEx) Config() *ExConfig { return &e.config }
+
+func (e *testEx) GetMarketInfo() (*MarketInfo, error) { return &e.info, nil }
+
+func (e *testEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	id := NewOrderId(market, target, strconv.Itoa(len(e.orders)+1))
+	order := &Order{Id: id, Pair: target, TypeSide: t, Price: price, Amount: amount, Status: TradeStatusNew, DealAmount: decimals.Zero, Fee: decimals.Zero}
+	if t.IsMarket() {
+		order.Status, order.DealAmount, order.AvgPrice = TradeStatusFilled, amount, decimals.NewFromInt(100)
+	}
+	e.orders[id] = order
+	return &id, nil
+}
+
+func (e *testEx) GetOrder(id OrderId) (*Order, error) {
+	order, ok := e.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if !order.Status.End() {
+		order.DealAmount = decimals.Min(order.Amount, order.DealAmount.Add(e.step))
+		order.AvgPrice = order.Price
+		order.Status = TradeStatusPartiallyFilled
+		if order.DealAmount.Equal(order.Amount) {
+			order.Status = TradeStatusFilled
+		}
+	}
+	r := *order
+	return &r, nil
+}
+
+func (e *testEx) CancelOrder(id OrderId) error {
+	order, ok := e.orders[id]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	if order.Status.End() {
+		return NewExError(ErrorKindUnknown, "order(%s) already %s", id, order.Status)
+	}
+	order.Status = TradeStatusCanceled
+	return nil
+}
+
+func TestExecutor_TWAP(t *testing.T) {
+	x, _, now := newTestExecutor(t, 0)
+	begin := *now
+	parent := Parent{Market: MarketSpot, Pair: testPair, Side: TradeTypeSideMarketSell, Amount: decimals.One}
+	r, err := x.TWAP(parent, time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Children) != 3 || !r.DealAmount.EqualInt(1) || !r.AvgPrice.EqualInt(100) {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	// 1/3 is truncated by lot step, the last child takes the rest
+	for i, amount := range []float64{0.333, 0.333, 0.334} {
+		order := r.Children[i].Order
+		if !order.Amount.Equal(decimals.NewFromFloat64(amount)) || order.TypeSide != TradeTypeSideMarketSell {
+			t.Fatalf("unexpected child %d %s", i, order.String())
+		}
+	}
+	if !now.Equal(begin.Add(40 * time.Minute)) {
+		t.Fatalf("last child placed at %s", now)
+	}
+}
+
+func TestExecutor_CarryUnfilled(t *testing.T) {
+	x, _, now := newTestExecutor(t, 0.1)
+	begin := *now
+	parent := Parent{Market: MarketSpot, Pair: testPair, Side: TradeTypeSideLimitBuy, Amount: decimals.One, Price: decimals.NewFromInt(99)}
+	schedule, err := TWAPSchedule(parent.Amount, begin, 6*time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := x.Execute(parent, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.3 of 0.333 and 0.3 of 0.366 filled before next slices, the last child takes 0.4 and is canceled at the end
+	expected := []struct {
+		amount, deal float64
+		status       TradeStatus
+	}{{0.333, 0.3, TradeStatusCanceled}, {0.366, 0.3, TradeStatusCanceled}, {0.4, 0.3, TradeStatusCanceled}}
+	if len(r.Children) != len(expected) || !r.DealAmount.Equal(decimals.NewFromFloat64(0.9)) || !r.AvgPrice.EqualInt(99) {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	for i, v := range expected {
+		order := r.Children[i].Order
+		if !order.Amount.Equal(decimals.NewFromFloat64(v.amount)) || !order.DealAmount.Equal(decimals.NewFromFloat64(v.deal)) || order.Status != v.status {
+			t.Fatalf("unexpected child %d %s", i, order.String())
+		}
+	}
+	if !now.Equal(begin.Add(6 * time.Minute)) {
+		t.Fatalf("finished at %s, end of duration expected", now)
+	}
+}
+
+func TestExecutor_Iceberg(t *testing.T) {
+	x, _, _ := newTestExecutor(t, 0.2)
+	parent := Parent{Market: MarketSpot, Pair: testPair, Side: TradeTypeSideLimitSell, Amount: decimals.One, Price: decimals.NewFromFloat64(101.005)}
+	if _, err := x.Iceberg(Parent{Market: MarketSpot, Pair: testPair, Side: TradeTypeSideLimitSell, Amount: decimals.One}, decimals.One); err == nil {
+		t.Fatal("iceberg without price accepted")
+	}
+	r, err := x.Iceberg(parent, decimals.NewFromFloat64(0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Children) != 4 || !r.DealAmount.EqualInt(1) {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	for i, amount := range []float64{0.3, 0.3, 0.3, 0.1} {
+		order := r.Children[i].Order
+		if !order.Amount.Equal(decimals.NewFromFloat64(amount)) || !order.Price.Equal(decimals.NewFromFloat64(101)) ||
+			order.TypeSide != TradeTypeSideLimitSell || order.Status != TradeStatusFilled {
+			t.Fatalf("unexpected child %d %s", i, order.String())
+		}
+	}
+}
+
+func TestExecutor_Stop(t *testing.T) {
+	x, _, _ := newTestExecutor(t, 0)
+	x.Stop()
+	parent := Parent{Market: MarketSpot, Pair: testPair, Side: TradeTypeSideMarketBuy, Amount: decimals.One}
+	r, err := x.TWAP(parent, time.Hour, 2)
+	if err != ErrStopped {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(r.Children) != 1 || !r.DealAmount.Equal(decimals.NewFromFloat64(0.5)) {
+		t.Fatalf("unexpected report %+v", r)
+	}
+}
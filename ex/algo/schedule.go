@@ -0,0 +1,116 @@
+package algo
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"time"
+)
+
+const (
+	day = 24 * time.Hour
+)
+
+type (
+	// Slice is a part of parent order to be placed at Time
+	Slice struct {
+		Time     time.Time
+		Duration time.Duration    // open child order is canceled after it, zero means until the next slice
+		Amount   decimals.Decimal // unit amount, before rounded by lot
+	}
+)
+
+// TWAPSchedule splits amount into count equal slices spaced evenly in [begin, begin+duration)
+func TWAPSchedule(amount decimals.Decimal, begin time.Time, duration time.Duration, count int) ([]Slice, error) {
+	if !amount.IsPositive() {
+		return nil, errorz.Errorf("invalid parent amount(%s)", amount.String())
+	}
+	if duration <= 0 || count <= 0 {
+		return nil, errorz.Errorf("invalid TWAP duration(%s) or slices(%d)", duration, count)
+	}
+	weights := make([]decimals.Decimal, count)
+	times := make([]time.Time, count)
+	for i := range weights {
+		weights[i] = decimals.One
+		times[i] = begin.Add(duration / time.Duration(count) * time.Duration(i))
+	}
+	return weighted(amount, times, weights, begin.Add(duration)), nil
+}
+
+// VWAPSchedule places one slice every period of profile in [begin, begin+duration),
+// each slice is weighted by average volume of profile at the same time of day (UTC).
+// It is the same as TWAP if profile has no volume at those times.
+func VWAPSchedule(amount decimals.Decimal, begin time.Time, duration time.Duration, profile *Kline) ([]Slice, error) {
+	if !amount.IsPositive() {
+		return nil, errorz.Errorf("invalid parent amount(%s)", amount.String())
+	}
+	if profile == nil || len(profile.Items) == 0 {
+		return nil, errorz.Errorf("empty volume profile")
+	}
+	period := profile.Period.ToDuration()
+	if period <= 0 || period > day {
+		return nil, errorz.Errorf("invalid period(%s) of volume profile, (0, 1d] required", profile.Period)
+	}
+	if duration < period {
+		return nil, errorz.Errorf("duration(%s) shorter than period(%s) of volume profile", duration, profile.Period)
+	}
+
+	sums := map[time.Duration]decimals.Decimal{}
+	counts := map[time.Duration]int64{}
+	for _, dot := range profile.Items {
+		key := timeOfDay(dot.Time, period)
+		if _, ok := sums[key]; !ok {
+			sums[key] = decimals.Zero
+		}
+		sums[key] = sums[key].Add(dot.Volume)
+		counts[key]++
+	}
+
+	var times []time.Time
+	var weights []decimals.Decimal
+	total := decimals.Zero
+	for t := begin; t.Before(begin.Add(duration)); t = t.Add(period) {
+		w := decimals.Zero
+		if n, ok := counts[timeOfDay(t, period)]; ok {
+			w = sums[timeOfDay(t, period)].Div(decimals.NewFromInt(n))
+		}
+		times = append(times, t)
+		weights = append(weights, w)
+		total = total.Add(w)
+	}
+	if !total.IsPositive() {
+		for i := range weights {
+			weights[i] = decimals.One
+		}
+	}
+	return weighted(amount, times, weights, begin.Add(duration)), nil
+}
+
+// offset in day of time, truncated by period
+func timeOfDay(t time.Time, period time.Duration) time.Duration {
+	t = t.UTC()
+	return t.Sub(t.Truncate(day)).Truncate(period)
+}
+
+// amount split by weights, the last slice takes what is left by division.
+// Every slice lasts until the next one, the last one until end.
+func weighted(amount decimals.Decimal, times []time.Time, weights []decimals.Decimal, end time.Time) []Slice {
+	total := decimals.Zero
+	for _, w := range weights {
+		total = total.Add(w)
+	}
+	r := make([]Slice, len(times))
+	left := amount
+	for i := range times {
+		r[i].Time = times[i]
+		if i == len(times)-1 {
+			r[i].Duration = end.Sub(times[i])
+			r[i].Amount = left
+			break
+		}
+		r[i].Duration = times[i+1].Sub(times[i])
+		r[i].Amount = amount.Mul(weights[i]).Div(total)
+		left = left.Sub(r[i].Amount)
+	}
+	return r
+}
@@ -0,0 +1,65 @@
+package algo
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"testing"
+	"time"
+)
+
+func TestTWAPSchedule(t *testing.T) {
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	slices, err := TWAPSchedule(decimals.NewFromInt(10), begin, time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := decimals.Zero
+	for i, s := range slices {
+		if !s.Time.Equal(begin.Add(time.Duration(i)*20*time.Minute)) || s.Duration != 20*time.Minute {
+			t.Fatalf("unexpected time of slice %d: %s for %s", i, s.Time, s.Duration)
+		}
+		sum = sum.Add(s.Amount)
+	}
+	if len(slices) != 3 || !sum.EqualInt(10) {
+		t.Fatalf("unexpected slices %+v", slices)
+	}
+	if _, err := TWAPSchedule(decimals.NewFromInt(10), begin, time.Hour, 0); err == nil {
+		t.Fatal("zero slices accepted")
+	}
+}
+
+func TestVWAPSchedule(t *testing.T) {
+	begin := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	profile := &Kline{Period: Period1Hour}
+	for d := 1; d <= 2; d++ {
+		day := time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+		profile.Items = append(profile.Items,
+			KDot{Time: day, Volume: decimals.NewFromInt(int64(d))},
+			KDot{Time: day.Add(time.Hour), Volume: decimals.NewFromInt(int64(3 * d))},
+		)
+	}
+
+	// average volume of hour 0 is 1.5, hour 1 is 4.5
+	slices, err := VWAPSchedule(decimals.NewFromInt(10), begin, 2*time.Hour, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slices) != 2 || !slices[0].Amount.Equal(decimals.NewFromFloat64(2.5)) || !slices[1].Amount.Equal(decimals.NewFromFloat64(7.5)) ||
+		!slices[1].Time.Equal(begin.Add(time.Hour)) {
+		t.Fatalf("unexpected slices %+v", slices)
+	}
+
+	// no volume at those hours
+	slices, err = VWAPSchedule(decimals.NewFromInt(10), begin.Add(5*time.Hour), 2*time.Hour, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slices) != 2 || !slices[0].Amount.EqualInt(5) || !slices[1].Amount.EqualInt(5) {
+		t.Fatalf("unexpected slices %+v", slices)
+	}
+
+	if _, err := VWAPSchedule(decimals.NewFromInt(10), begin, 30*time.Minute, profile); err == nil {
+		t.Fatal("duration shorter than period accepted")
+	}
+}
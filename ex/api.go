@@ -15,6 +15,9 @@ type (
 		// exchange custom settings
 		Config() *ExConfig
 
+		// methods, markets, order types, periods, depth and batch limits supported, see DefaultCapabilities
+		Capabilities() Capabilities
+
 		// get all supported pairs, min trade amount...
 		GetMarketInfo() (*MarketInfo, error)
 
@@ -65,9 +68,17 @@ type (
 )
 
 // email is required in living trading, but not required in kline spider
+// exchange adapter must be registered by RegisterEx before
 func NewEx(name Platform, apiKey, apiSecret, proxy string, c clock.Clock, email string) (Ex, error) {
-	switch name {
-	default:
+	registryMu.RLock()
+	reg, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
 		return nil, errorz.Errorf("unsupported exchange(%s)", name.String())
 	}
+
+	config := reg.config.Clone()
+	config.Email = email
+	config.Clock = c
+	return reg.factory(config, apiKey, apiSecret, proxy)
 }
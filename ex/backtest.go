@@ -0,0 +1,488 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	FillModelTouch  FillModel = "touch"  // limit order fills at its price once a bar touches it, market order fills at open of next bar
+	FillModelClose  FillModel = "close"  // orders fill at close of next bar, limit orders only if close price is acceptable
+	FillModelVolume FillModel = "volume" // like touch, but each bar fills at most Participation of its unit volume
+
+	defaultBacktestKlineLimit = 1000
+	defaultBacktestFillLimit  = 1000
+)
+
+type (
+	FillModel string
+
+	BacktestOption struct {
+		Model         FillModel
+		Participation decimals.Decimal // max ratio of bar unit volume taken by one order, FillModelVolume only
+		KlineLimit    int              // max dots returned by one GetKline call, like real exchanges
+	}
+
+	// replay unit, a kline dot or a single fill
+	replayBar struct {
+		Time       time.Time // open time
+		End        time.Time // close time, visible since then
+		Open       decimals.Decimal
+		High       decimals.Decimal
+		Low        decimals.Decimal
+		Close      decimals.Decimal
+		UnitVolume decimals.Decimal
+	}
+
+	replayKey struct {
+		market Market
+		pair   Pair
+		period Period // PeriodError for fills
+	}
+
+	// BacktestEx replays stored klines and fills by ExConfig.Clock.
+	// Nothing later than current simulated time is visible, no matter which method is called.
+	// Orders are matched when any method is called, against bars closed after the order was placed.
+	BacktestEx struct {
+		config     *ExConfig
+		option     BacktestOption
+		mu         sync.Mutex
+		ledger     *localLedger
+		marketInfo *MarketInfo
+		bars       map[replayKey][]replayBar
+		fills      map[replayKey][]Fill
+		fillBars   map[replayKey][]replayBar // a bar of every fill
+		matched    map[OrderId]int           // index of the next match bar of order
+	}
+)
+
+func (fm FillModel) Verify() error {
+	if fm != FillModelTouch && fm != FillModelClose && fm != FillModelVolume {
+		return errorz.Errorf("invalid FillModel(%s)", string(fm))
+	}
+	return nil
+}
+
+// config: IsBackTestEx must be true and Clock is required, it drives the replay
+func NewBacktestEx(config *ExConfig, marketInfo *MarketInfo, initAccount *Account, option BacktestOption) (*BacktestEx, error) {
+	if config == nil || !config.IsBackTestEx {
+		return nil, errorz.Errorf("config is not for back test exchange")
+	}
+	if config.Clock == nil {
+		return nil, errorz.Errorf("nil clock in back test config")
+	}
+	if marketInfo == nil {
+		return nil, errorz.Errorf("nil market info")
+	}
+	if err := option.Model.Verify(); err != nil {
+		return nil, err
+	}
+	if option.Model == FillModelVolume && !option.Participation.IsPositive() {
+		return nil, errorz.Errorf("invalid participation(%s)", option.Participation.String())
+	}
+	if option.KlineLimit <= 0 {
+		option.KlineLimit = defaultBacktestKlineLimit
+	}
+	return &BacktestEx{
+		config:     config,
+		option:     option,
+		ledger:     newLocalLedger(config, initAccount),
+		marketInfo: marketInfo,
+		bars:       map[replayKey][]replayBar{},
+		fills:      map[replayKey][]Fill{},
+		fillBars:   map[replayKey][]replayBar{},
+		matched:    map[OrderId]int{},
+	}, nil
+}
+
+// load kline history to replay, k.Period is required
+func (b *BacktestEx) AddKline(market Market, target Pair, k *Kline) error {
+	if k == nil || k.Period.ToSeconds() <= 0 {
+		return errorz.Errorf("invalid kline to replay")
+	}
+	var bars []replayBar
+	for _, dot := range k.Items {
+		unitVolume := decimals.Zero
+		if dot.Close.IsPositive() {
+			unitVolume = dot.Volume.Div(dot.Close) // KDot volume is in quote
+		}
+		bars = append(bars, replayBar{
+			Time:       dot.Time,
+			End:        dot.Time.Add(k.Period.ToDurationExact(dot.Time, time.UTC)),
+			Open:       dot.Open,
+			High:       dot.High,
+			Low:        dot.Low,
+			Close:      dot.Close,
+			UnitVolume: unitVolume,
+		})
+	}
+	sort.Slice(bars, func(i, j int) bool {
+		return bars[i].Time.Before(bars[j].Time)
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bars[replayKey{market: market, pair: target, period: k.Period}] = bars
+	return nil
+}
+
+// load trade history to replay, fills are preferred to klines in order matching
+func (b *BacktestEx) AddFills(market Market, target Pair, fills []Fill) error {
+	cpy := append([]Fill{}, fills...)
+	sort.SliceStable(cpy, func(i, j int) bool {
+		if cpy[i].Time.Equal(cpy[j].Time) {
+			return cpy[i].Id < cpy[j].Id
+		}
+		return cpy[i].Time.Before(cpy[j].Time)
+	})
+	var bars []replayBar
+	for _, fill := range cpy {
+		bars = append(bars, replayBar{
+			Time:       fill.Time,
+			End:        fill.Time,
+			Open:       fill.Price,
+			High:       fill.Price,
+			Low:        fill.Price,
+			Close:      fill.Price,
+			UnitVolume: fill.UnitQty,
+		})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fills[replayKey{market: market, pair: target}] = cpy
+	b.fillBars[replayKey{market: market, pair: target}] = bars
+	return nil
+}
+
+func (b *BacktestEx) Config() *ExConfig {
+	return b.config
+}
+
+// simulated trading of spot and margin markets enabled by config
+func (b *BacktestEx) Capabilities() Capabilities {
+	c := DefaultCapabilities(b)
+	c.Markets = intersectMarkets(c, []Market{MarketSpot, MarketMargin})
+	c.TradeTypes = []TradeTypeSide{TradeTypeSideLimitBuy, TradeTypeSideLimitSell, TradeTypeSideMarketBuy, TradeTypeSideMarketSell}
+	c.OrderTypes = []OrderType{OrderTypeLimit, OrderTypeMarket}
+	return SortCapabilities(c)
+}
+
+func (b *BacktestEx) GetMarketInfo() (*MarketInfo, error) {
+	return b.marketInfo, nil
+}
+
+func (b *BacktestEx) GetAccount() (*Account, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.process()
+	return copyAccount(b.ledger.account), nil
+}
+
+// depth is made up by latest visible bar, one level each side at close price with bar volume
+func (b *BacktestEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar, ok := b.lastBar(market, target)
+	if !ok {
+		return nil, errorz.Errorf("no replay data of %s before %s", target.String(), b.now())
+	}
+	d := &Depth{Time: bar.End}
+	d.Sells = OrderBookList{OrderBook{Price: bar.Close, Amount: bar.UnitVolume}}
+	d.Buys = OrderBookList{OrderBook{Price: bar.Close, Amount: bar.UnitVolume}}
+	return d, nil
+}
+
+func (b *BacktestEx) GetTicks() (map[PairExt]Tick, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := map[PairExt]Tick{}
+	for key := range b.dataKeys() {
+		bar, ok := b.lastBar(key.market, key.pair)
+		if !ok {
+			continue
+		}
+		r[key.pair.SetMarket(key.market)] = Tick{
+			Time:   bar.End,
+			Last:   bar.Close,
+			Buy:    bar.Close,
+			Sell:   bar.Close,
+			High:   bar.High,
+			Low:    bar.Low,
+			Volume: bar.UnitVolume,
+		}
+	}
+	return r, nil
+}
+
+func (b *BacktestEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bars, ok := b.bars[replayKey{market: market, pair: target, period: period}]
+	if !ok {
+		return nil, errorz.Errorf("no %s kline of %s to replay", period, target.String())
+	}
+	now := b.now()
+	visible := bars[:sort.Search(len(bars), func(i int) bool {
+		return bars[i].End.After(now)
+	})]
+	if since == nil && len(visible) > b.option.KlineLimit {
+		visible = visible[len(visible)-b.option.KlineLimit:] // latest ones like real exchanges
+	}
+	r := &Kline{Pair: target.SetMarket(market), Period: period}
+	for _, bar := range visible {
+		if since != nil && bar.Time.Before(*since) {
+			continue
+		}
+		r.Items = append(r.Items, KDot{
+			Time:   bar.Time,
+			Open:   bar.Open,
+			Low:    bar.Low,
+			High:   bar.High,
+			Close:  bar.Close,
+			Volume: bar.UnitVolume.Mul(bar.Close),
+		})
+		if len(r.Items) >= b.option.KlineLimit {
+			break
+		}
+	}
+	return r, nil
+}
+
+// fromId is inclusive, like Binance
+func (b *BacktestEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	if limit <= 0 {
+		limit = defaultBacktestFillLimit
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	var r []Fill
+	for _, fill := range b.fills[replayKey{market: market, pair: target}] {
+		if fill.Time.After(now) {
+			break
+		}
+		if fromId != nil && fill.Id < *fromId {
+			continue
+		}
+		r = append(r, fill)
+		if len(r) >= limit {
+			break
+		}
+	}
+	return r, nil
+}
+
+func (b *BacktestEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	return decimals.Zero, ErrFunctionNotSupported
+}
+
+func (b *BacktestEx) Borrow(asset string, amount decimals.Decimal) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ledger.borrow(asset, amount)
+}
+
+func (b *BacktestEx) Repay(asset string, amount decimals.Decimal) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ledger.repay(asset, amount)
+}
+
+func (b *BacktestEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ledger.transfer(asset, amount, target)
+}
+
+func (b *BacktestEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	info, err := findPairInfo(b.marketInfo, market, target)
+	if err != nil {
+		return nil, err
+	}
+	amount, price, err = verifyTrade(market, t, amount, price, info)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.now().Before(b.config.TradeBeginTime) {
+		return nil, NewExError(ErrorKindMarketClosed, "trading begins at %s, now %s", b.config.TradeBeginTime, b.now())
+	}
+	b.process()
+
+	toFreeze := amount
+	if t.IsBuy() {
+		if t.IsLimit() {
+			toFreeze = amount.Mul(price)
+		} else {
+			bar, ok := b.lastBar(market, target)
+			if !ok {
+				return nil, errorz.Errorf("no replay data of %s before %s", target.String(), b.now())
+			}
+			toFreeze = amount.Mul(bar.Close)
+		}
+	}
+	order, err := b.ledger.open(market, target, t, amount, price, toFreeze, "")
+	if err != nil {
+		return nil, err
+	}
+	id := order.Id
+	return &id, nil
+}
+
+func (b *BacktestEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.process()
+	return b.ledger.list(market, target, false), nil
+}
+
+func (b *BacktestEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.process()
+	return b.ledger.list(market, target, true), nil
+}
+
+func (b *BacktestEx) GetOrder(id OrderId) (*Order, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.process()
+	return b.ledger.get(id)
+}
+
+func (b *BacktestEx) CancelOrder(id OrderId) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.process()
+	return b.ledger.cancel(id)
+}
+
+func (b *BacktestEx) now() time.Time {
+	return b.config.Clock.Now()
+}
+
+// all market & pair which have replay data, b.mu must be held
+func (b *BacktestEx) dataKeys() map[replayKey]bool {
+	r := map[replayKey]bool{}
+	for key := range b.bars {
+		r[replayKey{market: key.market, pair: key.pair}] = true
+	}
+	for key := range b.fills {
+		r[key] = true
+	}
+	return r
+}
+
+// bars used to match orders, fills first, then kline with min period, b.mu must be held
+func (b *BacktestEx) matchBars(market Market, target Pair) []replayBar {
+	if bars, ok := b.fillBars[replayKey{market: market, pair: target}]; ok {
+		return bars
+	}
+
+	var r []replayBar
+	minSeconds := int64(0)
+	for key, bars := range b.bars {
+		if key.market != market || key.pair != target {
+			continue
+		}
+		if minSeconds == 0 || key.period.ToSeconds() < minSeconds {
+			minSeconds = key.period.ToSeconds()
+			r = bars
+		}
+	}
+	return r
+}
+
+// latest visible bar, b.mu must be held
+func (b *BacktestEx) lastBar(market Market, target Pair) (replayBar, bool) {
+	now := b.now()
+	bars := b.matchBars(market, target)
+	idx := sort.Search(len(bars), func(i int) bool {
+		return bars[i].End.After(now)
+	})
+	if idx == 0 {
+		return replayBar{}, false
+	}
+	return bars[idx-1], true
+}
+
+// match pending orders with bars closed since last call, b.mu must be held
+func (b *BacktestEx) process() {
+	now := b.now()
+	for _, order := range b.ledger.pending() {
+		market := b.ledger.markets[order.Id]
+		info, err := findPairInfo(b.marketInfo, market, order.Pair)
+		if err != nil {
+			continue
+		}
+		bars := b.matchBars(market, order.Pair)
+		for i := b.matched[order.Id]; i < len(bars); i++ {
+			bar := bars[i]
+			if bar.End.After(now) {
+				break
+			}
+			b.matched[order.Id] = i + 1
+			if bar.Time.Before(order.Time) {
+				continue
+			}
+			b.matchBar(order, bar, info)
+			if order.Status.End() {
+				delete(b.matched, order.Id)
+				break
+			}
+		}
+	}
+}
+
+// b.mu must be held
+func (b *BacktestEx) matchBar(order *Order, bar replayBar, info *PairInfo) {
+	t := order.TypeSide
+	dealPrice := decimals.Zero
+	switch b.option.Model {
+	case FillModelClose:
+		if t.IsMarket() || (t.IsBuy() && !bar.Close.GreaterThan(order.Price)) || (t.IsSell() && !bar.Close.LessThan(order.Price)) {
+			dealPrice = bar.Close
+		}
+	default:
+		if t.IsMarket() {
+			dealPrice = bar.Open
+		} else if t.IsBuy() && !bar.Low.GreaterThan(order.Price) {
+			dealPrice = decimals.Min(order.Price, bar.Open) // gap down opens below limit price
+		} else if t.IsSell() && !bar.High.LessThan(order.Price) {
+			dealPrice = order.Price
+			if bar.Open.GreaterThan(order.Price) {
+				dealPrice = bar.Open // gap up opens above limit price
+			}
+		}
+	}
+
+	if dealPrice.IsPositive() {
+		deal := order.Amount.Sub(order.DealAmount)
+		if b.option.Model == FillModelVolume {
+			deal = decimals.Min(deal, bar.UnitVolume.Mul(b.option.Participation))
+		}
+		if t.IsBuy() {
+			if frozen := b.ledger.frozen[order.Id]; deal.Mul(dealPrice).GreaterThan(frozen) {
+				deal = frozen.Div(dealPrice)
+			}
+		}
+		deal = roundAmount(deal, info)
+		if deal.IsPositive() {
+			feeRate := b.config.MakerFee
+			if t.IsMarket() {
+				feeRate = b.config.TakerFee
+			}
+			b.ledger.settle(order, deal, dealPrice, feeRate)
+		}
+	}
+	// with volume participation, market orders keep filling in following bars
+	b.ledger.update(order, t.IsMarket() && b.option.Model != FillModelVolume)
+}
@@ -0,0 +1,145 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"testing"
+	"time"
+)
+
+var testBacktestBegin = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 1min bars, close: 100, 102, 98, 105
+func newTestBacktestEx(t *testing.T, option BacktestOption) (*BacktestEx, *testClock) {
+	clk := &testClock{now: testBacktestBegin}
+	config := newFakeEx().config
+	config.Clock = clk
+	config.IsBackTestEx = true
+	config.TradeBeginTime = testBacktestBegin.Add(time.Minute)
+	acc := NewEmptyAccount()
+	acc.SetSpot("USDT", decimals.NewFromInt(1000), decimals.Zero)
+	b, err := NewBacktestEx(&config, &newFakeEx().info, acc, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &Kline{Period: Period1Min}
+	for i, v := range [][4]float64{{100, 101, 99, 100}, {100, 103, 100, 102}, {102, 102, 97, 98}, {98, 106, 98, 105}} {
+		k.Items = append(k.Items, KDot{
+			Time:   testBacktestBegin.Add(time.Duration(i) * time.Minute),
+			Open:   decimals.NewFromFloat64(v[0]),
+			High:   decimals.NewFromFloat64(v[1]),
+			Low:    decimals.NewFromFloat64(v[2]),
+			Close:  decimals.NewFromFloat64(v[3]),
+			Volume: decimals.NewFromFloat64(v[3] * 10),
+		})
+	}
+	if err := b.AddKline(MarketSpot, testPair, k); err != nil {
+		t.Fatal(err)
+	}
+	return b, clk
+}
+
+func TestBacktestEx_NoLookAhead(t *testing.T) {
+	b, clk := newTestBacktestEx(t, BacktestOption{Model: FillModelTouch})
+	if _, err := b.GetDepth(MarketSpot, testPair, 5); err == nil {
+		t.Fatal("no data should be visible before first bar closed")
+	}
+	clk.now = testBacktestBegin.Add(2*time.Minute + time.Second)
+	k, err := b.GetKline(MarketSpot, testPair, Period1Min, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Len() != 2 {
+		t.Fatalf("2 closed bars expected, got %d", k.Len())
+	}
+	ticks, _ := b.GetTicks()
+	if tick := ticks[testPair.SetMarket(MarketSpot)]; !tick.Last.EqualInt(102) {
+		t.Fatalf("unexpected tick %s", tick.String())
+	}
+}
+
+func TestBacktestEx_TradeBeginTime(t *testing.T) {
+	b, _ := newTestBacktestEx(t, BacktestOption{Model: FillModelTouch})
+	if _, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99)); err == nil {
+		t.Fatal("trading before TradeBeginTime should fail")
+	}
+}
+
+func TestBacktestEx_FillModels(t *testing.T) {
+	type item struct {
+		option    BacktestOption
+		expectAvg float64
+		expectAmt float64
+	}
+	items := []item{
+		{option: BacktestOption{Model: FillModelTouch}, expectAvg: 99, expectAmt: 2},
+		{option: BacktestOption{Model: FillModelClose}, expectAvg: 98, expectAmt: 2},
+		{option: BacktestOption{Model: FillModelVolume, Participation: decimals.NewFromFloat64(0.1)}, expectAvg: 99, expectAmt: 1},
+	}
+	for _, v := range items {
+		b, clk := newTestBacktestEx(t, v.option)
+		clk.now = testBacktestBegin.Add(2 * time.Minute)
+		id, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(2), decimals.NewFromInt(99))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clk.now = testBacktestBegin.Add(3 * time.Minute)
+		order, err := b.GetOrder(*id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !order.AvgPrice.Equal(decimals.NewFromFloat64(v.expectAvg)) || !order.DealAmount.Equal(decimals.NewFromFloat64(v.expectAmt)) {
+			t.Fatalf("model %s got %s", v.option.Model, order.String())
+		}
+	}
+}
+
+func TestBacktestEx_KlineLatest(t *testing.T) {
+	b, clk := newTestBacktestEx(t, BacktestOption{Model: FillModelTouch, KlineLimit: 2})
+	clk.now = testBacktestBegin.Add(3 * time.Minute)
+	k, err := b.GetKline(MarketSpot, testPair, Period1Min, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Len() != 2 || !k.Items[0].Time.Equal(testBacktestBegin.Add(time.Minute)) || !k.Items[1].Close.EqualInt(98) {
+		t.Fatalf("latest 2 closed bars expected, got %v", k.Items)
+	}
+	since := testBacktestBegin
+	k, err = b.GetKline(MarketSpot, testPair, Period1Min, &since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Len() != 2 || !k.Items[0].Time.Equal(testBacktestBegin) {
+		t.Fatalf("oldest 2 bars since begin expected, got %v", k.Items)
+	}
+}
+
+func TestBacktestEx_FillsOfSameTime(t *testing.T) {
+	b, clk := newTestBacktestEx(t, BacktestOption{Model: FillModelVolume, Participation: decimals.One})
+	at := testBacktestBegin.Add(2*time.Minute + time.Second)
+	var fills []Fill
+	for id := int64(1); id <= 3; id++ {
+		fills = append(fills, Fill{Id: id, Time: at, Price: decimals.NewFromInt(99), UnitQty: decimals.NewFromFloat64(0.5)})
+	}
+	if err := b.AddFills(MarketSpot, testPair, fills); err != nil {
+		t.Fatal(err)
+	}
+	clk.now = testBacktestBegin.Add(2 * time.Minute)
+	id, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(2), decimals.NewFromInt(99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.now = at
+	order, err := b.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !order.DealAmount.Equal(decimals.NewFromFloat64(1.5)) {
+		t.Fatalf("all 3 fills of the same time expected to match, got %s", order.String())
+	}
+	order, _ = b.GetOrder(*id)
+	if !order.DealAmount.Equal(decimals.NewFromFloat64(1.5)) {
+		t.Fatalf("fills matched twice, got %s", order.String())
+	}
+}
@@ -0,0 +1,165 @@
+package ex
+
+import (
+	"fmt"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultBatchConcurrency = 5
+)
+
+type (
+	// BatchResult of one request in batch, Id is nil if Err is not nil
+	BatchResult struct {
+		Id  *OrderId
+		Err error
+	}
+
+	// BatchTrader is implemented by Ex which places or cancels many orders in few round trips.
+	// Results are in the same order of requests, one bad request never fails others.
+	BatchTrader interface {
+		BatchTrade(reqs []TradeRequest) []BatchResult
+		BatchCancel(ids []OrderId) []error
+	}
+
+	// AllOrdersCanceler is implemented by Ex which cancels all open orders of a pair in one request.
+	AllOrdersCanceler interface {
+		// ids of canceled orders are returned
+		CancelAllOrders(market Market, target Pair) ([]OrderId, error)
+	}
+
+	// OpenOrdersGetter is implemented by Ex which gets open orders of all pairs of a market in one request.
+	OpenOrdersGetter interface {
+		GetAllOpenOrders(market Market) ([]Order, error)
+	}
+)
+
+// BatchTrade places reqs by e if e is a BatchTrader, or by TradeWithOptions one by one,
+// at most concurrency requests at the same time, concurrency <= 0 means default.
+func BatchTrade(e Ex, reqs []TradeRequest, concurrency int) []BatchResult {
+	if bt, ok := e.(BatchTrader); ok {
+		return bt.BatchTrade(reqs)
+	}
+	r := make([]BatchResult, len(reqs))
+	parallel(len(reqs), concurrency, func(i int) {
+		r[i].Id, r[i].Err = TradeWithOptions(e, reqs[i])
+	})
+	return r
+}
+
+// BatchCancel cancels ids by e if e is a BatchTrader, or by CancelOrder one by one like BatchTrade.
+func BatchCancel(e Ex, ids []OrderId, concurrency int) []error {
+	if bt, ok := e.(BatchTrader); ok {
+		return bt.BatchCancel(ids)
+	}
+	r := make([]error, len(ids))
+	parallel(len(ids), concurrency, func(i int) {
+		r[i] = e.CancelOrder(ids[i])
+	})
+	return r
+}
+
+// CancelAllOrders cancels all open orders of target by e if e is an AllOrdersCanceler, or by BatchCancel.
+// If some orders are not canceled, ids of canceled ones are returned with the first error.
+func CancelAllOrders(e Ex, market Market, target Pair, concurrency int) ([]OrderId, error) {
+	if ac, ok := e.(AllOrdersCanceler); ok {
+		return ac.CancelAllOrders(market, target)
+	}
+	return cancelAllOrders(e, market, target, concurrency)
+}
+
+// CancelAllOrders without native support of e
+func cancelAllOrders(e Ex, market Market, target Pair, concurrency int) ([]OrderId, error) {
+	orders, err := e.GetOpenOrders(market, target)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]OrderId, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.Id)
+	}
+
+	var canceled []OrderId
+	var firstErr error
+	failed := 0
+	for i, err := range BatchCancel(e, ids, concurrency) {
+		if err == nil {
+			canceled = append(canceled, ids[i])
+			continue
+		}
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return canceled, &ExError{
+			Kind: ErrorKindOf(firstErr),
+			Msg:  fmt.Sprintf("%d of %d orders not canceled, first error: %s", failed, len(ids), firstErr.Error()),
+			Err:  firstErr,
+		}
+	}
+	return canceled, nil
+}
+
+// GetAllOpenOrders gets open orders of all pairs of market by e if e is an OpenOrdersGetter,
+// or pair by pair of enabled pairs in GetMarketInfo, at most concurrency requests at the same time, concurrency <= 0 means default.
+// Orders got are returned with the first error.
+func GetAllOpenOrders(e Ex, market Market, concurrency int) ([]Order, error) {
+	if g, ok := e.(OpenOrdersGetter); ok {
+		return g.GetAllOpenOrders(market)
+	}
+	return getAllOpenOrders(e, market, concurrency)
+}
+
+// GetAllOpenOrders without native support of e
+func getAllOpenOrders(e Ex, market Market, concurrency int) ([]Order, error) {
+	mi, err := e.GetMarketInfo()
+	if err != nil {
+		return nil, err
+	}
+	var pairs []Pair
+	for pe, info := range mi.Infos {
+		if pe.Market() == market && info.Enabled {
+			pairs = append(pairs, pe.Pair())
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].String() < pairs[j].String() })
+
+	orders := make([][]Order, len(pairs))
+	errs := make([]error, len(pairs))
+	parallel(len(pairs), concurrency, func(i int) {
+		orders[i], errs[i] = e.GetOpenOrders(market, pairs[i])
+	})
+	var r []Order
+	var firstErr error
+	for i := range pairs {
+		r = append(r, orders[i]...)
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	return r, firstErr
+}
+
+// call fn(0) to fn(n-1) with at most concurrency goroutines
+func parallel(n, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
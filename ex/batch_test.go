@@ -0,0 +1,97 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// hides optional interfaces of inner exchange, and counts concurrent CancelOrder calls
+	plainEx struct {
+		Ex
+		mu      sync.Mutex
+		running int
+		peak    int
+	}
+)
+
+func (p *plainEx) CancelOrder(id OrderId) error {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.peak {
+		p.peak = p.running
+	}
+	p.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		p.mu.Lock()
+		p.running--
+		p.mu.Unlock()
+	}()
+	return p.Ex.CancelOrder(id)
+}
+
+func TestBatchTrade(t *testing.T) {
+	paper, _ := newTestPaperEx(t)
+	e := &plainEx{Ex: paper}
+
+	var reqs []TradeRequest
+	for i := 0; i < 6; i++ {
+		reqs = append(reqs, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(int64(90-i))))
+	}
+	reqs = append(reqs, NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(100), decimals.NewFromInt(90)))
+	results := BatchTrade(e, reqs, 2)
+	if len(results) != len(reqs) {
+		t.Fatalf("%d results expected, got %d", len(reqs), len(results))
+	}
+	var ids []OrderId
+	for i, res := range results[:6] {
+		if res.Err != nil {
+			t.Fatalf("request %d failed: %v", i, res.Err)
+		}
+		ids = append(ids, *res.Id)
+	}
+	if res := results[6]; res.Id != nil || ErrorKindOf(res.Err) != ErrorKindInsufficientBalance {
+		t.Fatalf("last request should fail for insufficient balance, got %v", res.Err)
+	}
+
+	errs := BatchCancel(e, append(ids[:2], NewOrderId(MarketSpot, testPair, "404")), 2)
+	if errs[0] != nil || errs[1] != nil || ErrorKindOf(errs[2]) != ErrorKindOrderNotFound {
+		t.Fatalf("unexpected cancel errors %v", errs)
+	}
+
+	canceled, err := CancelAllOrders(e, MarketSpot, testPair, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 4 {
+		t.Fatalf("4 canceled orders expected, got %d", len(canceled))
+	}
+	if e.peak > 3 {
+		t.Fatalf("at most 3 concurrent cancels expected, got %d", e.peak)
+	}
+	if orders, _ := paper.GetOpenOrders(MarketSpot, testPair); len(orders) != 0 {
+		t.Fatalf("no open order expected, got %d", len(orders))
+	}
+}
+
+func TestPaperEx_CancelAllOrders(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	r, _ := newTestRetryEx(t, p, RetryOption{})
+	for _, price := range []int64{90, 91} {
+		if _, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(price)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canceled, err := CancelAllOrders(r, MarketSpot, testPair, 0)
+	if err != nil || len(canceled) != 2 {
+		t.Fatalf("2 canceled orders expected, got %v, err %v", canceled, err)
+	}
+	acc, _ := p.GetAccount()
+	if !acc.Spot["USDT"].Free.EqualInt(1000) {
+		t.Fatalf("all USDT should be freed, got %s", acc.Spot["USDT"].Free.String())
+	}
+}
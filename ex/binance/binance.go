@@ -0,0 +1,411 @@
+package binance
+
+import (
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	maxKlineLimit = 1000
+	maxFillLimit  = 1000
+)
+
+type (
+	Option struct {
+		Endpoint   string       // DefaultEndpoint if empty, FakeServer.URL in tests
+		HttpClient *http.Client // optional, proxy is ignored if set
+	}
+
+	// BinanceEx implements ex.Ex by Binance spot and margin REST API
+	BinanceEx struct {
+		config *ExConfig
+		client *client
+	}
+
+	exchangeInfo struct {
+		Symbols []struct {
+			Symbol                 string `json:"symbol"`
+			Status                 string `json:"status"`
+			BaseAsset              string `json:"baseAsset"`
+			QuoteAsset             string `json:"quoteAsset"`
+			IsSpotTradingAllowed   bool   `json:"isSpotTradingAllowed"`
+			IsMarginTradingAllowed bool   `json:"isMarginTradingAllowed"`
+			Filters                []struct {
+				FilterType string `json:"filterType"`
+				MinQty     string `json:"minQty"`
+				StepSize   string `json:"stepSize"`
+				TickSize   string `json:"tickSize"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+
+	depthResp struct {
+		Bids [][2]string `json:"bids"`
+		Asks [][2]string `json:"asks"`
+	}
+
+	tickResp struct {
+		Symbol    string `json:"symbol"`
+		LastPrice string `json:"lastPrice"`
+		BidPrice  string `json:"bidPrice"`
+		AskPrice  string `json:"askPrice"`
+		HighPrice string `json:"highPrice"`
+		LowPrice  string `json:"lowPrice"`
+		Volume    string `json:"volume"`
+		CloseTime int64  `json:"closeTime"`
+	}
+
+	tradeResp struct {
+		Id           int64  `json:"id"`
+		Price        string `json:"price"`
+		Qty          string `json:"qty"`
+		Time         int64  `json:"time"`
+		IsBuyerMaker bool   `json:"isBuyerMaker"`
+	}
+
+	serverTimeResp struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+
+	aggTradeResp struct {
+		Id           int64  `json:"a"`
+		Price        string `json:"p"`
+		Qty          string `json:"q"`
+		FirstId      int64  `json:"f"`
+		LastId       int64  `json:"l"`
+		Time         int64  `json:"T"`
+		IsBuyerMaker bool   `json:"m"`
+	}
+)
+
+func init() {
+	ex.RegisterEx(Binance, DefaultConfig(), func(config ExConfig, apiKey, apiSecret, proxy string) (ex.Ex, error) {
+		return NewBinanceEx(config, apiKey, apiSecret, proxy, Option{})
+	})
+}
+
+// apiKey and apiSecret are only required by account, margin and order APIs
+func NewBinanceEx(config ExConfig, apiKey, apiSecret, proxy string, option Option) (*BinanceEx, error) {
+	config.Name = Binance
+	b := &BinanceEx{config: &config}
+	c, err := newClient(option.Endpoint, apiKey, apiSecret, proxy, option.HttpClient, b.now)
+	if err != nil {
+		return nil, err
+	}
+	b.client = c
+	return b, nil
+}
+
+func (b *BinanceEx) now() time.Time {
+	if b.config.Clock != nil {
+		return b.config.Clock.Now()
+	}
+	return time.Now().UTC()
+}
+
+func (b *BinanceEx) Config() *ExConfig {
+	return b.config
+}
+
+// spot and margin orders of types in orderTypes and OCO, post only is limit maker
+func (b *BinanceEx) Capabilities() Capabilities {
+	c := ex.DefaultCapabilities(b)
+	c.Markets = nil
+	for _, market := range []Market{MarketSpot, MarketMargin} {
+		if b.config.MarketEnabled[market] {
+			c.Markets = append(c.Markets, market)
+		}
+	}
+	c.OrderTypes = []OrderType{OrderTypeOCO}
+	for t := range orderTypes {
+		c.OrderTypes = append(c.OrderTypes, t)
+	}
+	c.TimeInForces = []TimeInForce{TimeInForceGTC, TimeInForceIOC, TimeInForceFOK, TimeInForcePostOnly}
+	return ex.SortCapabilities(c)
+}
+
+func (b *BinanceEx) symbol(target Pair) string {
+	return target.CustomFormat(b.config)
+}
+
+// spot and margin share the same order books and trades
+func (b *BinanceEx) verifyMarket(market Market) error {
+	if market != MarketSpot && market != MarketMargin {
+		return ErrFunctionNotSupported
+	}
+	if !b.config.MarketEnabled[market] {
+		return errorz.Errorf("market(%s) disabled in config", market)
+	}
+	return nil
+}
+
+func (b *BinanceEx) GetMarketInfo() (*MarketInfo, error) {
+	info := exchangeInfo{}
+	if err := b.client.do(http.MethodGet, "/api/v3/exchangeInfo", nil, false, &info); err != nil {
+		return nil, err
+	}
+
+	r := &MarketInfo{Infos: map[PairExt]PairInfo{}}
+	for _, s := range info.Symbols {
+		pair := NewPair(s.BaseAsset, s.QuoteAsset)
+		if pair == PairErr {
+			continue
+		}
+		pi := PairInfo{Enabled: s.Status == "TRADING"}
+		for _, f := range s.Filters {
+			var err error
+			switch f.FilterType {
+			case "LOT_SIZE":
+				if pi.LotMin, err = parseDecimal(f.MinQty); err != nil {
+					return nil, err
+				}
+				if pi.LotStep, err = parseDecimal(f.StepSize); err != nil {
+					return nil, err
+				}
+				pi.UnitPrecision = precisionOf(f.StepSize)
+			case "PRICE_FILTER":
+				pi.QuotePrecision = precisionOf(f.TickSize)
+			}
+		}
+		if s.IsSpotTradingAllowed && b.config.SpotEnabled() {
+			r.Infos[pair.SetMarket(MarketSpot)] = pi
+		}
+		if s.IsMarginTradingAllowed && b.config.MarginEnabled() {
+			r.Infos[pair.SetMarket(MarketMargin)] = pi
+		}
+	}
+	return r, nil
+}
+
+func (b *BinanceEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > b.config.MaxDepth {
+		limit = b.config.MaxDepth
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	params.Set("limit", strconv.Itoa(limit))
+	resp := depthResp{}
+	if err := b.client.do(http.MethodGet, "/api/v3/depth", params, false, &resp); err != nil {
+		return nil, err
+	}
+
+	r := &Depth{Time: b.now()}
+	var err error
+	if r.Buys, err = parseOrderBooks(resp.Bids); err != nil {
+		return nil, err
+	}
+	if r.Sells, err = parseOrderBooks(resp.Asks); err != nil {
+		return nil, err
+	}
+	r.Sort()
+	return r, nil
+}
+
+func parseOrderBooks(items [][2]string) (OrderBookList, error) {
+	var r OrderBookList
+	for _, item := range items {
+		price, err := parseDecimal(item[0])
+		if err != nil {
+			return nil, err
+		}
+		amount, err := parseDecimal(item[1])
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, OrderBook{Price: price, Amount: amount})
+	}
+	return r, nil
+}
+
+func (b *BinanceEx) GetServerTime() (time.Time, error) {
+	resp := serverTimeResp{}
+	if err := b.client.do(http.MethodGet, "/api/v3/time", nil, false, &resp); err != nil {
+		return time.Time{}, err
+	}
+	return fromMillis(resp.ServerTime), nil
+}
+
+// spot ticks of all symbols, symbols can't be parsed are ignored
+func (b *BinanceEx) GetTicks() (map[PairExt]Tick, error) {
+	var resp []tickResp
+	if err := b.client.do(http.MethodGet, "/api/v3/ticker/24hr", nil, false, &resp); err != nil {
+		return nil, err
+	}
+	r := map[PairExt]Tick{}
+	for _, t := range resp {
+		pair, err := ParsePairCustom(t.Symbol, b.config)
+		if err != nil {
+			continue
+		}
+		tick := Tick{Time: fromMillis(t.CloseTime)}
+		for _, v := range []struct {
+			s string
+			d *decimals.Decimal
+		}{{t.LastPrice, &tick.Last}, {t.BidPrice, &tick.Buy}, {t.AskPrice, &tick.Sell}, {t.HighPrice, &tick.High}, {t.LowPrice, &tick.Low}, {t.Volume, &tick.Volume}} {
+			if *v.d, err = parseDecimal(v.s); err != nil {
+				return nil, err
+			}
+		}
+		r[pair.SetMarket(MarketSpot)] = tick
+	}
+	return r, nil
+}
+
+// at most 1000 dots since given time, latest 1000 dots if since is nil
+func (b *BinanceEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	interval, err := period.CustomFormat(b.config)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	params.Set("interval", interval)
+	params.Set("limit", strconv.Itoa(maxKlineLimit))
+	if since != nil {
+		params.Set("startTime", strconv.FormatInt(toMillis(*since), 10))
+	}
+	var rows [][]json.RawMessage
+	if err := b.client.do(http.MethodGet, "/api/v3/klines", params, false, &rows); err != nil {
+		return nil, err
+	}
+
+	r := &Kline{Pair: NewPairExt(target, &period, &market, nil), Period: period}
+	for _, row := range rows {
+		dot, err := parseKDot(row)
+		if err != nil {
+			return nil, err
+		}
+		r.Items = append(r.Items, dot)
+	}
+	return r, nil
+}
+
+// [openTime, open, high, low, close, volume, closeTime, quoteVolume, ...]
+func parseKDot(row []json.RawMessage) (KDot, error) {
+	dot := KDot{}
+	if len(row) < 8 {
+		return dot, errorz.Errorf("invalid kline row of %d fields", len(row))
+	}
+	var openTime int64
+	if err := json.Unmarshal(row[0], &openTime); err != nil {
+		return dot, err
+	}
+	dot.Time = fromMillis(openTime)
+	for i, d := range map[int]*decimals.Decimal{1: &dot.Open, 2: &dot.High, 3: &dot.Low, 4: &dot.Close, 7: &dot.Volume} {
+		var s string
+		if err := json.Unmarshal(row[i], &s); err != nil {
+			return dot, err
+		}
+		v, err := parseDecimal(s)
+		if err != nil {
+			return dot, err
+		}
+		*d = v
+	}
+	return dot, nil
+}
+
+// recent trades if fromId is nil, otherwise historical trades from fromId which requires api key
+func (b *BinanceEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxFillLimit {
+		limit = maxFillLimit
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	params.Set("limit", strconv.Itoa(limit))
+	path := "/api/v3/trades"
+	if fromId != nil {
+		if err := (FillOption{BeginId: *fromId, IdLimit: int64(limit)}).VerifyBinance(); err != nil {
+			return nil, err
+		}
+		params.Set("fromId", strconv.FormatInt(*fromId, 10))
+		path = "/api/v3/historicalTrades"
+	}
+	var resp []tradeResp
+	if err := b.client.do(http.MethodGet, path, params, false, &resp); err != nil {
+		return nil, err
+	}
+	return fillsOf(resp)
+}
+
+// Binance has no historical trades by time, the first trade id of window is found by aggregate trades,
+// then historical trades are paged from it until the window ends.
+func (b *BinanceEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	if err := (FillOption{BeginTime: begin, TimeDuration: duration}).VerifyBinance(); err != nil {
+		return nil, err
+	}
+	end := begin.Add(duration)
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	params.Set("startTime", strconv.FormatInt(toMillis(begin), 10))
+	params.Set("endTime", strconv.FormatInt(toMillis(end)-1, 10))
+	params.Set("limit", "1")
+	var aggs []aggTradeResp
+	if err := b.client.do(http.MethodGet, "/api/v3/aggTrades", params, false, &aggs); err != nil {
+		return nil, err
+	}
+	if len(aggs) == 0 {
+		return nil, nil
+	}
+
+	var r []Fill
+	fromId := aggs[0].FirstId
+	for {
+		page, err := b.GetFills(market, target, &fromId, maxFillLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, fill := range page {
+			if !fill.Time.Before(end) {
+				return r, nil
+			}
+			if !fill.Time.Before(begin) {
+				r = append(r, fill)
+			}
+			fromId = fill.Id + 1
+		}
+		if len(page) < maxFillLimit {
+			return r, nil
+		}
+	}
+}
+
+func fillsOf(resp []tradeResp) ([]Fill, error) {
+	var r []Fill
+	for _, t := range resp {
+		price, err := parseDecimal(t.Price)
+		if err != nil {
+			return nil, err
+		}
+		qty, err := parseDecimal(t.Qty)
+		if err != nil {
+			return nil, err
+		}
+		side := "buy"
+		if t.IsBuyerMaker { // taker sold
+			side = "sell"
+		}
+		r = append(r, Fill{Id: t.Id, Time: fromMillis(t.Time), Price: price, UnitQty: qty, Side: side})
+	}
+	return r, nil
+}
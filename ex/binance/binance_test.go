@@ -0,0 +1,548 @@
+package binance
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	"github.com/shawnwyckoff/fintypes/ex/extest"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testPair = NewPair("BTC", "USDT")
+
+func newTestBinanceEx(t *testing.T) (*BinanceEx, *FakeServer) {
+	s := NewFakeServer()
+	t.Cleanup(s.Close)
+	s.AddSymbol(testPair, "0.001", "0.001", "0.01", true)
+	s.SetDepth(testPair, [][2]string{{"100", "1"}, {"99", "2"}}, [][2]string{{"101", "1"}, {"102", "2"}})
+	s.SetBalance(MarketSpot, "USDT", decimals.NewFromInt(1000))
+	b, err := NewBinanceEx(DefaultConfig(), FakeApiKey, FakeApiSecret, "", Option{Endpoint: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, s
+}
+
+func TestBinanceEx_Registered(t *testing.T) {
+	config, err := ex.DefaultExConfig(Binance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Periods[Period1Hour] != "1h" {
+		t.Fatal("preset periods expected")
+	}
+}
+
+func TestBinanceEx_Certify(t *testing.T) {
+	extest.Run(t, func(t *testing.T, sc extest.Scenario) ex.Ex {
+		s := NewFakeServer()
+		t.Cleanup(s.Close)
+		s.AddSymbol(sc.Pair, sc.Info.LotMin.String(), sc.Info.LotStep.String(), "0.01", true)
+		var bids, asks [][2]string
+		for _, book := range sc.Bids {
+			bids = append(bids, [2]string{book.Price.String(), book.Amount.String()})
+		}
+		for _, book := range sc.Asks {
+			asks = append(asks, [2]string{book.Price.String(), book.Amount.String()})
+		}
+		s.SetDepth(sc.Pair, bids, asks)
+		for asset, free := range sc.Balances {
+			s.SetBalance(sc.Market, asset, free)
+		}
+		b, err := NewBinanceEx(DefaultConfig(), FakeApiKey, FakeApiSecret, "", Option{Endpoint: s.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}, extest.DefaultScenario())
+}
+
+func TestBinanceEx_Capabilities(t *testing.T) {
+	b, _ := newTestBinanceEx(t)
+	c := b.Capabilities()
+	for _, method := range []ExMethod{ExMethodTradeWithOptions, ExMethodCancelAllOrders, ExMethodGetAllOpenOrders, ExMethodGetFillsByTime, ExMethodWithdraw} {
+		if !c.HasMethod(method) {
+			t.Fatalf("%s missing in %v", method, c.Methods)
+		}
+	}
+	if c.HasMethod(ExMethodBatchTrade) || c.HasMethod(ExMethodGetPositions) || c.MaxDepth != 5000 {
+		t.Fatalf("unexpected capabilities %+v", c)
+	}
+	required := Capabilities{
+		Markets:      []Market{MarketSpot, MarketMargin},
+		OrderTypes:   []OrderType{OrderTypeOCO, OrderTypeTrailingStop},
+		TimeInForces: []TimeInForce{TimeInForcePostOnly},
+		Periods:      []Period{Period1Min, Period1Week},
+	}
+	if err := c.Require(required); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Require(Capabilities{Markets: []Market{MarketFuture}}); err == nil {
+		t.Fatal("future market is not supported")
+	}
+}
+
+func TestBinanceEx_MarketData(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+
+	mi, err := b.GetMarketInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := mi.Infos[testPair.SetMarket(MarketMargin)]
+	if !ok || !info.Enabled || info.UnitPrecision != 3 || info.QuotePrecision != 2 || !info.LotStep.Equal(decimals.NewFromFloat64(0.001)) {
+		t.Fatalf("unexpected pair info %+v", info)
+	}
+
+	depth, err := b.GetDepth(MarketSpot, testPair, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Buys) != 1 || !depth.Buys[0].Price.Equal(decimals.NewFromInt(100)) || !depth.Sells[0].Price.Equal(decimals.NewFromInt(101)) {
+		t.Fatalf("unexpected depth %s", depth.String())
+	}
+	if _, err := b.GetDepth(MarketPerp, testPair, 1); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	if _, err := b.GetDepth(MarketSpot, NewPair("ETH", "USDT"), 1); !errors.Is(err, ErrMarketClosed) {
+		t.Fatalf("ErrMarketClosed expected for unknown symbol, got %v", err)
+	}
+
+	s.SetTick(testPair, Tick{Time: time.Now(), Last: decimals.NewFromInt(100)})
+	ticks, err := b.GetTicks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick, ok := ticks[testPair.SetMarket(MarketSpot)]; !ok || !tick.Last.Equal(decimals.NewFromInt(100)) {
+		t.Fatalf("tick of %s expected, got %v", testPair, ticks)
+	}
+
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.AddKDots(testPair, "1m", time.Minute, []KDot{
+		{Time: begin, Open: decimals.NewFromInt(1), High: decimals.NewFromInt(2), Low: decimals.NewFromInt(1), Close: decimals.NewFromInt(2), Volume: decimals.NewFromInt(10)},
+		{Time: begin.Add(time.Minute), Open: decimals.NewFromInt(2), High: decimals.NewFromInt(3), Low: decimals.NewFromInt(2), Close: decimals.NewFromInt(3), Volume: decimals.NewFromInt(20)},
+	})
+	since := begin.Add(time.Minute)
+	k, err := b.GetKline(MarketSpot, testPair, Period1Min, &since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Items) != 1 || !k.Items[0].Time.Equal(since) || !k.Items[0].Volume.Equal(decimals.NewFromInt(20)) {
+		t.Fatalf("unexpected kline %+v", k.Items)
+	}
+
+	s.AddFills(testPair, []Fill{
+		{Id: 1, Time: begin, Price: decimals.NewFromInt(100), UnitQty: decimals.One, Side: "buy"},
+		{Id: 2, Time: begin, Price: decimals.NewFromInt(99), UnitQty: decimals.One, Side: "sell"},
+	})
+	fromId := int64(2)
+	fills, err := b.GetFills(MarketSpot, testPair, &fromId, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 1 || fills[0].Id != 2 || fills[0].Side != "sell" {
+		t.Fatalf("unexpected fills %+v", fills)
+	}
+}
+
+func TestBinanceEx_Trade(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+
+	id, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(2), decimals.NewFromInt(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := b.GetOpenOrders(MarketSpot, testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].Id != *id || orders[0].Status != TradeStatusNew || orders[0].TypeSide != TradeTypeSideLimitBuy {
+		t.Fatalf("unexpected open orders %v", orders)
+	}
+	acc, err := b.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Spot["USDT"].Locked.Equal(decimals.NewFromInt(180)) {
+		t.Fatalf("180 USDT locked expected, got %s", acc.Spot["USDT"].Locked.String())
+	}
+
+	orderId, _ := strconv.ParseInt(id.StrId(), 10, 64)
+	if !s.FillOrder(orderId) {
+		t.Fatal("resting order should be filled")
+	}
+	order, err := b.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != TradeStatusFilled || !order.AvgPrice.Equal(decimals.NewFromInt(90)) {
+		t.Fatalf("unexpected order %s", order.String())
+	}
+	if err := b.CancelOrder(*id); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("ErrOrderNotFound expected, got %v", err)
+	}
+
+	if _, err := b.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.NewFromInt(100), decimals.Zero); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("ErrInsufficientBalance expected, got %v", err)
+	}
+	if _, err := b.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.NewFromFloat64(0.0001), decimals.Zero); !errors.Is(err, ErrInvalidLot) {
+		t.Fatalf("ErrInvalidLot expected, got %v", err)
+	}
+	all, err := b.GetAllOrders(MarketSpot, testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("1 order expected, got %d", len(all))
+	}
+}
+
+func TestBinanceEx_Margin(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	s.SetBorrowable("USDT", decimals.NewFromInt(500))
+
+	if err := b.Transfer("USDT", decimals.NewFromInt(100), MarketMargin); err != nil {
+		t.Fatal(err)
+	}
+	borrowable, err := b.GetBorrowable("USDT")
+	if err != nil || !borrowable.Equal(decimals.NewFromInt(500)) {
+		t.Fatalf("500 borrowable expected, got %s, err %v", borrowable.String(), err)
+	}
+	if err := b.Borrow("USDT", decimals.NewFromInt(200)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Repay("USDT", decimals.NewFromInt(50)); err != nil {
+		t.Fatal(err)
+	}
+	acc, err := b.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	margin := acc.Margin["USDT"]
+	if !margin.Free.Equal(decimals.NewFromInt(250)) || !margin.Borrowed.Equal(decimals.NewFromInt(150)) {
+		t.Fatalf("unexpected margin balance free %s borrowed %s", margin.Free.String(), margin.Borrowed.String())
+	}
+	if !acc.Spot["USDT"].Free.Equal(decimals.NewFromInt(900)) {
+		t.Fatalf("900 USDT in spot expected, got %s", acc.Spot["USDT"].Free.String())
+	}
+
+	id, err := b.Trade(MarketMargin, testPair, TradeTypeSideMarketBuy, decimals.One, decimals.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Market() != MarketMargin {
+		t.Fatalf("margin order id expected, got %s", id.String())
+	}
+}
+
+func TestBinanceEx_AuthFailed(t *testing.T) {
+	s := NewFakeServer()
+	defer s.Close()
+	b, err := NewBinanceEx(DefaultConfig(), FakeApiKey, "wrong secret", "", Option{Endpoint: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetAccount(); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("ErrAuthFailed expected, got %v", err)
+	}
+}
+
+func TestBinanceEx_TradeWithOptions(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	s.SetBalance(MarketSpot, "BTC", decimals.NewFromInt(2))
+
+	// bids: 100, 99, asks: 101, 102
+	if _, err := b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(101), WithTimeInForce(TimeInForcePostOnly))); err == nil {
+		t.Fatal("crossing post only order should be rejected")
+	}
+	id, err := b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90), WithTimeInForce(TimeInForceIOC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, err := b.GetOrder(*id); err != nil || order.Status != TradeStatusExpired {
+		t.Fatalf("IOC order should expire, got %v, err %v", order, err)
+	}
+
+	id, err = b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(95), WithStop(decimals.NewFromInt(96), false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, err := b.GetOrder(*id); err != nil || order.Status != TradeStatusNew || order.TypeSide != TradeTypeSideLimitSell {
+		t.Fatalf("resting stop limit sell expected, got %v, err %v", order, err)
+	}
+
+	// take profit at 120, stop loss at 90
+	id, err = b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(120), WithOCO(decimals.NewFromInt(90), decimals.NewFromInt(89))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := b.GetOpenOrders(MarketSpot, testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 3 {
+		t.Fatalf("stop limit and 2 OCO legs expected, got %d orders", len(orders))
+	}
+	if err := b.CancelOrder(*id); err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ := b.GetOpenOrders(MarketSpot, testPair); len(orders) != 1 {
+		t.Fatalf("canceling one OCO leg should cancel both, %d open orders left", len(orders))
+	}
+	if blc := s.Balance(MarketSpot, "BTC"); !blc.Free.Equal(decimals.One) || !blc.Locked.Equal(decimals.One) {
+		t.Fatalf("1 BTC locked by stop limit expected, got free %s locked %s", blc.Free.String(), blc.Locked.String())
+	}
+
+	if _, err := b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideMarketSell, decimals.One, decimals.Zero, WithReduceOnly())); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}
+
+func TestBinanceEx_ClientId(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	clientId := NewClientOrderId()
+	req := NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90), WithClientId(clientId))
+
+	id, err := b.TradeWithOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.ClientId() != clientId {
+		t.Fatalf("client id should be embedded in %s", id)
+	}
+	order, err := b.GetOrderByClientId(MarketSpot, testPair, clientId)
+	if err != nil || order.Id != *id {
+		t.Fatalf("order %s expected, got %v, err %v", id, order, err)
+	}
+	if order, err := b.GetOrder(*id); err != nil || order.Id != *id {
+		t.Fatalf("order %s expected, got %v, err %v", id, order, err)
+	}
+
+	// resubmission after the order is filled still places nothing
+	strId, _ := strconv.ParseInt(id.StrId(), 10, 64)
+	if !s.FillOrder(strId) {
+		t.Fatal("order should be open")
+	}
+	again, err := b.TradeWithOptions(req)
+	if err != nil || *again != *id {
+		t.Fatalf("resubmission should return %s, got %v, err %v", id, again, err)
+	}
+	if orders, _ := b.GetAllOrders(MarketSpot, testPair); len(orders) != 1 {
+		t.Fatalf("1 order expected, got %d", len(orders))
+	}
+	if _, err := b.GetOrderByClientId(MarketSpot, testPair, NewClientOrderId()); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("ErrOrderNotFound expected, got %v", err)
+	}
+}
+
+func TestBinanceEx_CancelAllOrders(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	s.SetBalance(MarketSpot, "BTC", decimals.NewFromInt(2))
+
+	if _, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(120), WithOCO(decimals.NewFromInt(95), decimals.Zero))); err != nil {
+		t.Fatal(err)
+	}
+	open, err := b.GetOpenOrders(MarketSpot, testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled, err := ex.CancelAllOrders(b, MarketSpot, testPair, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 3 {
+		t.Fatalf("limit order and 2 OCO legs expected, got %v", canceled)
+	}
+	// canceled ids are the same as placed ones
+	for _, order := range open {
+		found := false
+		for _, id := range canceled {
+			found = found || id == order.Id
+		}
+		if !found {
+			t.Fatalf("open order(%s) not in canceled %v", order.Id.String(), canceled)
+		}
+	}
+	if orders, _ := b.GetOpenOrders(MarketSpot, testPair); len(orders) != 0 {
+		t.Fatalf("no open order expected, got %d", len(orders))
+	}
+	if blc := s.Balance(MarketSpot, "BTC"); !blc.Free.EqualInt(2) || blc.Locked.IsPositive() {
+		t.Fatalf("all BTC should be freed, got free %s locked %s", blc.Free.String(), blc.Locked.String())
+	}
+	if blc := s.Balance(MarketSpot, "USDT"); !blc.Free.EqualInt(1000) {
+		t.Fatalf("all USDT should be freed, got %s", blc.Free.String())
+	}
+
+	// kill switch finds orders not placed through it by one request
+	if _, err := b.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+	all, err := b.GetAllOpenOrders(MarketSpot)
+	if err != nil || len(all) != 1 || all[0].Id.Pair() != testPair {
+		t.Fatalf("1 open order expected, got %v, err %v", all, err)
+	}
+	r, err := ex.NewRiskEx(b, ex.RiskLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceled, err := r.Kill(); err != nil || len(canceled) != 1 || canceled[0] != all[0].Id {
+		t.Fatalf("open order should be killed, got %v, err %v", canceled, err)
+	}
+}
+
+func TestBinanceEx_Wallet(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	s.SetWithdrawFee("USDT", decimals.One)
+	s.AddDeposit("USDT", "TRX", decimals.NewFromInt(500), "0xdeposit")
+
+	address, err := b.GetDepositAddress("USDT", "TRX")
+	if err != nil || address.Verify() != nil || address.Network != "TRX" {
+		t.Fatalf("deposit address expected, got %v, err %v", address, err)
+	}
+	deposits, err := b.GetDeposits("USDT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 1 || deposits[0].Kind != TransferKindDeposit || deposits[0].Status != TransferStatusSuccess ||
+		deposits[0].TxHash != "0xdeposit" || !deposits[0].Amount.EqualInt(500) {
+		t.Fatalf("unexpected deposits %v", deposits)
+	}
+
+	if _, err := b.Withdraw(decimals.NewFromInt(1500), DepositAddress{Asset: "USDT", Network: "TRX", Address: "Txyz"}); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("ErrInsufficientBalance expected, got %v", err)
+	}
+	id, err := b.Withdraw(decimals.NewFromInt(100), DepositAddress{Asset: "USDT", Network: "TRX", Address: "Txyz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blc := s.Balance(MarketSpot, "USDT"); !blc.Free.EqualInt(1399) {
+		t.Fatalf("1399 USDT left expected, got %s", blc.Free.String())
+	}
+	since := time.Now().Add(-time.Minute)
+	withdrawals, err := b.GetWithdrawals("", &since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].Id != id || withdrawals[0].Status != TransferStatusSuccess ||
+		!withdrawals[0].Fee.Equal(decimals.One) || withdrawals[0].Address != "Txyz" || withdrawals[0].TxHash == "" {
+		t.Fatalf("unexpected withdrawals %v", withdrawals)
+	}
+
+	if err := b.Transfer("USDT", decimals.NewFromInt(10), MarketMargin); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Transfer("USDT", decimals.NewFromInt(4), MarketSpot); err != nil {
+		t.Fatal(err)
+	}
+	transfers, err := b.GetTransfers("USDT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 || transfers[0].From != MarketSpot || !transfers[0].Amount.EqualInt(10) || transfers[1].To != MarketSpot {
+		t.Fatalf("unexpected transfers %v", transfers)
+	}
+	if transfers, _ := b.GetTransfers("BTC", nil); len(transfers) != 0 {
+		t.Fatalf("no BTC transfer expected, got %v", transfers)
+	}
+
+	// more than the default page of 10 and the max page of 100
+	for i := 0; i < 103; i++ {
+		if err := b.Transfer("USDT", decimals.One, MarketMargin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transfers, err = b.GetTransfers("USDT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 105 || !transfers[0].Amount.EqualInt(10) || !transfers[104].Amount.EqualInt(1) {
+		t.Fatalf("105 transfers expected, got %d", len(transfers))
+	}
+
+	// since longer ago than a request covers, and more than a page of deposits
+	for i := 0; i < 1001; i++ {
+		s.AddDeposit("USDT", "TRX", decimals.One, "0xmore"+strconv.Itoa(i))
+	}
+	since = time.Now().Add(-200 * 24 * time.Hour)
+	if deposits, err = b.GetDeposits("USDT", &since); err != nil || len(deposits) != 1002 || deposits[0].TxHash != "0xdeposit" {
+		t.Fatalf("1002 deposits expected, got %d, err %v", len(deposits), err)
+	}
+	if withdrawals, err = b.GetWithdrawals("", &since); err != nil || len(withdrawals) != 1 {
+		t.Fatalf("1 withdrawal expected, got %d, err %v", len(withdrawals), err)
+	}
+	if transfers, err = b.GetTransfers("USDT", &since); err != nil || len(transfers) != 105 {
+		t.Fatalf("105 transfers expected, got %d, err %v", len(transfers), err)
+	}
+}
+
+func TestBinanceEx_GetFillsByTime(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var fills []Fill
+	for id := int64(1); id <= 5; id++ {
+		fills = append(fills, Fill{Id: id, Time: begin.Add(time.Duration(id) * time.Minute), Price: decimals.NewFromInt(100), UnitQty: decimals.One, Side: "buy"})
+	}
+	s.AddFills(testPair, fills)
+
+	r, err := b.GetFillsByTime(MarketSpot, testPair, begin.Add(2*time.Minute), 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 || r[0].Id != 2 || r[1].Id != 3 {
+		t.Fatalf("unexpected fills %+v", r)
+	}
+	r, err = b.GetFillsByTime(MarketSpot, testPair, begin.Add(time.Hour), time.Minute)
+	if err != nil || len(r) != 0 {
+		t.Fatalf("unexpected fills %+v %v", r, err)
+	}
+	if _, err := b.GetFillsByTime(MarketSpot, testPair, begin, 2*time.Hour); err == nil {
+		t.Fatal("window over an hour accepted")
+	}
+}
+
+func TestBinanceEx_ServerTime(t *testing.T) {
+	b, s := newTestBinanceEx(t)
+	s.SetClockOffset(30 * time.Second)
+	if _, err := b.GetAccount(); !errors.Is(err, ErrClockSkew) {
+		t.Fatalf("ErrClockSkew expected, got %v", err)
+	}
+
+	server, err := b.GetServerTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := server.Sub(time.Now().UTC()); d < 29*time.Second || d > 31*time.Second {
+		t.Fatalf("server time %s is %s ahead, 30s expected", server, d)
+	}
+
+	sc, err := ex.NewSyncedClock(b, ex.SyncOption{MaxDrift: 5 * time.Second, BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := sc.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset.Offset < 29*time.Second || offset.Offset > 31*time.Second {
+		t.Fatalf("offset %s, 30s expected", offset.Offset)
+	}
+	select {
+	case alert := <-sc.Alerts():
+		if alert.Offset != offset.Offset {
+			t.Fatalf("alert %+v of another offset", alert)
+		}
+	default:
+		t.Fatal("no alert of drift")
+	}
+	b.Config().Clock = sc
+	if _, err := b.GetAccount(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,195 @@
+package binance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultEndpoint = "https://api.binance.com"
+
+	defaultRecvWindow = 5 * time.Second
+	defaultTimeout    = 30 * time.Second
+)
+
+type (
+	// error body of Binance API
+	apiError struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+
+	client struct {
+		endpoint   string
+		apiKey     string
+		apiSecret  string
+		httpClient *http.Client
+		now        func() time.Time
+	}
+)
+
+func newClient(endpoint, apiKey, apiSecret, proxy string, httpClient *http.Client, now func() time.Time) (*client, error) {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if httpClient == nil {
+		transport := &http.Transport{}
+		if proxy != "" {
+			proxyURL, err := url.Parse(proxy)
+			if err != nil {
+				return nil, errorz.Errorf("invalid proxy(%s): %s", proxy, err.Error())
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		httpClient = &http.Client{Transport: transport, Timeout: defaultTimeout}
+	}
+	return &client{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		httpClient: httpClient,
+		now:        now,
+	}, nil
+}
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// send request and decode response into result
+// signed requests carry timestamp and HMAC SHA256 signature of query string, all params are sent in query string
+func (c *client) do(method, path string, params url.Values, signed bool, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	query := params.Encode()
+	if signed {
+		if c.apiKey == "" || c.apiSecret == "" {
+			return NewExError(ErrorKindAuthFailed, "api key and secret required by %s", path)
+		}
+		params.Set("recvWindow", strconv.FormatInt(int64(defaultRecvWindow/time.Millisecond), 10))
+		params.Set("timestamp", strconv.FormatInt(toMillis(c.now()), 10))
+		query = params.Encode()
+		query += "&signature=" + sign(c.apiSecret, query)
+	}
+
+	u := c.endpoint + path
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-MBX-APIKEY", c.apiKey)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return WrapExError(ErrorKindNetworkTransient, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return WrapExError(ErrorKindNetworkTransient, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp.StatusCode, body)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return errorz.Errorf("invalid response of %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// map HTTP status and Binance error code to ErrorKind
+func parseError(status int, body []byte) error {
+	ae := apiError{}
+	_ = json.Unmarshal(body, &ae)
+	msg := ae.Msg
+	if msg == "" {
+		msg = strings.TrimSpace(string(body))
+	}
+	msg = "binance: " + msg
+
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusTeapot: // 418 means IP banned for ignoring 429
+		return NewExError(ErrorKindRateLimited, "%s", msg)
+	case status >= http.StatusInternalServerError:
+		return NewExError(ErrorKindNetworkTransient, "%s", msg)
+	case status == http.StatusUnauthorized:
+		return NewExError(ErrorKindAuthFailed, "%s", msg)
+	}
+
+	switch ae.Code {
+	case -1003: // too many requests
+		return NewExError(ErrorKindRateLimited, "%s", msg)
+	case -1001, -1007: // disconnected, timeout waiting for backend
+		return NewExError(ErrorKindNetworkTransient, "%s", msg)
+	case -1021: // timestamp outside of recvWindow
+		return NewExError(ErrorKindClockSkew, "%s", msg)
+	case -1002, -1022, -2014, -2015: // unauthorized, invalid signature, bad api key
+		return NewExError(ErrorKindAuthFailed, "%s", msg)
+	case -1013, -1111, -1100: // filter failure, bad precision, illegal characters
+		return NewExError(ErrorKindInvalidLot, "%s", msg)
+	case -2011, -2013: // cancel rejected, order does not exist
+		if strings.Contains(strings.ToLower(ae.Msg), "unknown order") || ae.Code == -2013 {
+			return NewExError(ErrorKindOrderNotFound, "%s", msg)
+		}
+	case -1121: // invalid symbol
+		return NewExError(ErrorKindMarketClosed, "%s", msg)
+	case -2010, -3041, -4026: // new order rejected, balance not enough, withdraw balance not enough
+		if strings.Contains(strings.ToLower(ae.Msg), "insufficient") || ae.Code == -3041 || ae.Code == -4026 {
+			return NewExError(ErrorKindInsufficientBalance, "%s", msg)
+		}
+		if strings.Contains(strings.ToLower(ae.Msg), "market is closed") {
+			return NewExError(ErrorKindMarketClosed, "%s", msg)
+		}
+	}
+	return errorz.Errorf("%s (http %d, code %d)", msg, status, ae.Code)
+}
+
+// new order rejected because client order id is taken by an open order
+func isDuplicateOrder(err error) bool {
+	return ErrorKindOf(err) == ErrorKindUnknown && strings.Contains(strings.ToLower(err.Error()), "duplicate order")
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func parseDecimal(s string) (decimals.Decimal, error) {
+	if s == "" {
+		return decimals.Zero, nil
+	}
+	return decimals.NewFromString(s)
+}
+
+// digits after decimal point of step like "0.00100000"
+func precisionOf(step string) int {
+	i := strings.Index(step, ".")
+	if i < 0 {
+		return 0
+	}
+	return len(strings.TrimRight(step[i+1:], "0"))
+}
@@ -0,0 +1,58 @@
+package binance
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"time"
+)
+
+// DefaultConfig is the ExConfig preset of Binance spot and margin API
+func DefaultConfig() ExConfig {
+	return ExConfig{
+		Name:                  Binance,
+		MaxDepth:              5000,
+		PairDelimiter:         "",
+		PairDelimiterLeftTail: []string{"UP", "DOWN", "BULL", "BEAR"}, // leveraged tokens like XRPBULL/USDT
+		PairNormalOrder:       true,
+		PairUpperCase:         true,
+		PairsSeparator:        ",",
+		Periods: map[Period]string{
+			Period1Min:        "1m",
+			Period3Min:        "3m",
+			Period5Min:        "5m",
+			Period15Min:       "15m",
+			Period30Min:       "30m",
+			Period1Hour:       "1h",
+			Period2Hour:       "2h",
+			Period4Hour:       "4h",
+			Period6Hour:       "6h",
+			Period8Hour:       "8h",
+			Period12Hour:      "12h",
+			Period1Day:        "1d",
+			Period1Week:       "1w",
+			Period1MonthFUZZY: "1M",
+		},
+		TradeStatus: map[TradeStatus]string{
+			TradeStatusNew:             "NEW",
+			TradeStatusPartiallyFilled: "PARTIALLY_FILLED",
+			TradeStatusFilled:          "FILLED",
+			TradeStatusCanceled:        "CANCELED",
+			TradeStatusCanceling:       "PENDING_CANCEL",
+			TradeStatusRejected:        "REJECTED",
+			TradeStatusExpired:         "EXPIRED",
+		},
+		// side is sent separately
+		TradeTypes: map[TradeTypeSide]string{
+			TradeTypeSideLimitBuy:   "LIMIT",
+			TradeTypeSideLimitSell:  "LIMIT",
+			TradeTypeSideMarketBuy:  "MARKET",
+			TradeTypeSideMarketSell: "MARKET",
+		},
+		RateLimit:      100 * time.Millisecond, // 1200 request weight per minute
+		FillRateLimit:  500 * time.Millisecond,
+		KlineRateLimit: 200 * time.Millisecond,
+		MakerFee:       decimals.NewFromFloat64(0.001),
+		TakerFee:       decimals.NewFromFloat64(0.001),
+		MarketEnabled:  map[Market]bool{MarketSpot: true, MarketMargin: true},
+	}
+}
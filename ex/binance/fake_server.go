@@ -0,0 +1,1013 @@
+package binance
+
+import (
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FakeApiKey    = "fake-api-key"
+	FakeApiSecret = "fake-api-secret"
+)
+
+type (
+	// FakeServer is an in-memory stand-in of Binance REST API for offline tests, use its URL as Option.Endpoint.
+	// Market orders fill at once at best book price, limit orders rest until FillOrder.
+	FakeServer struct {
+		*httptest.Server
+		mu         sync.Mutex
+		symbols    map[string]fakeSymbol
+		balances   map[Market]map[string]Balance
+		borrowable map[string]decimals.Decimal
+		depths     map[string]depthResp
+		ticks      map[string]tickResp
+		trades     map[string][]tradeResp
+		klines     map[string][][]interface{} // symbol + interval
+		orders     map[int64]*fakeOrder
+		fees       map[string]decimals.Decimal // withdraw fees
+		deposits   []depositResp
+		withdraws  []withdrawResp
+		transfers  []transferResp
+		seq        int64
+		offset     time.Duration // server clock ahead of local clock
+	}
+
+	fakeSymbol struct {
+		pair     Pair
+		minQty   string
+		stepSize string
+		tickSize string
+		margin   bool
+	}
+
+	fakeOrder struct {
+		market Market
+		pair   Pair
+		resp   orderResp
+		locked decimals.Decimal // quote locked by buy order, unit locked by sell order
+		listId int64            // legs of an OCO share the same list id, zero if not OCO
+	}
+)
+
+func NewFakeServer() *FakeServer {
+	s := &FakeServer{
+		symbols:    map[string]fakeSymbol{},
+		balances:   map[Market]map[string]Balance{MarketSpot: {}, MarketMargin: {}},
+		borrowable: map[string]decimals.Decimal{},
+		depths:     map[string]depthResp{},
+		ticks:      map[string]tickResp{},
+		trades:     map[string][]tradeResp{},
+		klines:     map[string][][]interface{}{},
+		orders:     map[int64]*fakeOrder{},
+		fees:       map[string]decimals.Decimal{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/time", s.public(s.serverTime))
+	mux.HandleFunc("/api/v3/exchangeInfo", s.public(s.exchangeInfo))
+	mux.HandleFunc("/api/v3/depth", s.public(s.depth))
+	mux.HandleFunc("/api/v3/ticker/24hr", s.public(s.ticker))
+	mux.HandleFunc("/api/v3/klines", s.public(s.kline))
+	mux.HandleFunc("/api/v3/trades", s.public(s.recentTrades))
+	mux.HandleFunc("/api/v3/historicalTrades", s.public(s.historicalTrades))
+	mux.HandleFunc("/api/v3/aggTrades", s.public(s.aggTrades))
+	mux.HandleFunc("/api/v3/account", s.signed(s.account(MarketSpot)))
+	mux.HandleFunc("/sapi/v1/margin/account", s.signed(s.account(MarketMargin)))
+	mux.HandleFunc("/sapi/v1/margin/maxBorrowable", s.signed(s.maxBorrowable))
+	mux.HandleFunc("/sapi/v1/margin/loan", s.signed(s.loan))
+	mux.HandleFunc("/sapi/v1/margin/repay", s.signed(s.repay))
+	mux.HandleFunc("/sapi/v1/margin/transfer", s.signed(s.transfer))
+	mux.HandleFunc("/sapi/v1/capital/deposit/address", s.signed(s.depositAddress))
+	mux.HandleFunc("/sapi/v1/capital/deposit/hisrec", s.signed(s.depositHistory))
+	mux.HandleFunc("/sapi/v1/capital/withdraw/apply", s.signed(s.withdraw))
+	mux.HandleFunc("/sapi/v1/capital/withdraw/history", s.signed(s.withdrawHistory))
+	mux.HandleFunc("/api/v3/order", s.signed(s.order(MarketSpot)))
+	mux.HandleFunc("/sapi/v1/margin/order", s.signed(s.order(MarketMargin)))
+	mux.HandleFunc("/api/v3/order/oco", s.signed(s.oco(MarketSpot)))
+	mux.HandleFunc("/sapi/v1/margin/order/oco", s.signed(s.oco(MarketMargin)))
+	mux.HandleFunc("/api/v3/openOrders", s.signed(s.listOrders(MarketSpot, true)))
+	mux.HandleFunc("/sapi/v1/margin/openOrders", s.signed(s.listOrders(MarketMargin, true)))
+	mux.HandleFunc("/api/v3/allOrders", s.signed(s.listOrders(MarketSpot, false)))
+	mux.HandleFunc("/sapi/v1/margin/allOrders", s.signed(s.listOrders(MarketMargin, false)))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func fakeSymbolOf(pair Pair) string {
+	return pair.Unit() + pair.Quote()
+}
+
+func (s *FakeServer) AddSymbol(pair Pair, minQty, stepSize, tickSize string, margin bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.symbols[fakeSymbolOf(pair)] = fakeSymbol{pair: pair, minQty: minQty, stepSize: stepSize, tickSize: tickSize, margin: margin}
+}
+
+func (s *FakeServer) SetBalance(market Market, asset string, free decimals.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blc := s.balances[market][asset]
+	blc.Free = free
+	s.balances[market][asset] = blc
+}
+
+func (s *FakeServer) Balance(market Market, asset string) Balance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.balances[market][asset]
+}
+
+// server clock runs offset ahead of local clock, signed requests out of recvWindow of it are rejected
+func (s *FakeServer) SetClockOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+}
+
+func (s *FakeServer) SetBorrowable(asset string, amount decimals.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.borrowable[asset] = amount
+}
+
+// price and amount pairs
+func (s *FakeServer) SetWithdrawFee(asset string, fee decimals.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fees[asset] = fee
+}
+
+// credit spot balance by a finished deposit
+func (s *FakeServer) AddDeposit(asset, network string, amount decimals.Decimal, txHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blc := s.balances[MarketSpot][asset]
+	blc.Free = blc.Free.Add(amount)
+	s.balances[MarketSpot][asset] = blc
+	s.seq++
+	s.deposits = append(s.deposits, depositResp{
+		Id:         strconv.FormatInt(s.seq, 10),
+		Amount:     amount.String(),
+		Coin:       asset,
+		Network:    network,
+		Status:     1,
+		Address:    fakeAddressOf(asset, network),
+		TxId:       txHash,
+		InsertTime: toMillis(time.Now()),
+	})
+}
+
+func (s *FakeServer) SetDepth(pair Pair, bids, asks [][2]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.depths[fakeSymbolOf(pair)] = depthResp{Bids: bids, Asks: asks}
+}
+
+func (s *FakeServer) SetTick(pair Pair, tick Tick) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	symbol := fakeSymbolOf(pair)
+	s.ticks[symbol] = tickResp{
+		Symbol:    symbol,
+		LastPrice: tick.Last.String(),
+		BidPrice:  tick.Buy.String(),
+		AskPrice:  tick.Sell.String(),
+		HighPrice: tick.High.String(),
+		LowPrice:  tick.Low.String(),
+		Volume:    tick.Volume.String(),
+		CloseTime: toMillis(tick.Time),
+	}
+}
+
+func (s *FakeServer) AddFills(pair Pair, fills []Fill) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	symbol := fakeSymbolOf(pair)
+	for _, f := range fills {
+		s.trades[symbol] = append(s.trades[symbol], tradeResp{Id: f.Id, Price: f.Price.String(), Qty: f.UnitQty.String(), Time: toMillis(f.Time), IsBuyerMaker: f.Side == "sell"})
+	}
+}
+
+// interval: Binance interval like "1m"
+func (s *FakeServer) AddKDots(pair Pair, interval string, period time.Duration, dots []KDot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fakeSymbolOf(pair) + interval
+	for _, d := range dots {
+		s.klines[key] = append(s.klines[key], []interface{}{
+			toMillis(d.Time), d.Open.String(), d.High.String(), d.Low.String(), d.Close.String(), "0",
+			toMillis(d.Time.Add(period)) - 1, d.Volume.String(),
+		})
+	}
+}
+
+// fill resting order fully at its price, or stop price if it has no limit price,
+// the other leg of OCO expires
+func (s *FakeServer) FillOrder(orderId int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderId]
+	if !ok || o.resp.Status != "NEW" {
+		return false
+	}
+	price, _ := parseDecimal(o.resp.Price)
+	if !price.IsPositive() {
+		price, _ = parseDecimal(o.resp.StopPrice)
+	}
+	s.fill(o, price)
+	return true
+}
+
+// unlock balance held by o and its OCO legs, other legs get status, locked amount is moved out of Locked
+func (s *FakeServer) release(o *fakeOrder, status string) decimals.Decimal {
+	legs := []*fakeOrder{o}
+	if o.listId != 0 {
+		legs = nil
+		for _, leg := range s.orders {
+			if leg.listId == o.listId {
+				legs = append(legs, leg)
+			}
+		}
+	}
+	locked := decimals.Zero
+	for _, leg := range legs {
+		locked = locked.Add(leg.locked)
+		leg.locked = decimals.Zero
+		if leg != o && leg.resp.Status == "NEW" {
+			leg.resp.Status = status
+		}
+	}
+	asset := o.pair.Unit()
+	if o.resp.Side == "BUY" {
+		asset = o.pair.Quote()
+	}
+	blc := s.balances[o.market][asset]
+	blc.Locked = blc.Locked.Sub(locked)
+	s.balances[o.market][asset] = blc
+	return locked
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Code: code, Msg: msg})
+}
+
+func (s *FakeServer) public(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// check api key and signature like Binance
+func (s *FakeServer) signed(h http.HandlerFunc) http.HandlerFunc {
+	return s.public(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != FakeApiKey {
+			writeError(w, http.StatusUnauthorized, -2015, "Invalid API-key, IP, or permissions for action.")
+			return
+		}
+		raw := r.URL.RawQuery
+		i := strings.LastIndex(raw, "&signature=")
+		if i < 0 || sign(FakeApiSecret, raw[:i]) != raw[i+len("&signature="):] {
+			writeError(w, http.StatusBadRequest, -1022, "Signature for this request is not valid.")
+			return
+		}
+		ts, err := strconv.ParseInt(r.URL.Query().Get("timestamp"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'timestamp' was not sent.")
+			return
+		}
+		window, err := strconv.ParseInt(r.URL.Query().Get("recvWindow"), 10, 64)
+		if err != nil {
+			window = 5000
+		}
+		if now := toMillis(time.Now().Add(s.offset)); ts > now+1000 || now-ts > window {
+			writeError(w, http.StatusBadRequest, -1021, "Timestamp for this request is outside of the recvWindow.")
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *FakeServer) symbolOf(w http.ResponseWriter, r *http.Request) (fakeSymbol, bool) {
+	sym, ok := s.symbols[r.URL.Query().Get("symbol")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+	}
+	return sym, ok
+}
+
+func (s *FakeServer) serverTime(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, serverTimeResp{ServerTime: toMillis(time.Now().Add(s.offset))})
+}
+
+func (s *FakeServer) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	var symbols []interface{}
+	for symbol, sym := range s.symbols {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":                 symbol,
+			"status":                 "TRADING",
+			"baseAsset":              sym.pair.Unit(),
+			"quoteAsset":             sym.pair.Quote(),
+			"isSpotTradingAllowed":   true,
+			"isMarginTradingAllowed": sym.margin,
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "tickSize": sym.tickSize},
+				{"filterType": "LOT_SIZE", "minQty": sym.minQty, "stepSize": sym.stepSize},
+			},
+		})
+	}
+	writeJSON(w, map[string]interface{}{"symbols": symbols})
+}
+
+func (s *FakeServer) depth(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.symbolOf(w, r); !ok {
+		return
+	}
+	d := s.depths[r.URL.Query().Get("symbol")]
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		if len(d.Bids) > limit {
+			d.Bids = d.Bids[:limit]
+		}
+		if len(d.Asks) > limit {
+			d.Asks = d.Asks[:limit]
+		}
+	}
+	writeJSON(w, d)
+}
+
+func (s *FakeServer) ticker(w http.ResponseWriter, r *http.Request) {
+	ticks := []tickResp{}
+	for _, t := range s.ticks {
+		ticks = append(ticks, t)
+	}
+	writeJSON(w, ticks)
+}
+
+func (s *FakeServer) kline(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.symbolOf(w, r); !ok {
+		return
+	}
+	q := r.URL.Query()
+	startTime, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	rows := [][]interface{}{}
+	for _, row := range s.klines[q.Get("symbol")+q.Get("interval")] {
+		if row[0].(int64) >= startTime && (limit <= 0 || len(rows) < limit) {
+			rows = append(rows, row)
+		}
+	}
+	writeJSON(w, rows)
+}
+
+func (s *FakeServer) recentTrades(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.symbolOf(w, r); !ok {
+		return
+	}
+	trades := s.trades[r.URL.Query().Get("symbol")]
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit > 0 && len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+	writeJSON(w, trades)
+}
+
+func (s *FakeServer) historicalTrades(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-MBX-APIKEY") != FakeApiKey {
+		writeError(w, http.StatusUnauthorized, -2015, "Invalid API-key, IP, or permissions for action.")
+		return
+	}
+	if _, ok := s.symbolOf(w, r); !ok {
+		return
+	}
+	fromId, _ := strconv.ParseInt(r.URL.Query().Get("fromId"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	trades := []tradeResp{}
+	for _, t := range s.trades[r.URL.Query().Get("symbol")] {
+		if t.Id >= fromId && (limit <= 0 || len(trades) < limit) {
+			trades = append(trades, t)
+		}
+	}
+	writeJSON(w, trades)
+}
+
+// each trade is an aggregate trade of itself
+func (s *FakeServer) aggTrades(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.symbolOf(w, r); !ok {
+		return
+	}
+	startTime, _ := strconv.ParseInt(r.URL.Query().Get("startTime"), 10, 64)
+	endTime, _ := strconv.ParseInt(r.URL.Query().Get("endTime"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	aggs := []aggTradeResp{}
+	for _, t := range s.trades[r.URL.Query().Get("symbol")] {
+		if t.Time < startTime || (endTime > 0 && t.Time > endTime) || (limit > 0 && len(aggs) >= limit) {
+			continue
+		}
+		aggs = append(aggs, aggTradeResp{Id: t.Id, Price: t.Price, Qty: t.Qty, FirstId: t.Id, LastId: t.Id, Time: t.Time, IsBuyerMaker: t.IsBuyerMaker})
+	}
+	writeJSON(w, aggs)
+}
+
+func (s *FakeServer) account(market Market) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var blcs []balanceResp
+		for asset, blc := range s.balances[market] {
+			blcs = append(blcs, balanceResp{
+				Asset:    asset,
+				Free:     blc.Free.String(),
+				Locked:   blc.Locked.String(),
+				Borrowed: blc.Borrowed.String(),
+				Interest: blc.Interest.String(),
+			})
+		}
+		if market == MarketMargin {
+			writeJSON(w, map[string]interface{}{"userAssets": blcs})
+		} else {
+			writeJSON(w, map[string]interface{}{"balances": blcs})
+		}
+	}
+}
+
+func (s *FakeServer) maxBorrowable(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"amount": s.borrowable[r.URL.Query().Get("asset")].String()})
+}
+
+func (s *FakeServer) amountOf(w http.ResponseWriter, r *http.Request) (string, decimals.Decimal, bool) {
+	amount, err := decimals.NewFromString(r.URL.Query().Get("amount"))
+	if err != nil || !amount.IsPositive() {
+		writeError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'amount'.")
+		return "", decimals.Zero, false
+	}
+	return r.URL.Query().Get("asset"), amount, true
+}
+
+func (s *FakeServer) loan(w http.ResponseWriter, r *http.Request) {
+	asset, amount, ok := s.amountOf(w, r)
+	if !ok {
+		return
+	}
+	if s.borrowable[asset].LessThan(amount) {
+		writeError(w, http.StatusBadRequest, -3006, "Your borrow amount has exceed maximum borrow amount.")
+		return
+	}
+	s.borrowable[asset] = s.borrowable[asset].Sub(amount)
+	blc := s.balances[MarketMargin][asset]
+	blc.Free = blc.Free.Add(amount)
+	blc.Borrowed = blc.Borrowed.Add(amount)
+	s.balances[MarketMargin][asset] = blc
+	s.seq++
+	writeJSON(w, map[string]int64{"tranId": s.seq})
+}
+
+func (s *FakeServer) repay(w http.ResponseWriter, r *http.Request) {
+	asset, amount, ok := s.amountOf(w, r)
+	if !ok {
+		return
+	}
+	blc := s.balances[MarketMargin][asset]
+	if blc.Free.LessThan(amount) {
+		writeError(w, http.StatusBadRequest, -3041, "Balance is not enough")
+		return
+	}
+	pay := decimals.Min(blc.Borrowed, amount)
+	blc.Free = blc.Free.Sub(pay)
+	blc.Borrowed = blc.Borrowed.Sub(pay)
+	s.balances[MarketMargin][asset] = blc
+	s.seq++
+	writeJSON(w, map[string]int64{"tranId": s.seq})
+}
+
+// POST to transfer, GET for history
+func (s *FakeServer) transfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.transferHistory(w, r)
+		return
+	}
+	asset, amount, ok := s.amountOf(w, r)
+	if !ok {
+		return
+	}
+	from, to := MarketSpot, MarketMargin
+	if r.URL.Query().Get("type") == "2" {
+		from, to = MarketMargin, MarketSpot
+	}
+	src, dst := s.balances[from][asset], s.balances[to][asset]
+	if src.Free.LessThan(amount) {
+		writeError(w, http.StatusBadRequest, -3041, "Balance is not enough")
+		return
+	}
+	src.Free = src.Free.Sub(amount)
+	dst.Free = dst.Free.Add(amount)
+	s.balances[from][asset], s.balances[to][asset] = src, dst
+	s.seq++
+	typ := "ROLL_IN"
+	if from == MarketMargin {
+		typ = "ROLL_OUT"
+	}
+	s.transfers = append(s.transfers, transferResp{TxId: s.seq, Asset: asset, Amount: amount.String(), Status: "CONFIRMED", Type: typ, Timestamp: toMillis(time.Now())})
+	writeJSON(w, map[string]int64{"tranId": s.seq})
+}
+
+func (s *FakeServer) order(market Market) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.newOrder(market, w, r)
+		case http.MethodGet, http.MethodDelete:
+			o, ok := s.findOrder(r)
+			if !ok || o.market != market || fakeSymbolOf(o.pair) != r.URL.Query().Get("symbol") {
+				writeError(w, http.StatusBadRequest, -2013, "Order does not exist.")
+				return
+			}
+			if r.Method == http.MethodDelete {
+				if o.resp.Status != "NEW" {
+					writeError(w, http.StatusBadRequest, -2011, "Unknown order sent.")
+					return
+				}
+				s.close(o, "CANCELED")
+			}
+			writeJSON(w, o.resp)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// order by orderId or origClientOrderId
+func (s *FakeServer) findOrder(r *http.Request) (*fakeOrder, bool) {
+	q := r.URL.Query()
+	if clientId := q.Get("origClientOrderId"); clientId != "" {
+		for _, o := range s.orders {
+			if o.resp.ClientOrderId == clientId {
+				return o, true
+			}
+		}
+		return nil, false
+	}
+	id, _ := strconv.ParseInt(q.Get("orderId"), 10, 64)
+	o, ok := s.orders[id]
+	return o, ok
+}
+
+// client order id must be unique among open orders, Binance makes one if not given
+func (s *FakeServer) clientIdOf(w http.ResponseWriter, clientId string) (string, bool) {
+	if clientId == "" {
+		return "fake" + strconv.FormatInt(s.seq+1, 10), true
+	}
+	for _, o := range s.orders {
+		if o.resp.ClientOrderId == clientId && o.resp.Status == "NEW" {
+			writeError(w, http.StatusBadRequest, -2010, "Duplicate order sent.")
+			return "", false
+		}
+	}
+	return clientId, true
+}
+
+// best price of opposite side, false if book is empty
+func (s *FakeServer) bestPrice(symbol, side string) (decimals.Decimal, bool) {
+	book := s.depths[symbol].Asks
+	if side == "SELL" {
+		book = s.depths[symbol].Bids
+	}
+	if len(book) == 0 {
+		return decimals.Zero, false
+	}
+	price, _ := parseDecimal(book[0][0])
+	return price, true
+}
+
+func (s *FakeServer) newOrder(market Market, w http.ResponseWriter, r *http.Request) {
+	sym, ok := s.symbolOf(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	qty, ok := s.quantityOf(w, q.Get("quantity"), sym)
+	if !ok {
+		return
+	}
+	side, typ := q.Get("side"), q.Get("type")
+	price, _ := parseDecimal(q.Get("price"))
+	stopPrice, _ := parseDecimal(q.Get("stopPrice"))
+	best, hasBook := s.bestPrice(q.Get("symbol"), side)
+
+	var fillPrice decimals.Decimal // filled at once if positive
+	status := "NEW"
+	switch typ {
+	case "MARKET":
+		if !hasBook {
+			writeError(w, http.StatusBadRequest, -2010, "Market is closed.")
+			return
+		}
+		fillPrice = best
+	case "LIMIT", "LIMIT_MAKER":
+		if !price.IsPositive() {
+			writeError(w, http.StatusBadRequest, -1013, "Filter failure: PRICE_FILTER")
+			return
+		}
+		crossed := hasBook && ((side == "BUY" && !best.GreaterThan(price)) || (side == "SELL" && !best.LessThan(price)))
+		if typ == "LIMIT_MAKER" && crossed {
+			writeError(w, http.StatusBadRequest, -2010, "Order would immediately match and take.")
+			return
+		}
+		tif := q.Get("timeInForce")
+		if crossed {
+			fillPrice = best
+		} else if tif == "IOC" || tif == "FOK" {
+			status = "EXPIRED"
+		}
+	case "STOP_LOSS", "TAKE_PROFIT":
+		if !stopPrice.IsPositive() && q.Get("trailingDelta") == "" {
+			writeError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'stopPrice' was not sent.")
+			return
+		}
+	case "STOP_LOSS_LIMIT", "TAKE_PROFIT_LIMIT":
+		if !stopPrice.IsPositive() || !price.IsPositive() {
+			writeError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'stopPrice' or 'price' was not sent.")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, -1116, "Invalid orderType.")
+		return
+	}
+
+	// lock by limit price, stop price, or best price
+	lockPrice := price
+	for _, v := range []decimals.Decimal{fillPrice, stopPrice, best} {
+		if !lockPrice.IsPositive() {
+			lockPrice = v
+		}
+	}
+	clientId, ok := s.clientIdOf(w, q.Get("newClientOrderId"))
+	if !ok {
+		return
+	}
+	o, ok := s.open(w, market, sym, q.Get("symbol"), side, typ, qty, q.Get("price"), q.Get("stopPrice"), lockPrice, clientId)
+	if !ok {
+		return
+	}
+	switch {
+	case fillPrice.IsPositive():
+		o.resp.Price = price.String()
+		s.fill(o, fillPrice)
+	case status != "NEW":
+		s.close(o, status)
+	}
+	writeJSON(w, orderResp{Symbol: o.resp.Symbol, OrderId: o.resp.OrderId, ClientOrderId: o.resp.ClientOrderId, TransactTime: o.resp.Time})
+}
+
+func (s *FakeServer) quantityOf(w http.ResponseWriter, quantity string, sym fakeSymbol) (decimals.Decimal, bool) {
+	qty, err := decimals.NewFromString(quantity)
+	minQty, _ := parseDecimal(sym.minQty)
+	if err != nil || qty.LessThan(minQty) || precisionOf(quantity) > precisionOf(sym.stepSize) {
+		writeError(w, http.StatusBadRequest, -1013, "Filter failure: LOT_SIZE")
+		return decimals.Zero, false
+	}
+	return qty, true
+}
+
+// check balance, lock it and save a new order
+func (s *FakeServer) open(w http.ResponseWriter, market Market, sym fakeSymbol, symbol, side, typ string, qty decimals.Decimal, price, stopPrice string, lockPrice decimals.Decimal, clientId string) (*fakeOrder, bool) {
+	blcs := s.balances[market]
+	asset, need := sym.pair.Unit(), qty
+	if side == "BUY" {
+		asset, need = sym.pair.Quote(), qty.Mul(lockPrice)
+	}
+	blc := blcs[asset]
+	if blc.Free.LessThan(need) {
+		writeError(w, http.StatusBadRequest, -2010, "Account has insufficient balance for requested action.")
+		return nil, false
+	}
+	blc.Free, blc.Locked = blc.Free.Sub(need), blc.Locked.Add(need)
+	blcs[asset] = blc
+
+	s.seq++
+	o := &fakeOrder{market: market, pair: sym.pair, locked: need, resp: orderResp{
+		Symbol:              symbol,
+		OrderId:             s.seq,
+		ClientOrderId:       clientId,
+		Price:               price,
+		StopPrice:           stopPrice,
+		OrigQty:             qty.String(),
+		ExecutedQty:         "0",
+		CummulativeQuoteQty: "0",
+		Status:              "NEW",
+		Type:                typ,
+		Side:                side,
+		Time:                toMillis(time.Now()),
+	}}
+	if o.resp.Price == "" {
+		o.resp.Price = "0"
+	}
+	s.orders[o.resp.OrderId] = o
+	return o, true
+}
+
+// fill order at once at price
+func (s *FakeServer) fill(o *fakeOrder, price decimals.Decimal) {
+	qty, _ := parseDecimal(o.resp.OrigQty)
+	locked := s.release(o, "EXPIRED")
+	blcs := s.balances[o.market]
+	unit, quote := blcs[o.pair.Unit()], blcs[o.pair.Quote()]
+	if o.resp.Side == "BUY" {
+		quote.Free = quote.Free.Add(locked).Sub(qty.Mul(price))
+		unit.Free = unit.Free.Add(qty)
+	} else {
+		unit.Free = unit.Free.Add(locked).Sub(qty)
+		quote.Free = quote.Free.Add(qty.Mul(price))
+	}
+	blcs[o.pair.Unit()], blcs[o.pair.Quote()] = unit, quote
+	o.resp.Status = "FILLED"
+	o.resp.ExecutedQty = o.resp.OrigQty
+	o.resp.CummulativeQuoteQty = qty.Mul(price).String()
+}
+
+// end order and its OCO legs without any deal, locked balance is freed
+func (s *FakeServer) close(o *fakeOrder, status string) {
+	locked := s.release(o, status)
+	asset := o.pair.Unit()
+	if o.resp.Side == "BUY" {
+		asset = o.pair.Quote()
+	}
+	blc := s.balances[o.market][asset]
+	blc.Free = blc.Free.Add(locked)
+	s.balances[o.market][asset] = blc
+	o.resp.Status = status
+}
+
+func (s *FakeServer) oco(market Market) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sym, ok := s.symbolOf(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		qty, ok := s.quantityOf(w, q.Get("quantity"), sym)
+		if !ok {
+			return
+		}
+		price, _ := parseDecimal(q.Get("price"))
+		stopPrice, _ := parseDecimal(q.Get("stopPrice"))
+		if !price.IsPositive() || !stopPrice.IsPositive() {
+			writeError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'price' or 'stopPrice' was not sent.")
+			return
+		}
+		side := q.Get("side")
+		lockPrice := price
+		if side == "BUY" && stopPrice.GreaterThan(price) {
+			lockPrice = stopPrice
+		}
+		clientId, ok := s.clientIdOf(w, q.Get("limitClientOrderId"))
+		if !ok {
+			return
+		}
+		maker, ok := s.open(w, market, sym, q.Get("symbol"), side, "LIMIT_MAKER", qty, q.Get("price"), "", lockPrice, clientId)
+		if !ok {
+			return
+		}
+		stopType := "STOP_LOSS"
+		if q.Get("stopLimitPrice") != "" {
+			stopType = "STOP_LOSS_LIMIT"
+		}
+		// the stop leg shares balance locked by the maker leg
+		s.seq++
+		stop := &fakeOrder{market: market, pair: sym.pair, locked: decimals.Zero, resp: maker.resp}
+		stop.resp.OrderId = s.seq
+		stop.resp.ClientOrderId = "fake" + strconv.FormatInt(s.seq, 10)
+		stop.resp.Type = stopType
+		stop.resp.Price = q.Get("stopLimitPrice")
+		if stop.resp.Price == "" {
+			stop.resp.Price = "0"
+		}
+		stop.resp.StopPrice = q.Get("stopPrice")
+		s.orders[stop.resp.OrderId] = stop
+		maker.listId, stop.listId = maker.resp.OrderId, maker.resp.OrderId
+
+		writeJSON(w, ocoResp{OrderListId: maker.listId, OrderReports: []orderResp{stop.resp, maker.resp}})
+	}
+}
+
+// open orders also accept DELETE to cancel all of them
+func (s *FakeServer) listOrders(market Market, openOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		symbol := r.URL.Query().Get("symbol")
+		if openOnly && r.Method == http.MethodDelete {
+			s.cancelAll(market, symbol, w)
+			return
+		}
+		orders := []orderResp{}
+		for _, o := range s.orders {
+			// open orders of all symbols if symbol is not sent
+			if o.market != market || (o.resp.Symbol != symbol && (symbol != "" || !openOnly)) || (openOnly && o.resp.Status != "NEW") {
+				continue
+			}
+			orders = append(orders, o.resp)
+		}
+		sort.Slice(orders, func(i, j int) bool { return orders[i].OrderId < orders[j].OrderId })
+		writeJSON(w, orders)
+	}
+}
+
+// legs of OCO are reported as one item
+func (s *FakeServer) cancelAll(market Market, symbol string, w http.ResponseWriter) {
+	var ids []int64
+	for id, o := range s.orders {
+		if o.market == market && o.resp.Symbol == symbol && o.resp.Status == "NEW" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	items := []canceledResp{}
+	lists := map[int64]int{} // list id => index in items
+	for _, id := range ids {
+		o := s.orders[id]
+		if o.resp.Status == "NEW" {
+			s.close(o, "CANCELED")
+		}
+		// like Binance, clientOrderId is a new one of the cancel request
+		s.seq++
+		resp := o.resp
+		resp.OrigClientOrderId, resp.ClientOrderId = resp.ClientOrderId, "cancel"+strconv.FormatInt(s.seq, 10)
+		if o.listId == 0 {
+			items = append(items, canceledResp{orderResp: resp})
+			continue
+		}
+		i, ok := lists[o.listId]
+		if !ok {
+			i = len(items)
+			lists[o.listId] = i
+			items = append(items, canceledResp{})
+		}
+		items[i].OrderReports = append(items[i].OrderReports, resp)
+	}
+	writeJSON(w, items)
+}
+
+func fakeAddressOf(asset, network string) string {
+	return "fake-" + strings.ToLower(asset+"-"+network)
+}
+
+// filter of history requests, false if r doesn't want record of asset at ms
+func historyWants(r *http.Request, assetKey, asset string, ms int64) bool {
+	q := r.URL.Query()
+	if v := q.Get(assetKey); v != "" && v != asset {
+		return false
+	}
+	start, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+	if v := q.Get("endTime"); v != "" {
+		if end, _ := strconv.ParseInt(v, 10, 64); ms > end {
+			return false
+		}
+	}
+	return ms >= start
+}
+
+// time range of a history request must be shorter than window like Binance
+func historyRangeOK(w http.ResponseWriter, r *http.Request, window time.Duration) bool {
+	q := r.URL.Query()
+	start, err1 := strconv.ParseInt(q.Get("startTime"), 10, 64)
+	end, err2 := strconv.ParseInt(q.Get("endTime"), 10, 64)
+	if err1 == nil && err2 == nil && end-start >= int64(window/time.Millisecond) {
+		writeError(w, http.StatusBadRequest, -1127, "More than the max interval.")
+		return false
+	}
+	return true
+}
+
+// records from offset, limit of them at most, 1000 by default and at most like Binance capital API
+func capitalPage(r *http.Request, n int) (begin, end int) {
+	limit, offset := maxCapitalPage, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+	begin, end = offset, offset+limit
+	if begin > n {
+		begin = n
+	}
+	if end > n {
+		end = n
+	}
+	return begin, end
+}
+
+func (s *FakeServer) depositAddress(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	writeJSON(w, map[string]string{"address": fakeAddressOf(q.Get("coin"), q.Get("network")), "coin": q.Get("coin"), "tag": ""})
+}
+
+// newest first, paged by limit and offset
+func (s *FakeServer) depositHistory(w http.ResponseWriter, r *http.Request) {
+	if !historyRangeOK(w, r, capitalWindow) {
+		return
+	}
+	items := []depositResp{}
+	for i := len(s.deposits) - 1; i >= 0; i-- {
+		if v := s.deposits[i]; historyWants(r, "coin", v.Coin, v.InsertTime) {
+			items = append(items, v)
+		}
+	}
+	begin, end := capitalPage(r, len(items))
+	writeJSON(w, items[begin:end])
+}
+
+// withdrawal completes at once, fee is charged besides amount
+func (s *FakeServer) withdraw(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	amount, err := decimals.NewFromString(q.Get("amount"))
+	if err != nil || !amount.IsPositive() || q.Get("address") == "" {
+		writeError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'amount' or 'address'.")
+		return
+	}
+	coin, fee := q.Get("coin"), s.fees[q.Get("coin")]
+	blc := s.balances[MarketSpot][coin]
+	if blc.Free.LessThan(amount.Add(fee)) {
+		writeError(w, http.StatusBadRequest, -4026, "User has insufficient balance")
+		return
+	}
+	blc.Free = blc.Free.Sub(amount.Add(fee))
+	s.balances[MarketSpot][coin] = blc
+	s.seq++
+	id := "w" + strconv.FormatInt(s.seq, 10)
+	s.withdraws = append(s.withdraws, withdrawResp{
+		Id:             id,
+		Amount:         amount.String(),
+		TransactionFee: fee.String(),
+		Coin:           coin,
+		Network:        q.Get("network"),
+		Status:         6,
+		Address:        q.Get("address"),
+		AddressTag:     q.Get("addressTag"),
+		TxId:           "0xfake" + strconv.FormatInt(s.seq, 10),
+		ApplyTime:      time.Now().UTC().Format(withdrawTimeLayout),
+	})
+	writeJSON(w, map[string]string{"id": id})
+}
+
+// newest first, paged by limit and offset
+func (s *FakeServer) withdrawHistory(w http.ResponseWriter, r *http.Request) {
+	if !historyRangeOK(w, r, capitalWindow) {
+		return
+	}
+	items := []withdrawResp{}
+	for i := len(s.withdraws) - 1; i >= 0; i-- {
+		v := s.withdraws[i]
+		tm, _ := time.ParseInLocation(withdrawTimeLayout, v.ApplyTime, time.UTC)
+		if historyWants(r, "coin", v.Coin, toMillis(tm)) {
+			items = append(items, v)
+		}
+	}
+	begin, end := capitalPage(r, len(items))
+	writeJSON(w, items[begin:end])
+}
+
+// newest first, paged by size (10 by default, 100 at most) and current (from 1) like Binance
+func (s *FakeServer) transferHistory(w http.ResponseWriter, r *http.Request) {
+	if !historyRangeOK(w, r, transferWindow) {
+		return
+	}
+	rows := []transferResp{}
+	for i := len(s.transfers) - 1; i >= 0; i-- {
+		if v := s.transfers[i]; historyWants(r, "asset", v.Asset, v.Timestamp) {
+			rows = append(rows, v)
+		}
+	}
+	total := len(rows)
+	size, current := 10, 1
+	if v, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && v > 0 {
+		size = v
+	}
+	if size > 100 {
+		size = 100
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("current")); err == nil && v > 0 {
+		current = v
+	}
+	begin, end := (current-1)*size, current*size
+	if begin > len(rows) {
+		begin = len(rows)
+	}
+	if end > len(rows) {
+		end = len(rows)
+	}
+	writeJSON(w, map[string]interface{}{"rows": rows[begin:end], "total": total})
+}
@@ -0,0 +1,487 @@
+package binance
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type (
+	balanceResp struct {
+		Asset    string `json:"asset"`
+		Free     string `json:"free"`
+		Locked   string `json:"locked"`
+		Borrowed string `json:"borrowed"` // margin only
+		Interest string `json:"interest"` // margin only
+	}
+
+	ocoResp struct {
+		OrderListId  int64       `json:"orderListId"`
+		OrderReports []orderResp `json:"orderReports"`
+	}
+
+	// item of cancel all response, an order, or an OCO with its legs
+	canceledResp struct {
+		orderResp
+		OrderReports []orderResp `json:"orderReports"`
+	}
+
+	orderResp struct {
+		Symbol              string `json:"symbol"`
+		OrderId             int64  `json:"orderId"`
+		ClientOrderId       string `json:"clientOrderId"`
+		Price               string `json:"price"`
+		OrigQty             string `json:"origQty"`
+		ExecutedQty         string `json:"executedQty"`
+		CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
+		Status              string `json:"status"`
+		Type                string `json:"type"`
+		Side                string `json:"side"`
+		StopPrice           string `json:"stopPrice"`
+		Time                int64  `json:"time"`
+		TransactTime        int64  `json:"transactTime"`      // only in response of new order
+		OrigClientOrderId   string `json:"origClientOrderId"` // only in response of cancel, clientOrderId is new id of the cancel then
+	}
+)
+
+var (
+	orderTypes = map[OrderType]string{
+		OrderTypeLimit:            "LIMIT",
+		OrderTypeMarket:           "MARKET",
+		OrderTypeStopLimit:        "STOP_LOSS_LIMIT",
+		OrderTypeStopMarket:       "STOP_LOSS",
+		OrderTypeTakeProfitLimit:  "TAKE_PROFIT_LIMIT",
+		OrderTypeTakeProfitMarket: "TAKE_PROFIT",
+		OrderTypeTrailingStop:     "STOP_LOSS",
+	}
+)
+
+// path of spot API, or margin one
+func orderPath(market Market, spot, margin string) string {
+	if market == MarketMargin {
+		return margin
+	}
+	return spot
+}
+
+func (b *BinanceEx) GetAccount() (*Account, error) {
+	r := NewEmptyAccount()
+	if b.config.SpotEnabled() {
+		resp := struct {
+			Balances []balanceResp `json:"balances"`
+		}{}
+		if err := b.client.do(http.MethodGet, "/api/v3/account", nil, true, &resp); err != nil {
+			return nil, err
+		}
+		if err := fillBalances(r.Spot, resp.Balances); err != nil {
+			return nil, err
+		}
+	}
+	if b.config.MarginEnabled() {
+		resp := struct {
+			UserAssets []balanceResp `json:"userAssets"`
+		}{}
+		if err := b.client.do(http.MethodGet, "/sapi/v1/margin/account", nil, true, &resp); err != nil {
+			return nil, err
+		}
+		if err := fillBalances(r.Margin, resp.UserAssets); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// zero balances are skipped
+func fillBalances(dst map[string]Balance, src []balanceResp) error {
+	for _, v := range src {
+		blc := Balance{}
+		var err error
+		if blc.Free, err = parseDecimal(v.Free); err != nil {
+			return err
+		}
+		if blc.Locked, err = parseDecimal(v.Locked); err != nil {
+			return err
+		}
+		if blc.Borrowed, err = parseDecimal(v.Borrowed); err != nil {
+			return err
+		}
+		if blc.Interest, err = parseDecimal(v.Interest); err != nil {
+			return err
+		}
+		if blc.IsZero() {
+			continue
+		}
+		dst[v.Asset] = blc
+	}
+	return nil
+}
+
+func (b *BinanceEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	params := url.Values{}
+	params.Set("asset", asset)
+	resp := struct {
+		Amount string `json:"amount"`
+	}{}
+	if err := b.client.do(http.MethodGet, "/sapi/v1/margin/maxBorrowable", params, true, &resp); err != nil {
+		return decimals.Zero, err
+	}
+	return parseDecimal(resp.Amount)
+}
+
+func (b *BinanceEx) Borrow(asset string, amount decimals.Decimal) error {
+	params := url.Values{}
+	params.Set("asset", asset)
+	params.Set("amount", amount.String())
+	return b.client.do(http.MethodPost, "/sapi/v1/margin/loan", params, true, nil)
+}
+
+func (b *BinanceEx) Repay(asset string, amount decimals.Decimal) error {
+	params := url.Values{}
+	params.Set("asset", asset)
+	params.Set("amount", amount.String())
+	return b.client.do(http.MethodPost, "/sapi/v1/margin/repay", params, true, nil)
+}
+
+func (b *BinanceEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	params := url.Values{}
+	params.Set("asset", asset)
+	params.Set("amount", amount.String())
+	switch target {
+	case MarketMargin:
+		params.Set("type", "1") // spot to margin
+	case MarketSpot:
+		params.Set("type", "2") // margin to spot
+	default:
+		return errorz.Errorf("unsupported transfer target market(%s)", target)
+	}
+	return b.client.do(http.MethodPost, "/sapi/v1/margin/transfer", params, true, nil)
+}
+
+func (b *BinanceEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	if err := t.Verify(); err != nil {
+		return nil, err
+	}
+	return b.TradeWithOptions(NewTradeRequest(market, target, t, amount, price))
+}
+
+// all order types and time in force are supported except reduce only, which is for derivatives.
+// Binance only rejects client order id taken by open orders, so order with client id is looked up before placed.
+func (b *BinanceEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	if err := req.Verify(); err != nil {
+		return nil, err
+	}
+	if err := b.verifyMarket(req.Market); err != nil {
+		return nil, err
+	}
+	if req.ReduceOnly || (req.TimeInForce == TimeInForcePostOnly && req.Type != OrderTypeLimit) {
+		return nil, ex.NewUnsupportedRequestError(b, req)
+	}
+	if req.ClientId == "" {
+		return b.trade(req)
+	}
+
+	order, err := b.GetOrderByClientId(req.Market, req.Pair, req.ClientId)
+	if err == nil {
+		return &order.Id, nil
+	}
+	if ErrorKindOf(err) != ErrorKindOrderNotFound {
+		return nil, err
+	}
+	id, err := b.trade(req)
+	if err != nil && isDuplicateOrder(err) {
+		// placed by a concurrent request
+		if order, err := b.GetOrderByClientId(req.Market, req.Pair, req.ClientId); err == nil {
+			return &order.Id, nil
+		}
+	}
+	return id, err
+}
+
+func (b *BinanceEx) trade(req TradeRequest) (*OrderId, error) {
+	if req.Type == OrderTypeOCO {
+		return b.tradeOCO(req)
+	}
+
+	params := url.Values{}
+	params.Set("symbol", b.symbol(req.Pair))
+	params.Set("side", sideOf(req.Side))
+	params.Set("quantity", req.Amount.String())
+	typ, ok := orderTypes[req.Type]
+	if !ok {
+		return nil, ex.NewUnsupportedRequestError(b, req)
+	}
+	if req.TimeInForce == TimeInForcePostOnly {
+		typ = "LIMIT_MAKER"
+	}
+	params.Set("type", typ)
+	if req.Type.IsLimit() {
+		params.Set("price", req.Price.String())
+		if req.TimeInForce != TimeInForcePostOnly {
+			params.Set("timeInForce", timeInForceOf(req.TimeInForce))
+		}
+	}
+	if req.StopPrice.IsPositive() {
+		params.Set("stopPrice", req.StopPrice.String())
+	}
+	if req.Type == OrderTypeTrailingStop {
+		bips := req.TrailingDelta.Mul(decimals.NewFromInt(10000)).Trunc(0, 1)
+		params.Set("trailingDelta", bips.String())
+	}
+	if req.ClientId != "" {
+		params.Set("newClientOrderId", req.ClientId)
+	}
+	params.Set("newOrderRespType", "ACK")
+	resp := orderResp{}
+	if err := b.client.do(http.MethodPost, orderPath(req.Market, "/api/v3/order", "/sapi/v1/margin/order"), params, true, &resp); err != nil {
+		return nil, err
+	}
+	id := orderIdOf(req.Market, req.Pair, resp)
+	return &id, nil
+}
+
+// id of limit maker leg is returned, canceling any leg cancels the other one
+func (b *BinanceEx) tradeOCO(req TradeRequest) (*OrderId, error) {
+	params := url.Values{}
+	params.Set("symbol", b.symbol(req.Pair))
+	params.Set("side", sideOf(req.Side))
+	params.Set("quantity", req.Amount.String())
+	params.Set("price", req.Price.String())
+	params.Set("stopPrice", req.StopPrice.String())
+	if req.StopLimitPrice.IsPositive() {
+		params.Set("stopLimitPrice", req.StopLimitPrice.String())
+		params.Set("stopLimitTimeInForce", timeInForceOf(req.TimeInForce))
+	}
+	if req.ClientId != "" {
+		params.Set("limitClientOrderId", req.ClientId)
+	}
+	resp := ocoResp{}
+	if err := b.client.do(http.MethodPost, orderPath(req.Market, "/api/v3/order/oco", "/sapi/v1/margin/order/oco"), params, true, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.OrderReports) == 0 {
+		return nil, errorz.Errorf("no order in OCO response")
+	}
+	maker := resp.OrderReports[0]
+	for _, v := range resp.OrderReports {
+		if v.Type == "LIMIT_MAKER" {
+			maker = v
+		}
+	}
+	id := orderIdOf(req.Market, req.Pair, maker)
+	return &id, nil
+}
+
+// client order id made by Binance is kept too, it is dropped only if it breaks OrderId
+func orderIdOf(market Market, target Pair, v orderResp) OrderId {
+	strId := strconv.FormatInt(v.OrderId, 10)
+	if VerifyClientOrderId(v.ClientOrderId) != nil {
+		return NewOrderId(market, target, strId)
+	}
+	return NewOrderIdWithClientId(market, target, strId, v.ClientOrderId)
+}
+
+func sideOf(side TradeSide) string {
+	if side == TradeSideBuy {
+		return "BUY"
+	}
+	return "SELL"
+}
+
+// GTC if not given
+func timeInForceOf(tif TimeInForce) string {
+	switch tif {
+	case TimeInForceIOC:
+		return "IOC"
+	case TimeInForceFOK:
+		return "FOK"
+	default:
+		return "GTC"
+	}
+}
+
+func (b *BinanceEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	return b.getOrders(market, target, orderPath(market, "/api/v3/allOrders", "/sapi/v1/margin/allOrders"))
+}
+
+func (b *BinanceEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	return b.getOrders(market, target, orderPath(market, "/api/v3/openOrders", "/sapi/v1/margin/openOrders"))
+}
+
+// open orders of all symbols in one request, weight of it is 40 times of GetOpenOrders
+func (b *BinanceEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	var resp []orderResp
+	if err := b.client.do(http.MethodGet, orderPath(market, "/api/v3/openOrders", "/sapi/v1/margin/openOrders"), nil, true, &resp); err != nil {
+		return nil, err
+	}
+	var r []Order
+	for _, v := range resp {
+		target, err := ParsePairCustom(v.Symbol, b.config)
+		if err != nil {
+			return nil, err
+		}
+		order, err := b.parseOrder(market, target, v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, *order)
+	}
+	return r, nil
+}
+
+func (b *BinanceEx) getOrders(market Market, target Pair, path string) ([]Order, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	var resp []orderResp
+	if err := b.client.do(http.MethodGet, path, params, true, &resp); err != nil {
+		return nil, err
+	}
+	var r []Order
+	for _, v := range resp {
+		order, err := b.parseOrder(market, target, v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, *order)
+	}
+	return r, nil
+}
+
+func (b *BinanceEx) GetOrder(id OrderId) (*Order, error) {
+	params, err := b.orderParams(id)
+	if err != nil {
+		return nil, err
+	}
+	resp := orderResp{}
+	if err := b.client.do(http.MethodGet, orderPath(id.Market(), "/api/v3/order", "/sapi/v1/margin/order"), params, true, &resp); err != nil {
+		return nil, err
+	}
+	return b.parseOrder(id.Market(), id.Pair(), resp)
+}
+
+func (b *BinanceEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	if err := VerifyClientOrderId(clientId); err != nil {
+		return nil, err
+	}
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	params.Set("origClientOrderId", clientId)
+	resp := orderResp{}
+	if err := b.client.do(http.MethodGet, orderPath(market, "/api/v3/order", "/sapi/v1/margin/order"), params, true, &resp); err != nil {
+		return nil, err
+	}
+	return b.parseOrder(market, target, resp)
+}
+
+func (b *BinanceEx) CancelOrder(id OrderId) error {
+	params, err := b.orderParams(id)
+	if err != nil {
+		return err
+	}
+	return b.client.do(http.MethodDelete, orderPath(id.Market(), "/api/v3/order", "/sapi/v1/margin/order"), params, true, nil)
+}
+
+// canceled in one request, legs of OCO are included
+func (b *BinanceEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	if err := b.verifyMarket(market); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(target))
+	var resp []canceledResp
+	if err := b.client.do(http.MethodDelete, orderPath(market, "/api/v3/openOrders", "/sapi/v1/margin/openOrders"), params, true, &resp); err != nil {
+		return nil, err
+	}
+	var r []OrderId
+	for _, v := range resp {
+		orders := v.OrderReports
+		if len(orders) == 0 {
+			orders = []orderResp{v.orderResp}
+		}
+		for _, order := range orders {
+			order.ClientOrderId = order.OrigClientOrderId
+			r = append(r, orderIdOf(market, target, order))
+		}
+	}
+	return r, nil
+}
+
+func (b *BinanceEx) orderParams(id OrderId) (url.Values, error) {
+	if err := id.Verify(); err != nil {
+		return nil, err
+	}
+	if err := b.verifyMarket(id.Market()); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("symbol", b.symbol(id.Pair()))
+	params.Set("orderId", id.StrId())
+	return params, nil
+}
+
+func (b *BinanceEx) parseOrder(market Market, target Pair, v orderResp) (*Order, error) {
+	r := &Order{
+		Id:   orderIdOf(market, target, v),
+		Time: fromMillis(v.Time),
+		Pair: target,
+	}
+	if v.Time == 0 {
+		r.Time = fromMillis(v.TransactTime)
+	}
+
+	r.Status = TradeStatusError
+	for status, s := range b.config.TradeStatus {
+		if s == v.Status {
+			r.Status = status
+		}
+	}
+	if r.Status == TradeStatusError {
+		return nil, errorz.Errorf("unknown order status(%s)", v.Status)
+	}
+
+	// stop and take profit orders are taken as limit or market orders they turn into after triggered
+	isMarket := v.Type == "MARKET" || v.Type == "STOP_LOSS" || v.Type == "TAKE_PROFIT"
+	switch {
+	case isMarket && v.Side == "BUY":
+		r.TypeSide = TradeTypeSideMarketBuy
+	case isMarket:
+		r.TypeSide = TradeTypeSideMarketSell
+	case v.Side == "BUY":
+		r.TypeSide = TradeTypeSideLimitBuy
+	default:
+		r.TypeSide = TradeTypeSideLimitSell
+	}
+
+	var err error
+	if r.Price, err = parseDecimal(v.Price); err != nil {
+		return nil, err
+	}
+	if r.Amount, err = parseDecimal(v.OrigQty); err != nil {
+		return nil, err
+	}
+	if r.DealAmount, err = parseDecimal(v.ExecutedQty); err != nil {
+		return nil, err
+	}
+	quote, err := parseDecimal(v.CummulativeQuoteQty)
+	if err != nil {
+		return nil, err
+	}
+	r.AvgPrice = decimals.Zero
+	if r.DealAmount.IsPositive() {
+		r.AvgPrice = quote.Div(r.DealAmount)
+	}
+	r.Fee = decimals.Zero // not provided by order API
+	return r, nil
+}
@@ -0,0 +1,318 @@
+package binance
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	withdrawTimeLayout = "2006-01-02 15:04:05" // UTC
+	maxTransferPage    = 100                   // max size of a page of margin transfer history
+	maxCapitalPage     = 1000                  // max size of a page of deposit or withdrawal history
+	capitalWindow      = 90 * 24 * time.Hour   // time range of a deposit or withdrawal history request is less than it
+	transferWindow     = 30 * 24 * time.Hour   // time range of a margin transfer history request is less than it
+)
+
+type (
+	depositResp struct {
+		Id         string `json:"id"`
+		Amount     string `json:"amount"`
+		Coin       string `json:"coin"`
+		Network    string `json:"network"`
+		Status     int    `json:"status"`
+		Address    string `json:"address"`
+		AddressTag string `json:"addressTag"`
+		TxId       string `json:"txId"`
+		InsertTime int64  `json:"insertTime"`
+	}
+
+	withdrawResp struct {
+		Id             string `json:"id"`
+		Amount         string `json:"amount"`
+		TransactionFee string `json:"transactionFee"`
+		Coin           string `json:"coin"`
+		Network        string `json:"network"`
+		Status         int    `json:"status"`
+		Address        string `json:"address"`
+		AddressTag     string `json:"addressTag"`
+		TxId           string `json:"txId"`
+		ApplyTime      string `json:"applyTime"`
+	}
+
+	transferResp struct {
+		TxId      int64  `json:"txId"`
+		Asset     string `json:"asset"`
+		Amount    string `json:"amount"`
+		Status    string `json:"status"`
+		Type      string `json:"type"` // ROLL_IN: spot to margin, ROLL_OUT: margin to spot
+		Timestamp int64  `json:"timestamp"`
+	}
+)
+
+var (
+	depositStatus = map[int]TransferStatus{
+		0: TransferStatusPending,
+		1: TransferStatusSuccess,
+		6: TransferStatusSuccess, // credited but cannot withdraw
+		7: TransferStatusFailed,  // wrong deposit
+		8: TransferStatusPending, // waiting user confirm
+	}
+
+	withdrawStatus = map[int]TransferStatus{
+		0: TransferStatusPending, // email sent
+		1: TransferStatusCanceled,
+		2: TransferStatusPending, // awaiting approval
+		3: TransferStatusFailed,  // rejected
+		4: TransferStatusPending, // processing
+		5: TransferStatusFailed,
+		6: TransferStatusSuccess,
+	}
+
+	transferStatus = map[string]TransferStatus{
+		"PENDING":   TransferStatusPending,
+		"CONFIRMED": TransferStatusSuccess,
+		"FAILED":    TransferStatusFailed,
+	}
+)
+
+func (b *BinanceEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	params := url.Values{}
+	params.Set("coin", asset)
+	if network != "" {
+		params.Set("network", network)
+	}
+	resp := struct {
+		Address string `json:"address"`
+		Coin    string `json:"coin"`
+		Tag     string `json:"tag"`
+	}{}
+	if err := b.client.do(http.MethodGet, "/sapi/v1/capital/deposit/address", params, true, &resp); err != nil {
+		return nil, err
+	}
+	return &DepositAddress{Asset: resp.Coin, Network: network, Address: resp.Address, Tag: resp.Tag}, nil
+}
+
+// withdrawn from spot account
+func (b *BinanceEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	if err := to.Verify(); err != nil {
+		return "", err
+	}
+	if !amount.IsPositive() {
+		return "", errorz.Errorf("invalid withdraw amount(%s)", amount.String())
+	}
+	params := url.Values{}
+	params.Set("coin", to.Asset)
+	params.Set("address", to.Address)
+	params.Set("amount", amount.String())
+	if to.Network != "" {
+		params.Set("network", to.Network)
+	}
+	if to.Tag != "" {
+		params.Set("addressTag", to.Tag)
+	}
+	resp := struct {
+		Id string `json:"id"`
+	}{}
+	if err := b.client.do(http.MethodPost, "/sapi/v1/capital/withdraw/apply", params, true, &resp); err != nil {
+		return "", err
+	}
+	return resp.Id, nil
+}
+
+// deposits in windows of capitalWindow since since, one window ago if nil, all pages of them are read
+func (b *BinanceEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var resp []depositResp
+	for _, window := range historyWindows(since, b.now(), capitalWindow) {
+		params := historyParams("coin", asset, window)
+		params.Set("limit", strconv.Itoa(maxCapitalPage))
+		for offset := 0; ; offset += maxCapitalPage {
+			params.Set("offset", strconv.Itoa(offset))
+			var page []depositResp
+			if err := b.client.do(http.MethodGet, "/sapi/v1/capital/deposit/hisrec", params, true, &page); err != nil {
+				return nil, err
+			}
+			resp = append(resp, page...)
+			if len(page) < maxCapitalPage {
+				break
+			}
+		}
+	}
+	var r []AssetTransfer
+	for _, v := range resp {
+		amount, err := parseDecimal(v.Amount)
+		if err != nil {
+			return nil, err
+		}
+		status, ok := depositStatus[v.Status]
+		if !ok {
+			return nil, errorz.Errorf("unknown deposit status(%d)", v.Status)
+		}
+		r = append(r, AssetTransfer{
+			Id:      v.Id,
+			Kind:    TransferKindDeposit,
+			Asset:   v.Coin,
+			Amount:  amount,
+			Fee:     decimals.Zero,
+			Status:  status,
+			Time:    fromMillis(v.InsertTime),
+			Network: v.Network,
+			Address: v.Address,
+			Tag:     v.AddressTag,
+			TxHash:  v.TxId,
+		})
+	}
+	return sortTransfers(r), nil
+}
+
+// withdrawals like GetDeposits
+func (b *BinanceEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var resp []withdrawResp
+	for _, window := range historyWindows(since, b.now(), capitalWindow) {
+		params := historyParams("coin", asset, window)
+		params.Set("limit", strconv.Itoa(maxCapitalPage))
+		for offset := 0; ; offset += maxCapitalPage {
+			params.Set("offset", strconv.Itoa(offset))
+			var page []withdrawResp
+			if err := b.client.do(http.MethodGet, "/sapi/v1/capital/withdraw/history", params, true, &page); err != nil {
+				return nil, err
+			}
+			resp = append(resp, page...)
+			if len(page) < maxCapitalPage {
+				break
+			}
+		}
+	}
+	var r []AssetTransfer
+	for _, v := range resp {
+		amount, err := parseDecimal(v.Amount)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := parseDecimal(v.TransactionFee)
+		if err != nil {
+			return nil, err
+		}
+		status, ok := withdrawStatus[v.Status]
+		if !ok {
+			return nil, errorz.Errorf("unknown withdraw status(%d)", v.Status)
+		}
+		tm, err := time.ParseInLocation(withdrawTimeLayout, v.ApplyTime, time.UTC)
+		if err != nil {
+			return nil, errorz.Errorf("invalid withdraw apply time(%s)", v.ApplyTime)
+		}
+		r = append(r, AssetTransfer{
+			Id:      v.Id,
+			Kind:    TransferKindWithdrawal,
+			Asset:   v.Coin,
+			Amount:  amount,
+			Fee:     fee,
+			Status:  status,
+			Time:    tm,
+			Network: v.Network,
+			Address: v.Address,
+			Tag:     v.AddressTag,
+			TxHash:  v.TxId,
+		})
+	}
+	return sortTransfers(r), nil
+}
+
+// transfers between spot and margin account in windows of transferWindow like GetDeposits
+func (b *BinanceEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var rows []transferResp
+	for _, window := range historyWindows(since, b.now(), transferWindow) {
+		params := historyParams("asset", asset, window)
+		params.Set("size", strconv.Itoa(maxTransferPage))
+		for current := 1; ; current++ {
+			params.Set("current", strconv.Itoa(current))
+			resp := struct {
+				Rows []transferResp `json:"rows"`
+			}{}
+			if err := b.client.do(http.MethodGet, "/sapi/v1/margin/transfer", params, true, &resp); err != nil {
+				return nil, err
+			}
+			rows = append(rows, resp.Rows...)
+			if len(resp.Rows) < maxTransferPage {
+				break
+			}
+		}
+	}
+	var r []AssetTransfer
+	for _, v := range rows {
+		amount, err := parseDecimal(v.Amount)
+		if err != nil {
+			return nil, err
+		}
+		status, ok := transferStatus[v.Status]
+		if !ok {
+			return nil, errorz.Errorf("unknown transfer status(%s)", v.Status)
+		}
+		from, to := MarketSpot, MarketMargin
+		if v.Type == "ROLL_OUT" {
+			from, to = MarketMargin, MarketSpot
+		}
+		r = append(r, AssetTransfer{
+			Id:     strconv.FormatInt(v.TxId, 10),
+			Kind:   TransferKindInternal,
+			Asset:  v.Asset,
+			Amount: amount,
+			Fee:    decimals.Zero,
+			Status: status,
+			Time:   fromMillis(v.Timestamp),
+			From:   from,
+			To:     to,
+		})
+	}
+	return sortTransfers(r), nil
+}
+
+// first and last millisecond of windows from since to now, each one is shorter than window
+func historyWindows(since *time.Time, now time.Time, window time.Duration) [][2]time.Time {
+	begin := now.Add(-window + time.Millisecond)
+	if since != nil {
+		begin = *since
+	}
+	var r [][2]time.Time
+	for !begin.After(now) {
+		end := begin.Add(window - time.Millisecond)
+		if end.After(now) {
+			end = now
+		}
+		r = append(r, [2]time.Time{begin, end})
+		begin = end.Add(time.Millisecond)
+	}
+	return r
+}
+
+// assetKey is "coin" in capital API and "asset" in margin API
+func historyParams(assetKey, asset string, window [2]time.Time) url.Values {
+	params := url.Values{}
+	if asset != "" {
+		params.Set(assetKey, asset)
+	}
+	params.Set("startTime", strconv.FormatInt(toMillis(window[0]), 10))
+	params.Set("endTime", strconv.FormatInt(toMillis(window[1]), 10))
+	return params
+}
+
+// Binance returns newest first, records of the same time keep their order.
+// A record shifted to the next page by a new one is read twice, the copy is removed.
+func sortTransfers(transfers []AssetTransfer) []AssetTransfer {
+	var r []AssetTransfer
+	seen := map[string]bool{}
+	for i := len(transfers) - 1; i >= 0; i-- {
+		if seen[transfers[i].Id] {
+			continue
+		}
+		seen[transfers[i].Id] = true
+		r = append(r, transfers[i])
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Time.Before(r[j].Time) })
+	return r
+}
@@ -0,0 +1,113 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+)
+
+var (
+	basicMethods = []ExMethod{
+		ExMethodGetMarketInfo, ExMethodGetAccount, ExMethodGetDepth, ExMethodGetTicks, ExMethodGetKline, ExMethodGetFills,
+		ExMethodGetBorrowable, ExMethodBorrow, ExMethodRepay, ExMethodTransfer, ExMethodTrade,
+		ExMethodGetAllOrders, ExMethodGetOpenOrders, ExMethodGetOrder, ExMethodCancelOrder,
+	}
+)
+
+// DefaultCapabilities of e by its config and extension interfaces it implements:
+// all methods of Ex and implemented extension interfaces, enabled markets, trade types (all if not mapped) and periods of config,
+// basic limit and market orders of TradeWithOptions, and no native batch.
+// Adapters start from it and adjust what they really support.
+func DefaultCapabilities(e Ex) Capabilities {
+	c := Capabilities{Methods: append([]ExMethod(nil), basicMethods...)}
+	if _, ok := e.(AdvancedTrader); ok {
+		c.Methods = append(c.Methods, ExMethodTradeWithOptions)
+	}
+	if _, ok := e.(BatchTrader); ok {
+		c.Methods = append(c.Methods, ExMethodBatchTrade, ExMethodBatchCancel)
+	}
+	if _, ok := e.(AllOrdersCanceler); ok {
+		c.Methods = append(c.Methods, ExMethodCancelAllOrders)
+	}
+	if _, ok := e.(OpenOrdersGetter); ok {
+		c.Methods = append(c.Methods, ExMethodGetAllOpenOrders)
+	}
+	if _, ok := e.(ClientOrderGetter); ok {
+		c.Methods = append(c.Methods, ExMethodGetOrderByClientId)
+	}
+	if _, ok := e.(FillTimeGetter); ok {
+		c.Methods = append(c.Methods, ExMethodGetFillsByTime)
+	}
+	if _, ok := e.(ServerTimer); ok {
+		c.Methods = append(c.Methods, ExMethodGetServerTime)
+	}
+	if _, ok := e.(DerivativesEx); ok {
+		c.Methods = append(c.Methods, ExMethodGetPositions, ExMethodSetLeverage, ExMethodSetMarginMode,
+			ExMethodGetFundingRate, ExMethodGetFundingHistory, ExMethodGetMarkPrice)
+	}
+	if _, ok := e.(WalletEx); ok {
+		c.Methods = append(c.Methods, ExMethodGetDepositAddress, ExMethodWithdraw, ExMethodGetDeposits,
+			ExMethodGetWithdrawals, ExMethodGetTransfers)
+	}
+
+	if config := e.Config(); config != nil {
+		for market, enabled := range config.MarketEnabled {
+			if enabled {
+				c.Markets = append(c.Markets, market)
+			}
+		}
+		for t := range config.TradeTypes {
+			c.TradeTypes = append(c.TradeTypes, t)
+		}
+		for period := range config.Periods {
+			c.Periods = append(c.Periods, period)
+		}
+		c.MaxDepth = config.MaxDepth
+	}
+	if len(c.TradeTypes) == 0 {
+		c.TradeTypes = []TradeTypeSide{TradeTypeSideLimitBuy, TradeTypeSideLimitSell, TradeTypeSideMarketBuy, TradeTypeSideMarketSell}
+	}
+	// basic requests fall back to Trade
+	if c.HasTradeType(TradeTypeSideLimitBuy) || c.HasTradeType(TradeTypeSideLimitSell) {
+		c.OrderTypes = append(c.OrderTypes, OrderTypeLimit)
+	}
+	if c.HasTradeType(TradeTypeSideMarketBuy) || c.HasTradeType(TradeTypeSideMarketSell) {
+		c.OrderTypes = append(c.OrderTypes, OrderTypeMarket)
+	}
+	if len(c.OrderTypes) > 0 {
+		c.TimeInForces = []TimeInForce{TimeInForceGTC}
+	}
+	return SortCapabilities(c)
+}
+
+// SortCapabilities sorts lists of c, periods by duration and others by name
+func SortCapabilities(c Capabilities) Capabilities {
+	sort.Slice(c.Methods, func(i, j int) bool { return c.Methods[i] < c.Methods[j] })
+	sort.Slice(c.Markets, func(i, j int) bool { return c.Markets[i] < c.Markets[j] })
+	sort.Slice(c.TradeTypes, func(i, j int) bool { return c.TradeTypes[i] < c.TradeTypes[j] })
+	sort.Slice(c.OrderTypes, func(i, j int) bool { return c.OrderTypes[i] < c.OrderTypes[j] })
+	sort.Slice(c.TimeInForces, func(i, j int) bool { return c.TimeInForces[i] < c.TimeInForces[j] })
+	sort.Slice(c.Periods, func(i, j int) bool {
+		if c.Periods[i].ToDuration() == c.Periods[j].ToDuration() {
+			return c.Periods[i] < c.Periods[j]
+		}
+		return c.Periods[i].ToDuration() < c.Periods[j].ToDuration()
+	})
+	return c
+}
+
+// RequireCapabilities checks e supports required, strategy runners call it at startup
+// instead of failing with ErrFunctionNotSupported in session.
+func RequireCapabilities(e Ex, required Capabilities) error {
+	return e.Capabilities().Require(required)
+}
+
+// markets of c which are also in markets
+func intersectMarkets(c Capabilities, markets []Market) []Market {
+	var r []Market
+	for _, market := range markets {
+		if c.HasMarket(market) {
+			r = append(r, market)
+		}
+	}
+	return r
+}
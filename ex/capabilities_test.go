@@ -0,0 +1,41 @@
+package ex
+
+import (
+	"errors"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func TestDefaultCapabilities(t *testing.T) {
+	f := newFakeEx()
+	c := f.Capabilities()
+	if !c.HasMethod(ExMethodTrade) || c.HasMethod(ExMethodTradeWithOptions) || c.HasMethod(ExMethodGetPositions) {
+		t.Fatalf("unexpected methods %v", c.Methods)
+	}
+	if len(c.Markets) != 1 || !c.HasMarket(MarketSpot) || !c.HasOrderType(OrderTypeLimit) || c.MaxBatchSize != 0 {
+		t.Fatalf("unexpected capabilities %+v", c)
+	}
+
+	p, _ := newTestPaperEx(t)
+	c = p.Capabilities()
+	for _, method := range []ExMethod{ExMethodTradeWithOptions, ExMethodGetOrderByClientId, ExMethodCancelAllOrders, ExMethodGetKline} {
+		if !c.HasMethod(method) {
+			t.Fatalf("%s missing in %v", method, c.Methods)
+		}
+	}
+	if !c.HasTimeInForce(TimeInForceIOC) || c.HasMarket(MarketMargin) {
+		t.Fatalf("unexpected paper capabilities %+v", c)
+	}
+
+	r, err := NewRiskEx(p, RiskLimits{Markets: []Market{MarketSpot, MarketPerp}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RequireCapabilities(r, Capabilities{Markets: []Market{MarketPerp}, Methods: []ExMethod{ExMethodTrade}})
+	if !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("expected not supported error, got %v", err)
+	}
+	if err := RequireCapabilities(r, Capabilities{Markets: []Market{MarketSpot}, OrderTypes: []OrderType{OrderTypeMarket}}); err != nil {
+		t.Fatal(err)
+	}
+}
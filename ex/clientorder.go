@@ -0,0 +1,34 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+)
+
+type (
+	// ClientOrderGetter is implemented by Ex which can look up orders by client order id directly.
+	ClientOrderGetter interface {
+		// ErrOrderNotFound if no order has clientId
+		GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error)
+	}
+)
+
+// GetOrderByClientId finds order by client order id, by e if e is a ClientOrderGetter, or in all orders of target.
+// ErrOrderNotFound means the order was never placed.
+func GetOrderByClientId(e Ex, market Market, target Pair, clientId string) (*Order, error) {
+	if err := VerifyClientOrderId(clientId); err != nil {
+		return nil, err
+	}
+	if g, ok := e.(ClientOrderGetter); ok {
+		return g.GetOrderByClientId(market, target, clientId)
+	}
+	orders, err := e.GetAllOrders(market, target)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		if orders[i].Id.ClientId() == clientId {
+			return &orders[i], nil
+		}
+	}
+	return nil, NewExError(ErrorKindOrderNotFound, "no order with client id(%s)", clientId)
+}
@@ -0,0 +1,79 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+type (
+	// places orders but loses responses of the first n of them
+	lossyEx struct {
+		*PaperEx
+		n int
+	}
+)
+
+func (l *lossyEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	id, err := l.PaperEx.TradeWithOptions(req)
+	if err == nil && l.n > 0 {
+		l.n--
+		return nil, ErrNetworkTransient
+	}
+	return id, err
+}
+
+func TestPaperEx_ClientId(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	clientId := NewClientOrderId()
+	req := NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90), WithClientId(clientId))
+
+	id, err := TradeWithOptions(p, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.ClientId() != clientId {
+		t.Fatalf("client id should be embedded in %s", id)
+	}
+	again, err := TradeWithOptions(p, req)
+	if err != nil || *again != *id {
+		t.Fatalf("resubmission should return %s, got %v, err %v", id, again, err)
+	}
+	if orders, _ := p.GetAllOrders(MarketSpot, testPair); len(orders) != 1 {
+		t.Fatalf("1 order expected, got %d", len(orders))
+	}
+	order, err := GetOrderByClientId(p, MarketSpot, testPair, clientId)
+	if err != nil || order.Id != *id {
+		t.Fatalf("order %s expected, got %v, err %v", id, order, err)
+	}
+	if _, err := GetOrderByClientId(p, MarketSpot, testPair, NewClientOrderId()); ErrorKindOf(err) != ErrorKindOrderNotFound {
+		t.Fatalf("ErrOrderNotFound expected, got %v", err)
+	}
+
+	// client id can't be dropped silently
+	if _, err := TradeWithOptions(newFakeEx(), req); ErrorKindOf(err) != ErrorKindNotSupported {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}
+
+func TestRetryEx_ClientIdNeverDoubleTrades(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	r, _ := newTestRetryEx(t, &lossyEx{PaperEx: p, n: 1}, RetryOption{})
+
+	req := NewTradeRequest(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90), WithClientId(NewClientOrderId()))
+	id, err := r.TradeWithOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, _ := p.GetAllOrders(MarketSpot, testPair)
+	if len(orders) != 1 || orders[0].Id != *id {
+		t.Fatalf("only order %s expected, got %d orders", id, len(orders))
+	}
+
+	// without client id network error is not retried by default
+	r, _ = newTestRetryEx(t, &lossyEx{PaperEx: p, n: 1}, RetryOption{})
+	req.ClientId = ""
+	if _, err := r.TradeWithOptions(req); ErrorKindOf(err) != ErrorKindNetworkTransient {
+		t.Fatalf("ErrNetworkTransient expected, got %v", err)
+	}
+}
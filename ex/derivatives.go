@@ -0,0 +1,37 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"time"
+)
+
+type (
+	// DerivativesEx is implemented by Ex which trades futures or perpetual swaps,
+	// orders of them are placed by Trade or TradeWithOptions with MarketFuture or MarketPerp.
+	DerivativesEx interface {
+		// open positions of market
+		GetPositions(market Market) ([]Position, error)
+
+		// leverage of new positions of target
+		SetLeverage(market Market, target Pair, leverage int) error
+
+		// exchanges reject it usually if target has open positions or orders
+		SetMarginMode(market Market, target Pair, mode MarginMode) error
+
+		// current predicted funding rate of perpetual swap
+		GetFundingRate(target Pair) (*FundingRate, error)
+
+		// settled funding rates since time, oldest first
+		GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error)
+
+		GetMarkPrice(market Market, target Pair) (*MarkPrice, error)
+	}
+)
+
+// DerivativesEx of e, ErrFunctionNotSupported if e doesn't trade derivatives
+func DerivativesOf(e Ex) (DerivativesEx, error) {
+	if de, ok := e.(DerivativesEx); ok && e.Capabilities().HasMethod(ExMethodGetPositions) {
+		return de, nil
+	}
+	return nil, ErrFunctionNotSupported
+}
@@ -0,0 +1,134 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type (
+	fakeDerivativesEx struct {
+		*fakeEx
+		leverage  map[Pair]int
+		failFirst error // returned by first GetPositions
+	}
+)
+
+func newFakeDerivativesEx() *fakeDerivativesEx {
+	return &fakeDerivativesEx{fakeEx: newFakeEx(), leverage: map[Pair]int{}}
+}
+
+func (f *fakeDerivativesEx) Capabilities() Capabilities { return DefaultCapabilities(f) }
+
+func (f *fakeDerivativesEx) GetPositions(market Market) ([]Position, error) {
+	if err := f.failFirst; err != nil {
+		f.failFirst = nil
+		return nil, err
+	}
+	return []Position{{
+		Market:     market,
+		Pair:       testPair,
+		Side:       PositionSideLong,
+		Amount:     decimals.One,
+		EntryPrice: decimals.NewFromInt(100),
+		MarkPrice:  decimals.NewFromInt(101),
+		Leverage:   f.leverage[testPair],
+		MarginMode: MarginModeCross,
+	}}, nil
+}
+
+func (f *fakeDerivativesEx) SetLeverage(market Market, target Pair, leverage int) error {
+	f.leverage[target] = leverage
+	return nil
+}
+
+func (f *fakeDerivativesEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	return ErrFunctionNotSupported
+}
+
+func (f *fakeDerivativesEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	return &FundingRate{Pair: target, Rate: decimals.NewFromFloat64(0.0001), Time: time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC)}, nil
+}
+
+func (f *fakeDerivativesEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	return nil, nil
+}
+
+func (f *fakeDerivativesEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	return &MarkPrice{Market: market, Pair: target, Price: decimals.NewFromInt(101)}, nil
+}
+
+func TestDerivativesOf(t *testing.T) {
+	if _, err := DerivativesOf(newFakeEx()); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	// decorators of spot exchanges don't trade derivatives either
+	r, _ := newTestRetryEx(t, newFakeEx(), RetryOption{})
+	if _, err := r.GetPositions(MarketPerp); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	// and helpers tell it before any call
+	if _, err := DerivativesOf(r); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	if _, err := WalletOf(r); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	if _, err := FillTimeGetterOf(r); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	if _, err := NewSyncedClock(r, SyncOption{}); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+
+	inner := newFakeDerivativesEx()
+	inner.failFirst = ErrNetworkTransient
+	r, _ = newTestRetryEx(t, inner, RetryOption{})
+	de, err := DerivativesOf(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := de.SetLeverage(MarketPerp, testPair, 5); err != nil {
+		t.Fatal(err)
+	}
+	positions, err := de.GetPositions(MarketPerp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Leverage != 5 || !positions[0].Profit().EqualInt(1) {
+		t.Fatalf("unexpected positions %v", positions)
+	}
+}
+
+func TestRecordReplay_Derivatives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := NewRecordEx(newFakeDerivativesEx(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate, err := rec.GetFundingRate(testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.SetMarginMode(MarketPerp, testPair, MarginModeIsolated); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := NewReplayEx(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrate, err := rep.GetFundingRate(testPair)
+	if err != nil || !rrate.Rate.Equal(rate.Rate) || !rrate.Time.Equal(rate.Time) {
+		t.Fatalf("funding rate %v expected, got %v, err %v", rate, rrate, err)
+	}
+	if err := rep.SetMarginMode(MarketPerp, testPair, MarginModeIsolated); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("replayed ErrFunctionNotSupported expected, got %v", err)
+	}
+}
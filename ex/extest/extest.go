@@ -0,0 +1,231 @@
+// Package extest certifies ex.Ex implementations by the same battery of checks in a fake market scenario.
+//
+// An adapter is certified by a test like:
+//
+//	func TestCertify(t *testing.T) {
+//		extest.Run(t, func(t *testing.T, s extest.Scenario) ex.Ex {
+//			// seed a fake server of the adapter with s and connect to it
+//		}, extest.DefaultScenario())
+//	}
+package extest
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	"testing"
+)
+
+type (
+	// Factory makes a fresh exchange trading in s, every check calls it once
+	Factory func(t *testing.T, s Scenario) ex.Ex
+)
+
+// Run all checks as subtests of t
+func Run(t *testing.T, factory Factory, s Scenario) {
+	checks := []struct {
+		name string
+		fn   func(t *testing.T, e ex.Ex, s Scenario)
+	}{
+		{"MarketInfo", checkMarketInfo},
+		{"Depth", checkDepth},
+		{"Capabilities", checkCapabilities},
+		{"OrderRoundTrip", checkOrderRoundTrip},
+		{"Cancel", checkCancel},
+		{"FillBalances", checkFillBalances},
+	}
+	for _, c := range checks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			e := factory(t, s)
+			if e == nil || e.Config() == nil {
+				t.Fatal("factory returned nil exchange or config")
+			}
+			c.fn(t, e, s)
+		})
+	}
+}
+
+func checkMarketInfo(t *testing.T, e ex.Ex, s Scenario) {
+	mi, err := e.GetMarketInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mi.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := mi.Infos[s.Pair.SetMarket(s.Market)]
+	if !ok || !info.Enabled {
+		t.Fatalf("%s of %s market not enabled in market info", s.Pair.String(), s.Market)
+	}
+}
+
+func checkDepth(t *testing.T, e ex.Ex, s Scenario) {
+	depth, err := e.GetDepth(s.Market, s.Pair, len(s.Asks)+len(s.Bids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Sells) == 0 || len(depth.Buys) == 0 {
+		t.Fatalf("empty side of depth %s", depth.String())
+	}
+	for i := 1; i < len(depth.Sells); i++ {
+		if !depth.Sells[i-1].Price.LessThan(depth.Sells[i].Price) {
+			t.Fatalf("sells not sorted from the lowest price %s", depth.String())
+		}
+	}
+	for i := 1; i < len(depth.Buys); i++ {
+		if !depth.Buys[i-1].Price.GreaterThan(depth.Buys[i].Price) {
+			t.Fatalf("buys not sorted from the highest price %s", depth.String())
+		}
+	}
+	if !depth.Buys[0].Price.LessThan(depth.Sells[0].Price) {
+		t.Fatalf("crossed depth %s", depth.String())
+	}
+}
+
+func checkCapabilities(t *testing.T, e ex.Ex, s Scenario) {
+	c := e.Capabilities()
+	required := Capabilities{
+		Methods: []ExMethod{ExMethodGetMarketInfo, ExMethodGetAccount, ExMethodGetDepth, ExMethodTrade,
+			ExMethodGetOpenOrders, ExMethodGetOrder, ExMethodCancelOrder},
+		Markets:    []Market{s.Market},
+		TradeTypes: []TradeTypeSide{TradeTypeSideLimitBuy, TradeTypeSideMarketBuy, TradeTypeSideMarketSell},
+	}
+	if err := c.Require(required); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// limit buy far below the book which never fills
+func placeResting(t *testing.T, e ex.Ex, s Scenario) (OrderId, decimals.Decimal) {
+	price := s.Info.RoundPrice(s.Bids[len(s.Bids)-1].Price.Mul(decimals.NewFromFloat64(0.9)))
+	id, err := e.Trade(s.Market, s.Pair, TradeTypeSideLimitBuy, s.Amount, price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := id.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if id.Market() != s.Market || id.Pair() != s.Pair {
+		t.Fatalf("order id(%s) of another market or pair", id.String())
+	}
+	return *id, price
+}
+
+func checkOrderRoundTrip(t *testing.T, e ex.Ex, s Scenario) {
+	id, price := placeResting(t, e, s)
+	order, err := e.GetOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Id != id || order.Pair != s.Pair || order.TypeSide != TradeTypeSideLimitBuy {
+		t.Fatalf("order %+v doesn't match what was placed", order)
+	}
+	if !order.Amount.Equal(s.Amount) || !order.Price.Equal(price) {
+		t.Fatalf("amount(%s) or price(%s) of order doesn't match %s at %s", order.Amount.String(), order.Price.String(), s.Amount.String(), price.String())
+	}
+	if order.Status.End() {
+		t.Fatalf("resting order ended as %s", order.Status)
+	}
+	for _, list := range []func(Market, Pair) ([]Order, error){e.GetOpenOrders, e.GetAllOrders} {
+		orders, err := list(s.Market, s.Pair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsOrder(orders, id) {
+			t.Fatalf("order(%s) not listed", id.String())
+		}
+	}
+}
+
+func checkCancel(t *testing.T, e ex.Ex, s Scenario) {
+	before := balanceOf(t, e, s, s.Pair.Quote())
+	id, _ := placeResting(t, e, s)
+	if err := e.CancelOrder(id); err != nil {
+		t.Fatal(err)
+	}
+	order, err := e.GetOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !order.Status.End() {
+		t.Fatalf("canceled order is %s", order.Status)
+	}
+	open, err := e.GetOpenOrders(s.Market, s.Pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsOrder(open, id) {
+		t.Fatalf("canceled order(%s) still open", id.String())
+	}
+	after := balanceOf(t, e, s, s.Pair.Quote())
+	if !after.Free.Equal(before.Free) || !after.Locked.Equal(before.Locked) {
+		t.Fatalf("%s not released by cancel, %+v before and %+v after", s.Pair.Quote(), before, after)
+	}
+}
+
+// a market buy and a market sell fill at once, balances move by the deal within taker fee
+func checkFillBalances(t *testing.T, e ex.Ex, s Scenario) {
+	fee := e.Config().TakerFee
+	if fee.LessThan(decimals.Zero) {
+		fee = decimals.Zero
+	}
+	for _, side := range []TradeTypeSide{TradeTypeSideMarketBuy, TradeTypeSideMarketSell} {
+		unitBefore, quoteBefore := balanceOf(t, e, s, s.Pair.Unit()).Total(), balanceOf(t, e, s, s.Pair.Quote()).Total()
+		id, err := e.Trade(s.Market, s.Pair, side, s.Amount, decimals.Zero)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order, err := e.GetOrder(*id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Status != TradeStatusFilled || !order.DealAmount.Equal(s.Amount) {
+			t.Fatalf("%s order is %s with deal amount %s, filled %s expected", side, order.Status, order.DealAmount.String(), s.Amount.String())
+		}
+		price := order.AvgPrice
+		if !price.IsPositive() {
+			price = s.Asks[0].Price
+			if side.IsSell() {
+				price = s.Bids[0].Price
+			}
+		}
+		deal, dealQuote := order.DealAmount, order.DealAmount.Mul(price)
+		unitDelta := balanceOf(t, e, s, s.Pair.Unit()).Total().Sub(unitBefore)
+		quoteDelta := balanceOf(t, e, s, s.Pair.Quote()).Total().Sub(quoteBefore)
+
+		// fee may be charged in either asset
+		unitMin, unitMax := deal.Mul(decimals.One.Sub(fee)), deal
+		quoteMin, quoteMax := decimals.Zero.Sub(dealQuote.Mul(decimals.One.Add(fee))), decimals.Zero.Sub(dealQuote)
+		if side.IsSell() {
+			unitMin, unitMax = decimals.Zero.Sub(deal.Mul(decimals.One.Add(fee))), decimals.Zero.Sub(deal)
+			quoteMin, quoteMax = dealQuote.Mul(decimals.One.Sub(fee)), dealQuote
+		}
+		if unitDelta.LessThan(unitMin) || unitDelta.GreaterThan(unitMax) {
+			t.Fatalf("%s of %s order moved %s, [%s, %s] expected", s.Pair.Unit(), side, unitDelta.String(), unitMin.String(), unitMax.String())
+		}
+		if quoteDelta.LessThan(quoteMin) || quoteDelta.GreaterThan(quoteMax) {
+			t.Fatalf("%s of %s order moved %s, [%s, %s] expected", s.Pair.Quote(), side, quoteDelta.String(), quoteMin.String(), quoteMax.String())
+		}
+	}
+}
+
+func balanceOf(t *testing.T, e ex.Ex, s Scenario, asset string) Balance {
+	acc, err := e.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Market == MarketMargin {
+		return acc.AssetInMargin(asset)
+	}
+	return acc.AssetInSpot(asset)
+}
+
+func containsOrder(orders []Order, id OrderId) bool {
+	for _, order := range orders {
+		if order.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package extest
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	"testing"
+)
+
+func TestPaperEx(t *testing.T) {
+	config := ExConfig{Name: Binance, MaxDepth: 20, MakerFee: decimals.NewFromFloat64(0.001), TakerFee: decimals.NewFromFloat64(0.002)}
+	Run(t, func(t *testing.T, s Scenario) ex.Ex {
+		p, err := ex.NewPaperEx(NewFakeEx(config, s), s.Account())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}, DefaultScenario())
+}
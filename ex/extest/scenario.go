@@ -0,0 +1,168 @@
+package extest
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"github.com/shawnwyckoff/fintypes/ex"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"time"
+)
+
+type (
+	// Scenario is the fake market an exchange is certified in, Factory seeds the exchange with it.
+	// Levels of depth must be enough to fill Amount by the best one at once.
+	Scenario struct {
+		Market   Market
+		Pair     Pair
+		Info     PairInfo
+		Asks     []OrderBook                 // best first
+		Bids     []OrderBook                 // best first
+		Balances map[string]decimals.Decimal // free balances in Market
+		Amount   decimals.Decimal            // unit amount of test orders
+	}
+
+	// FakeEx serves market data of a Scenario, its trading methods return ErrFunctionNotSupported.
+	// It is the real exchange of simulated ones like ex.PaperEx in certification.
+	FakeEx struct {
+		config ExConfig
+		s      Scenario
+	}
+)
+
+// DefaultScenario trades 0.1 BTC/USDT in spot market around 100
+func DefaultScenario() Scenario {
+	return Scenario{
+		Market: MarketSpot,
+		Pair:   NewPair("BTC", "USDT"),
+		Info: PairInfo{
+			Enabled:        true,
+			UnitPrecision:  3,
+			QuotePrecision: 2,
+			LotMin:         decimals.NewFromFloat64(0.001),
+			LotStep:        decimals.NewFromFloat64(0.001),
+		},
+		Asks: []OrderBook{
+			{Price: decimals.NewFromInt(101), Amount: decimals.One},
+			{Price: decimals.NewFromInt(102), Amount: decimals.NewFromInt(2)},
+		},
+		Bids: []OrderBook{
+			{Price: decimals.NewFromInt(100), Amount: decimals.One},
+			{Price: decimals.NewFromInt(99), Amount: decimals.NewFromInt(2)},
+		},
+		Balances: map[string]decimals.Decimal{"USDT": decimals.NewFromInt(10000), "BTC": decimals.NewFromInt(10)},
+		Amount:   decimals.NewFromFloat64(0.1),
+	}
+}
+
+// Account with Balances of s
+func (s Scenario) Account() *Account {
+	acc := NewEmptyAccount()
+	for asset, free := range s.Balances {
+		if s.Market == MarketMargin {
+			acc.Margin[asset] = Balance{Free: free}
+		} else {
+			acc.Spot[asset] = Balance{Free: free}
+		}
+	}
+	return acc
+}
+
+// config: fees, limits and periods of FakeEx, market of s is enabled in it
+func NewFakeEx(config ExConfig, s Scenario) *FakeEx {
+	config = config.Clone()
+	if config.MarketEnabled == nil {
+		config.MarketEnabled = map[Market]bool{}
+	}
+	config.MarketEnabled[s.Market] = true
+	return &FakeEx{config: config, s: s}
+}
+
+func (f *FakeEx) Config() *ExConfig {
+	return &f.config
+}
+
+func (f *FakeEx) Capabilities() Capabilities {
+	c := ex.DefaultCapabilities(f)
+	c.Methods = []ExMethod{ExMethodGetMarketInfo, ExMethodGetDepth, ExMethodGetTicks, ExMethodGetKline, ExMethodGetFills}
+	// market data only, Trade always fails
+	c.TradeTypes, c.OrderTypes, c.TimeInForces = nil, nil, nil
+	return c
+}
+
+func (f *FakeEx) GetMarketInfo() (*MarketInfo, error) {
+	return &MarketInfo{Infos: map[PairExt]PairInfo{f.s.Pair.SetMarket(f.s.Market): f.s.Info}}, nil
+}
+
+func (f *FakeEx) GetAccount() (*Account, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	d := &Depth{Time: time.Now().UTC()}
+	if market != f.s.Market || target != f.s.Pair {
+		return d, nil
+	}
+	d.Sells = append(OrderBookList(nil), f.s.Asks...)
+	d.Buys = append(OrderBookList(nil), f.s.Bids...)
+	if limit > 0 && len(d.Sells) > limit {
+		d.Sells = d.Sells[:limit]
+	}
+	if limit > 0 && len(d.Buys) > limit {
+		d.Buys = d.Buys[:limit]
+	}
+	return d, nil
+}
+
+// last price is the middle of best levels
+func (f *FakeEx) GetTicks() (map[PairExt]Tick, error) {
+	r := map[PairExt]Tick{}
+	if len(f.s.Asks) > 0 && len(f.s.Bids) > 0 {
+		last := f.s.Asks[0].Price.Add(f.s.Bids[0].Price).Div(decimals.NewFromInt(2))
+		r[f.s.Pair.SetMarket(f.s.Market)] = Tick{Last: last}
+	}
+	return r, nil
+}
+
+func (f *FakeEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	return &Kline{}, nil
+}
+
+func (f *FakeEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	return nil, nil
+}
+
+func (f *FakeEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	return decimals.Zero, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) Borrow(asset string, amount decimals.Decimal) error {
+	return ErrFunctionNotSupported
+}
+
+func (f *FakeEx) Repay(asset string, amount decimals.Decimal) error {
+	return ErrFunctionNotSupported
+}
+
+func (f *FakeEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	return ErrFunctionNotSupported
+}
+
+func (f *FakeEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) GetOrder(id OrderId) (*Order, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *FakeEx) CancelOrder(id OrderId) error {
+	return ErrFunctionNotSupported
+}
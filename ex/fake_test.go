@@ -0,0 +1,129 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"github.com/shawnwyckoff/commpkg/sys/clock"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"time"
+)
+
+// manually driven clock
+type testClock struct {
+	clock.Clock
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// market data only exchange for tests
+type fakeEx struct {
+	config  ExConfig
+	info    MarketInfo
+	depths  map[PairExt]*Depth
+	ticks   map[PairExt]Tick
+	klines  map[PairExt]*Kline
+	fills   map[PairExt][]Fill
+	account *Account
+}
+
+var testPair = NewPair("BTC", "USDT")
+
+func newFakeEx() *fakeEx {
+	f := &fakeEx{
+		config: ExConfig{
+			Name:          Binance,
+			MaxDepth:      20,
+			MakerFee:      decimals.NewFromFloat64(0.001),
+			TakerFee:      decimals.NewFromFloat64(0.002),
+			MarketEnabled: map[Market]bool{MarketSpot: true},
+			Periods:       map[Period]string{Period1Min: "1m"},
+		},
+		info:    MarketInfo{Infos: map[PairExt]PairInfo{}},
+		depths:  map[PairExt]*Depth{},
+		ticks:   map[PairExt]Tick{},
+		klines:  map[PairExt]*Kline{},
+		fills:   map[PairExt][]Fill{},
+		account: NewEmptyAccount(),
+	}
+	f.info.Infos[testPair.SetMarket(MarketSpot)] = PairInfo{
+		Enabled:        true,
+		UnitPrecision:  4,
+		QuotePrecision: 2,
+		LotMin:         decimals.NewFromFloat64(0.001),
+		LotStep:        decimals.NewFromFloat64(0.001),
+	}
+	f.setDepth(testPair, [][2]float64{{101, 1}, {102, 2}}, [][2]float64{{100, 1}, {99, 2}})
+	return f
+}
+
+func (f *fakeEx) setDepth(pair Pair, sells, buys [][2]float64) {
+	d := &Depth{Time: time.Now().UTC()}
+	for _, v := range sells {
+		d.Sells = append(d.Sells, OrderBook{Price: decimals.NewFromFloat64(v[0]), Amount: decimals.NewFromFloat64(v[1])})
+	}
+	for _, v := range buys {
+		d.Buys = append(d.Buys, OrderBook{Price: decimals.NewFromFloat64(v[0]), Amount: decimals.NewFromFloat64(v[1])})
+	}
+	f.depths[pair.SetMarket(MarketSpot)] = d
+}
+
+func (f *fakeEx) Config() *ExConfig { return &f.config }
+
+func (f *fakeEx) Capabilities() Capabilities { return DefaultCapabilities(f) }
+
+func (f *fakeEx) GetMarketInfo() (*MarketInfo, error) { return &f.info, nil }
+
+func (f *fakeEx) GetAccount() (*Account, error) { return copyAccount(f.account), nil }
+
+func (f *fakeEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	d, ok := f.depths[target.SetMarket(market)]
+	if !ok {
+		return &Depth{}, nil
+	}
+	return d, nil
+}
+
+func (f *fakeEx) GetTicks() (map[PairExt]Tick, error) { return f.ticks, nil }
+
+func (f *fakeEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	k, ok := f.klines[target.SetMarket(market)]
+	if !ok {
+		return &Kline{}, nil
+	}
+	return k, nil
+}
+
+func (f *fakeEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	return f.fills[target.SetMarket(market)], nil
+}
+
+func (f *fakeEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	return decimals.Zero, ErrFunctionNotSupported
+}
+
+func (f *fakeEx) Borrow(asset string, amount decimals.Decimal) error { return ErrFunctionNotSupported }
+
+func (f *fakeEx) Repay(asset string, amount decimals.Decimal) error { return ErrFunctionNotSupported }
+
+func (f *fakeEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	return ErrFunctionNotSupported
+}
+
+func (f *fakeEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *fakeEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *fakeEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (f *fakeEx) GetOrder(id OrderId) (*Order, error) { return nil, ErrFunctionNotSupported }
+
+func (f *fakeEx) CancelOrder(id OrderId) error { return ErrFunctionNotSupported }
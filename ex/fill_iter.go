@@ -0,0 +1,244 @@
+package ex
+
+import (
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	defaultFillIdLimit = 1000
+)
+
+type (
+	// FillTimeGetter is implemented by Ex which gets fills by time
+	FillTimeGetter interface {
+		// fills in [begin, begin+duration), oldest first
+		GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error)
+	}
+
+	// FillIdGap is a range of fill ids never returned by exchange, both ends included
+	FillIdGap struct {
+		From int64
+		To   int64
+	}
+
+	// FillCheckpoint is progress of FillIterator, saved as JSON to resume later
+	FillCheckpoint struct {
+		Platform Platform
+		Market   Market
+		Pair     Pair
+		Option   FillOption
+		NextId   int64     // id mode, first id of next page
+		NextTime time.Time // time mode, begin of next window
+		LastId   int64     // newest id seen, 0 before first fill
+		Count    int64     // fills returned
+		Gaps     []FillIdGap
+	}
+
+	FillIteratorOption struct {
+		EndTime        time.Time // time mode only, zero means now of exchange clock when created
+		CheckpointPath string    // optional, progress is loaded from it if exists, and saved to it by Commit
+	}
+
+	// FillIterator walks fill history of a pair page by page, by id or by time windows of FillOption.
+	// Ids are expected to be continuous, missing ones are reported as gaps, duplicated ones are skipped.
+	//
+	// Delivery is at least once: a page is committed to checkpoint when the next page is requested or by Commit,
+	// so a page returned but not persisted by caller before a crash is returned again after resumed.
+	FillIterator struct {
+		e         Ex
+		option    FillIteratorOption
+		cp        FillCheckpoint
+		end       time.Time
+		done      bool
+		committed bool // whether progress of the last page is saved
+	}
+)
+
+// FillTimeGetter of e, ErrFunctionNotSupported if e doesn't get fills by time
+func FillTimeGetterOf(e Ex) (FillTimeGetter, error) {
+	if fg, ok := e.(FillTimeGetter); ok && e.Capabilities().HasMethod(ExMethodGetFillsByTime) {
+		return fg, nil
+	}
+	return nil, ErrFunctionNotSupported
+}
+
+// fillOption is verified by rules of exchange, it is ignored if checkpoint is resumed
+func NewFillIterator(e Ex, market Market, target Pair, fillOption FillOption, option FillIteratorOption) (*FillIterator, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to iterate fills")
+	}
+	it := &FillIterator{e: e, option: option, committed: true}
+	if option.CheckpointPath != "" {
+		cp, err := LoadFillCheckpoint(option.CheckpointPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if cp.Platform != e.Config().Name || cp.Market != market || cp.Pair != target {
+				return nil, errorz.Errorf("checkpoint(%s) of %s %s %s doesn't match %s %s %s", option.CheckpointPath,
+					cp.Platform, cp.Market, cp.Pair, e.Config().Name, market, target)
+			}
+			it.cp = *cp
+		}
+	}
+	if it.cp.Platform == "" {
+		if err := fillOption.Verify(e.Config().Name); err != nil {
+			return nil, err
+		}
+		it.cp = FillCheckpoint{
+			Platform: e.Config().Name,
+			Market:   market,
+			Pair:     target,
+			Option:   fillOption,
+			NextId:   fillOption.BeginId,
+			NextTime: fillOption.BeginTime,
+		}
+	}
+	if it.cp.Option.ByTime() {
+		if _, err := FillTimeGetterOf(e); err != nil {
+			return nil, err
+		}
+		it.end = option.EndTime
+		if it.end.IsZero() {
+			it.end = nowOf(e.Config())
+		}
+	}
+	return it, nil
+}
+
+// Next page of fills, oldest first, false if history is exhausted.
+// Page of time mode may be empty if no trade happened in that window.
+// The page returned last time is committed first, caller must have persisted it.
+func (it *FillIterator) Next() ([]Fill, bool, error) {
+	if err := it.Commit(); err != nil {
+		return nil, false, err
+	}
+	if it.done {
+		return nil, false, nil
+	}
+	var fills []Fill
+	var err error
+	if it.cp.Option.ByTime() {
+		fills, err = it.nextWindow()
+	} else {
+		fills, err = it.nextPage()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	fills = it.check(fills)
+	it.committed = false
+	if it.done && len(fills) == 0 {
+		return nil, false, nil
+	}
+	return fills, true, nil
+}
+
+func (it *FillIterator) nextPage() ([]Fill, error) {
+	limit := it.cp.Option.IdLimit
+	if limit <= 0 {
+		limit = defaultFillIdLimit
+	}
+	fromId := it.cp.NextId
+	fills, err := it.e.GetFills(it.cp.Market, it.cp.Pair, &fromId, int(limit))
+	if err != nil {
+		return nil, err
+	}
+	// a short page means latest fill reached
+	if int64(len(fills)) < limit {
+		it.done = true
+	}
+	for _, fill := range fills {
+		if fill.Id >= it.cp.NextId {
+			it.cp.NextId = fill.Id + 1
+		}
+	}
+	return fills, nil
+}
+
+func (it *FillIterator) nextWindow() ([]Fill, error) {
+	begin := it.cp.NextTime
+	if !begin.Before(it.end) {
+		it.done = true
+		return nil, nil
+	}
+	duration := it.cp.Option.TimeDuration
+	if begin.Add(duration).After(it.end) {
+		duration = it.end.Sub(begin)
+	}
+	fg, err := FillTimeGetterOf(it.e)
+	if err != nil {
+		return nil, err
+	}
+	fills, err := fg.GetFillsByTime(it.cp.Market, it.cp.Pair, begin, duration)
+	if err != nil {
+		return nil, err
+	}
+	it.cp.NextTime = begin.Add(duration)
+	if !it.cp.NextTime.Before(it.end) {
+		it.done = true
+	}
+	return fills, nil
+}
+
+// skip duplicated fills and record gaps
+func (it *FillIterator) check(fills []Fill) []Fill {
+	r := fills[:0]
+	for _, fill := range fills {
+		if it.cp.Count > 0 && fill.Id <= it.cp.LastId {
+			continue
+		}
+		if it.cp.Count > 0 && fill.Id > it.cp.LastId+1 {
+			it.cp.Gaps = append(it.cp.Gaps, FillIdGap{From: it.cp.LastId + 1, To: fill.Id - 1})
+		}
+		it.cp.LastId = fill.Id
+		it.cp.Count++
+		r = append(r, fill)
+	}
+	return r
+}
+
+// Commit saves progress of pages returned by Next, call it after the last page is persisted.
+func (it *FillIterator) Commit() error {
+	if it.committed || it.option.CheckpointPath == "" {
+		return nil
+	}
+	if err := SaveFillCheckpoint(it.option.CheckpointPath, it.cp); err != nil {
+		return err
+	}
+	it.committed = true
+	return nil
+}
+
+// progress after the last page returned by Next
+func (it *FillIterator) Checkpoint() FillCheckpoint {
+	r := it.cp
+	r.Gaps = append([]FillIdGap(nil), it.cp.Gaps...)
+	return r
+}
+
+func LoadFillCheckpoint(path string) (*FillCheckpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &FillCheckpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errorz.Errorf("invalid fill checkpoint(%s): %s", path, err.Error())
+	}
+	return cp, nil
+}
+
+// an interrupted save never breaks the old checkpoint
+func SaveFillCheckpoint(path string, cp FillCheckpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
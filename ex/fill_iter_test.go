@@ -0,0 +1,163 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type (
+	// each page of GetFills starts one fill before fromId
+	pagedFillEx struct {
+		*fakeEx
+		all   []Fill
+		pages int
+	}
+)
+
+func (p *pagedFillEx) Capabilities() Capabilities { return DefaultCapabilities(p) }
+
+func (p *pagedFillEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	p.pages++
+	var r []Fill
+	for _, fill := range p.all {
+		if fill.Id >= *fromId-1 && len(r) < limit {
+			r = append(r, fill)
+		}
+	}
+	return r, nil
+}
+
+func (p *pagedFillEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	p.pages++
+	var r []Fill
+	for _, fill := range p.all {
+		if !fill.Time.Before(begin) && fill.Time.Before(begin.Add(duration)) {
+			r = append(r, fill)
+		}
+	}
+	return r, nil
+}
+
+// ids 1 to 10 without 5 and 6, one fill per minute
+func newPagedFillEx() *pagedFillEx {
+	p := &pagedFillEx{fakeEx: newFakeEx()}
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := int64(1); id <= 10; id++ {
+		if id == 5 || id == 6 {
+			continue
+		}
+		p.all = append(p.all, Fill{Id: id, Time: begin.Add(time.Duration(id) * time.Minute), Price: decimals.NewFromInt(100), UnitQty: decimals.One, Side: "buy"})
+	}
+	return p
+}
+
+func collectFills(t *testing.T, it *FillIterator, maxPages int) []int64 {
+	var ids []int64
+	for i := 0; i < maxPages; i++ {
+		fills, ok, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		for _, fill := range fills {
+			ids = append(ids, fill.Id)
+		}
+	}
+	return ids
+}
+
+func TestFillIterator_ById(t *testing.T) {
+	p := newPagedFillEx()
+	if _, err := NewFillIterator(p, MarketSpot, testPair, FillOption{BeginId: 1, IdLimit: 5000}, FillIteratorOption{}); err == nil {
+		t.Fatal("IdLimit over Binance limit accepted")
+	}
+	it, err := NewFillIterator(p, MarketSpot, testPair, FillOption{BeginId: 1, IdLimit: 3}, FillIteratorOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := collectFills(t, it, 100)
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 7, 8, 9, 10}) {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	cp := it.Checkpoint()
+	if !reflect.DeepEqual(cp.Gaps, []FillIdGap{{From: 5, To: 6}}) || cp.Count != 8 || cp.LastId != 10 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+	if _, ok, err := it.Next(); ok || err != nil {
+		t.Fatalf("exhausted iterator returned %v %v", ok, err)
+	}
+}
+
+func TestFillIterator_Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fills.json")
+	p := newPagedFillEx()
+	option := FillIteratorOption{CheckpointPath: path}
+	it, err := NewFillIterator(p, MarketSpot, testPair, FillOption{BeginId: 1, IdLimit: 3}, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := collectFills(t, it, 2)
+
+	// restarted with another begin id, checkpoint wins, and the page not committed is returned again
+	it, err = NewFillIterator(p, MarketSpot, testPair, FillOption{BeginId: 100, IdLimit: 3}, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := collectFills(t, it, 1)
+	if !reflect.DeepEqual(again, first[3:]) {
+		t.Fatalf("page %v expected again, got %v", first[3:], again)
+	}
+	if err := it.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	it, err = NewFillIterator(p, MarketSpot, testPair, FillOption{BeginId: 100, IdLimit: 3}, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := append(first, collectFills(t, it, 100)...)
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 7, 8, 9, 10}) {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	cp, err := LoadFillCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp.Gaps, []FillIdGap{{From: 5, To: 6}}) || cp.LastId != 10 {
+		t.Fatalf("unexpected saved checkpoint %+v", cp)
+	}
+
+	if _, err := NewFillIterator(p, MarketSpot, NewPair("ETH", "USDT"), FillOption{BeginId: 1}, option); err == nil {
+		t.Fatal("checkpoint of another pair accepted")
+	}
+}
+
+func TestFillIterator_ByTime(t *testing.T) {
+	p := newPagedFillEx()
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	option := FillIteratorOption{EndTime: begin.Add(10*time.Minute + 30*time.Second)}
+	it, err := NewFillIterator(p, MarketSpot, testPair, FillOption{BeginTime: begin, TimeDuration: 3 * time.Minute}, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := collectFills(t, it, 100)
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 7, 8, 9, 10}) {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	// windows [0, 3), [3, 6), [6, 9), [9, 10:30)
+	if p.pages != 4 {
+		t.Fatalf("unexpected pages %d", p.pages)
+	}
+	if cp := it.Checkpoint(); !cp.NextTime.Equal(option.EndTime) || len(cp.Gaps) != 1 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+
+	if _, err := NewFillIterator(newFakeEx(), MarketSpot, testPair, FillOption{BeginTime: begin, TimeDuration: time.Minute}, option); err != ErrFunctionNotSupported {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
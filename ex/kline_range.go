@@ -0,0 +1,147 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sort"
+	"time"
+)
+
+const (
+	defaultKlineMaxPages = 10000
+)
+
+type (
+	// KlineGap is a time range [From, To) in which exchange returned no KDot
+	KlineGap struct {
+		From time.Time
+		To   time.Time
+	}
+
+	KlineReport struct {
+		Pages      int        // GetKline requests sent
+		Duplicates int        // KDots returned by more than one page
+		Gaps       []KlineGap // oldest first
+	}
+
+	// KlineFetcher walks GetKline of an Ex page by page over a time range,
+	// requests are spaced by ExConfig.KlineRateLimit.
+	KlineFetcher struct {
+		e        Ex
+		maxPages int
+		bucket   *tokenBucket
+		now      func() time.Time
+		sleep    func(time.Duration)
+	}
+)
+
+// maxPages: stop after so many requests even if range is not covered yet, <= 0 means default
+func NewKlineFetcher(e Ex, maxPages int) (*KlineFetcher, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to fetch kline")
+	}
+	if maxPages <= 0 {
+		maxPages = defaultKlineMaxPages
+	}
+	return &KlineFetcher{
+		e:        e,
+		maxPages: maxPages,
+		bucket:   newTokenBucket(e.Config().KlineRateLimit, 1),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}, nil
+}
+
+// Fetch KDots in [from, to) sorted by time, each time appears once.
+// Time ranges exchange didn't fill are reported as gaps, to in the future is taken as now.
+func (f *KlineFetcher) Fetch(market Market, target Pair, period Period, from, to time.Time) (*Kline, *KlineReport, error) {
+	if period.ToSeconds() <= 0 {
+		return nil, nil, errorz.Errorf("invalid period(%s)", period)
+	}
+	if !from.Before(to) {
+		return nil, nil, errorz.Errorf("invalid kline range [%s, %s)", from, to)
+	}
+
+	r := &Kline{Pair: NewPairExt(target, &period, &market, nil), Period: period}
+	report := &KlineReport{}
+	dots := map[time.Time]KDot{}
+	cursor := from
+	for report.Pages < f.maxPages && cursor.Before(to) {
+		if wait, _ := f.bucket.take(1, f.now(), false); wait > 0 {
+			f.sleep(wait)
+		}
+		since := cursor
+		page, err := f.e.GetKline(market, target, period, &since)
+		if err != nil {
+			return nil, nil, err
+		}
+		report.Pages++
+		if page == nil || len(page.Items) == 0 {
+			break
+		}
+		if page.Pair.Pair() == target {
+			r.Pair = page.Pair
+		}
+
+		newest := time.Time{}
+		for _, dot := range page.Items {
+			if dot.Time.After(newest) {
+				newest = dot.Time
+			}
+			if dot.Time.Before(from) || !dot.Time.Before(to) {
+				continue
+			}
+			key := dot.Time.UTC()
+			if _, ok := dots[key]; ok {
+				report.Duplicates++
+				continue
+			}
+			dots[key] = dot
+		}
+		// exchange has nothing newer than cursor
+		next := newest.Add(period.ToDurationExact(newest, time.UTC))
+		if !next.After(cursor) {
+			break
+		}
+		cursor = next
+	}
+
+	for _, dot := range dots {
+		r.Items = append(r.Items, dot)
+	}
+	sort.Slice(r.Items, func(i, j int) bool { return r.Items[i].Time.Before(r.Items[j].Time) })
+
+	end := to
+	if now := nowOf(f.e.Config()); now.Before(end) {
+		end = now
+	}
+	report.Gaps = klineGaps(r.Items, period, from, end)
+	return r, report, nil
+}
+
+// gaps of sorted dots in [from, end)
+func klineGaps(dots []KDot, period Period, from, end time.Time) []KlineGap {
+	if !from.Before(end) {
+		return nil
+	}
+	if len(dots) == 0 {
+		return []KlineGap{{From: from, To: end}}
+	}
+
+	var r []KlineGap
+	if first := dots[0].Time; first.Sub(from) >= period.ToDurationExact(from, time.UTC) {
+		r = append(r, KlineGap{From: from, To: first})
+	}
+	for i := 1; i < len(dots); i++ {
+		expected := dots[i-1].Time.Add(period.ToDurationExact(dots[i-1].Time, time.UTC))
+		if dots[i].Time.After(expected) {
+			r = append(r, KlineGap{From: expected, To: dots[i].Time})
+		}
+	}
+	last := dots[len(dots)-1].Time
+	if expected := last.Add(period.ToDurationExact(last, time.UTC)); expected.Before(end) {
+		r = append(r, KlineGap{From: expected, To: end})
+	}
+	return r
+}
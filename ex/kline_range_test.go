@@ -0,0 +1,87 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"testing"
+	"time"
+)
+
+type (
+	// returns pageSize dots per GetKline, each page starts one dot before since
+	pagedKlineEx struct {
+		*fakeEx
+		dots     []KDot
+		pageSize int
+	}
+)
+
+func (p *pagedKlineEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	r := &Kline{Pair: NewPairExt(target, &period, &market, nil), Period: period}
+	begin := since.Add(-period.ToDuration())
+	for _, dot := range p.dots {
+		if !dot.Time.Before(begin) && len(r.Items) < p.pageSize {
+			r.Items = append(r.Items, dot)
+		}
+	}
+	return r, nil
+}
+
+func TestKlineFetcher(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &pagedKlineEx{fakeEx: newFakeEx(), pageSize: 3}
+	e.config.KlineRateLimit = time.Second
+	e.config.Clock = &testClock{now: base.Add(20 * time.Hour)}
+	for i := 0; i < 12; i++ {
+		if i == 5 || i == 6 {
+			continue // exchange lost them
+		}
+		e.dots = append(e.dots, KDot{Time: base.Add(time.Duration(i) * time.Hour), Close: decimals.NewFromInt(int64(100 + i))})
+	}
+
+	f, err := NewKlineFetcher(e, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := base
+	slept := time.Duration(0)
+	f.now = func() time.Time { return now }
+	f.sleep = func(d time.Duration) { slept += d; now = now.Add(d) }
+
+	// to is after the last dot, it is limited by now of exchange clock
+	k, report, err := f.Fetch(MarketSpot, testPair, Period1Hour, base.Add(30*time.Minute), base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Items) != 9 || !k.Items[0].Time.Equal(base.Add(time.Hour)) || !k.Items[8].Time.Equal(base.Add(11*time.Hour)) {
+		t.Fatalf("9 dots from 01:00 to 11:00 expected, got %d: %v", len(k.Items), k.Items)
+	}
+	for i := 1; i < len(k.Items); i++ {
+		if !k.Items[i].Time.After(k.Items[i-1].Time) {
+			t.Fatalf("dots not sorted or deduplicated at %d", i)
+		}
+	}
+	if report.Duplicates == 0 {
+		t.Fatal("overlapped pages should be reported")
+	}
+	if report.Pages < 2 || slept < time.Duration(report.Pages-1)*time.Second {
+		t.Fatalf("%d pages should be spaced by 1s, slept %s", report.Pages, slept)
+	}
+	expected := []KlineGap{
+		{From: base.Add(5 * time.Hour), To: base.Add(7 * time.Hour)},
+		{From: base.Add(12 * time.Hour), To: base.Add(20 * time.Hour)},
+	}
+	if len(report.Gaps) != len(expected) {
+		t.Fatalf("gaps %v expected, got %v", expected, report.Gaps)
+	}
+	for i := range expected {
+		if !report.Gaps[i].From.Equal(expected[i].From) || !report.Gaps[i].To.Equal(expected[i].To) {
+			t.Fatalf("gaps %v expected, got %v", expected, report.Gaps)
+		}
+	}
+
+	if _, _, err := f.Fetch(MarketSpot, testPair, Period1Hour, base, base); err == nil {
+		t.Fatal("empty range should be rejected")
+	}
+}
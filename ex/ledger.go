@@ -0,0 +1,278 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+	"strconv"
+)
+
+type (
+	// local account and orders book-keeping shared by simulated exchanges, not goroutine safe
+	localLedger struct {
+		config  *ExConfig
+		account *Account
+		orders  map[OrderId]*Order
+		markets map[OrderId]Market
+		frozen  map[OrderId]decimals.Decimal // locked quote(buy) or unit(sell) amount still held by order
+		clients map[string]OrderId           // client order id => order id
+		seq     int64
+	}
+)
+
+func newLocalLedger(config *ExConfig, initAccount *Account) *localLedger {
+	return &localLedger{
+		config:  config,
+		account: copyAccount(initAccount),
+		orders:  map[OrderId]*Order{},
+		markets: map[OrderId]Market{},
+		frozen:  map[OrderId]decimals.Decimal{},
+		clients: map[string]OrderId{},
+	}
+}
+
+func (l *localLedger) balances(market Market) map[string]Balance {
+	if market == MarketMargin {
+		return l.account.Margin
+	}
+	return l.account.Spot
+}
+
+// freeze balance and create a new order
+// toFreeze: quote amount for buy orders, unit amount for sell orders
+// clientId: optional, caller should check it is not taken by clientOrder before
+func (l *localLedger) open(market Market, target Pair, t TradeTypeSide, amount, price, toFreeze decimals.Decimal, clientId string) (*Order, error) {
+	blcs := l.balances(market)
+	frozenAsset := target.Unit()
+	if t.IsBuy() {
+		frozenAsset = target.Quote()
+	}
+	blc := blcs[frozenAsset]
+	if blc.Free.LessThan(toFreeze) {
+		return nil, NewExError(ErrorKindInsufficientBalance, "free amount(%s) of asset(%s) less than required(%s)", blc.Free.String(), frozenAsset, toFreeze.String())
+	}
+	blc.Free = blc.Free.Sub(toFreeze)
+	blc.Locked = blc.Locked.Add(toFreeze)
+	blcs[frozenAsset] = blc
+
+	l.seq++
+	id := NewOrderIdWithClientId(market, target, strconv.FormatInt(l.seq, 10), clientId)
+	order := &Order{
+		Id:       id,
+		Time:     nowOf(l.config),
+		Pair:     target,
+		TypeSide: t,
+		Price:    price,
+		Amount:   amount,
+		Status:   TradeStatusNew,
+	}
+	l.orders[id] = order
+	l.markets[id] = market
+	l.frozen[id] = toFreeze
+	if clientId != "" {
+		l.clients[clientId] = id
+	}
+	return order, nil
+}
+
+// book a deal of order, fee is charged in the asset received
+func (l *localLedger) settle(order *Order, deal, dealPrice, feeRate decimals.Decimal) {
+	blcs := l.balances(l.markets[order.Id])
+	unit, quote := order.Pair.Unit(), order.Pair.Quote()
+	unitBlc, quoteBlc := blcs[unit], blcs[quote]
+	dealQuote := deal.Mul(dealPrice)
+
+	var fee decimals.Decimal
+	if order.TypeSide.IsBuy() {
+		// limit buy was frozen at order price, the rest of it is released when order ends
+		frozenCost := dealQuote
+		if order.TypeSide.IsLimit() {
+			frozenCost = deal.Mul(order.Price)
+			quoteBlc.Free = quoteBlc.Free.Add(frozenCost.Sub(dealQuote))
+		}
+		quoteBlc.Locked = quoteBlc.Locked.Sub(frozenCost)
+		l.frozen[order.Id] = l.frozen[order.Id].Sub(frozenCost)
+		fee = deal.Mul(feeRate)
+		unitBlc.Free = unitBlc.Free.Add(deal.Sub(fee))
+	} else {
+		unitBlc.Locked = unitBlc.Locked.Sub(deal)
+		l.frozen[order.Id] = l.frozen[order.Id].Sub(deal)
+		fee = dealQuote.Mul(feeRate)
+		quoteBlc.Free = quoteBlc.Free.Add(dealQuote.Sub(fee))
+	}
+	blcs[unit], blcs[quote] = unitBlc, quoteBlc
+
+	totalDeal := order.DealAmount.Add(deal)
+	order.AvgPrice = order.AvgPrice.Mul(order.DealAmount).Add(dealQuote).Div(totalDeal)
+	order.DealAmount = totalDeal
+	order.Fee = order.Fee.Add(fee)
+}
+
+// update order status after matching
+// closeUnfilled: unfilled part won't be matched anymore, like market orders
+func (l *localLedger) update(order *Order, closeUnfilled bool) {
+	if order.DealAmount.Equal(order.Amount) {
+		order.Status = TradeStatusFilled
+		l.unfreeze(order)
+	} else if closeUnfilled {
+		if order.DealAmount.IsPositive() {
+			order.Status = TradeStatusCanceled
+		} else {
+			order.Status = TradeStatusExpired
+		}
+		l.unfreeze(order)
+	} else if order.DealAmount.IsPositive() {
+		order.Status = TradeStatusPartiallyFilled
+	}
+}
+
+// release balance still frozen by order
+func (l *localLedger) unfreeze(order *Order) {
+	frozen, ok := l.frozen[order.Id]
+	if !ok {
+		return
+	}
+	delete(l.frozen, order.Id)
+	asset := order.Pair.Unit()
+	if order.TypeSide.IsBuy() {
+		asset = order.Pair.Quote()
+	}
+	blcs := l.balances(l.markets[order.Id])
+	blc := blcs[asset]
+	blc.Locked = blc.Locked.Sub(frozen)
+	blc.Free = blc.Free.Add(frozen)
+	blcs[asset] = blc
+}
+
+func (l *localLedger) cancel(id OrderId) error {
+	order, ok := l.orders[id]
+	if !ok {
+		return NewExError(ErrorKindOrderNotFound, "order(%s) not found", id.String())
+	}
+	if order.Status.End() {
+		return errorz.Errorf("order(%s) already %s", id.String(), order.Status)
+	}
+	l.unfreeze(order)
+	order.Status = TradeStatusCanceled
+	return nil
+}
+
+func (l *localLedger) get(id OrderId) (*Order, error) {
+	order, ok := l.orders[id]
+	if !ok {
+		return nil, NewExError(ErrorKindOrderNotFound, "order(%s) not found", id.String())
+	}
+	r := *order
+	return &r, nil
+}
+
+// order with client order id, false if not found
+func (l *localLedger) clientOrder(clientId string) (OrderId, bool) {
+	id, ok := l.clients[clientId]
+	return id, ok
+}
+
+func (l *localLedger) list(market Market, target Pair, openOnly bool) []Order {
+	var r []Order
+	for id, order := range l.orders {
+		if l.markets[id] != market || order.Pair != target {
+			continue
+		}
+		if openOnly && order.Status.End() {
+			continue
+		}
+		r = append(r, *order)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Time.Equal(r[j].Time) {
+			return r[i].Id.String() < r[j].Id.String()
+		}
+		return r[i].Time.Before(r[j].Time)
+	})
+	return r
+}
+
+// open orders which are not ended yet
+func (l *localLedger) pending() []*Order {
+	var r []*Order
+	for _, order := range l.orders {
+		if !order.Status.End() {
+			r = append(r, order)
+		}
+	}
+	return r
+}
+
+func (l *localLedger) borrow(asset string, amount decimals.Decimal) error {
+	if !amount.IsPositive() {
+		return errorz.Errorf("invalid borrow amount(%s)", amount.String())
+	}
+	blc := l.account.Margin[asset]
+	blc.Free = blc.Free.Add(amount)
+	blc.Borrowed = blc.Borrowed.Add(amount)
+	l.account.Margin[asset] = blc
+	return nil
+}
+
+func (l *localLedger) repay(asset string, amount decimals.Decimal) error {
+	if !amount.IsPositive() {
+		return errorz.Errorf("invalid repay amount(%s)", amount.String())
+	}
+	blc := l.account.Margin[asset]
+	if blc.Free.LessThan(amount) {
+		return NewExError(ErrorKindInsufficientBalance, "free amount(%s) of asset(%s) less than repay amount(%s)", blc.Free.String(), asset, amount.String())
+	}
+	// interest first, then principal
+	left := amount
+	payInterest := decimals.Min(blc.Interest, left)
+	blc.Interest = blc.Interest.Sub(payInterest)
+	left = left.Sub(payInterest)
+	payBorrowed := decimals.Min(blc.Borrowed, left)
+	blc.Borrowed = blc.Borrowed.Sub(payBorrowed)
+	blc.Free = blc.Free.Sub(payInterest).Sub(payBorrowed)
+	l.account.Margin[asset] = blc
+	return nil
+}
+
+func (l *localLedger) transfer(asset string, amount decimals.Decimal, target Market) error {
+	switch target {
+	case MarketMargin:
+		return l.account.TransferToMargin(asset, amount)
+	case MarketSpot:
+		return l.account.TransferToSpot(asset, amount)
+	default:
+		return errorz.Errorf("unsupported transfer target market(%s)", target)
+	}
+}
+
+// check trade parameters and round them by PairInfo
+func verifyTrade(market Market, t TradeTypeSide, amount, price decimals.Decimal, info *PairInfo) (decimals.Decimal, decimals.Decimal, error) {
+	if err := t.Verify(); err != nil {
+		return amount, price, err
+	}
+	if market != MarketSpot && market != MarketMargin {
+		return amount, price, errorz.Errorf("unsupported market(%s) in simulated trading", market)
+	}
+	amount = roundAmount(amount, info)
+	if !amount.IsPositive() || amount.LessThan(info.LotMin) {
+		return amount, price, NewExError(ErrorKindInvalidLot, "amount(%s) less than lot min(%s)", amount.String(), info.LotMin.String())
+	}
+	if t.IsLimit() {
+		price = roundPrice(price, info)
+		if !price.IsPositive() {
+			return amount, price, errorz.Errorf("invalid limit price(%s)", price.String())
+		}
+	} else {
+		price = decimals.Zero
+	}
+	return amount, price, nil
+}
+
+func findPairInfo(mi *MarketInfo, market Market, target Pair) (*PairInfo, error) {
+	info, ok := mi.Infos[target.SetMarket(market)]
+	if !ok || !info.Enabled {
+		return nil, NewExError(ErrorKindMarketClosed, "%s not tradable in %s market", target.String(), market)
+	}
+	return &info, nil
+}
@@ -0,0 +1,259 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// MultiEx holds one Ex per Platform.
+	// Market data of all platforms is merged with platform set on each PairExt,
+	// trading calls are routed by platform of target PairExt.
+	MultiEx struct {
+		exs       map[Platform]Ex
+		platforms []Platform // sorted
+	}
+)
+
+// platform of each Ex comes from its Config().Name
+func NewMultiEx(exs ...Ex) (*MultiEx, error) {
+	m := &MultiEx{exs: map[Platform]Ex{}}
+	for _, e := range exs {
+		if e == nil || e.Config() == nil {
+			return nil, errorz.Errorf("nil exchange or exchange config")
+		}
+		platform := e.Config().Name
+		if _, ok := m.exs[platform]; ok {
+			return nil, errorz.Errorf("duplicate exchange of platform(%s)", platform.String())
+		}
+		m.exs[platform] = e
+		m.platforms = append(m.platforms, platform)
+	}
+	if len(m.platforms) == 0 {
+		return nil, errorz.Errorf("no exchange")
+	}
+	sort.Slice(m.platforms, func(i, j int) bool { return m.platforms[i] < m.platforms[j] })
+	return m, nil
+}
+
+func (m *MultiEx) Platforms() []Platform {
+	return append([]Platform(nil), m.platforms...)
+}
+
+func (m *MultiEx) Ex(platform Platform) (Ex, error) {
+	e, ok := m.exs[platform]
+	if !ok {
+		return nil, errorz.Errorf("no exchange of platform(%s)", platform.String())
+	}
+	return e, nil
+}
+
+// target must have platform and market
+func (m *MultiEx) route(target PairExt) (Ex, error) {
+	if !target.HasPlatform() || !target.HasMarket() {
+		return nil, errorz.Errorf("target(%s) requires platform and market", target.String())
+	}
+	return m.Ex(target.Platform())
+}
+
+// call fn for every platform concurrently, first error by platform order is returned
+func (m *MultiEx) each(fn func(platform Platform, e Ex) error) error {
+	errs := make([]error, len(m.platforms))
+	var wg sync.WaitGroup
+	for i, platform := range m.platforms {
+		wg.Add(1)
+		go func(i int, platform Platform) {
+			defer wg.Done()
+			errs[i] = fn(platform, m.exs[platform])
+		}(i, platform)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return errorz.Errorf("platform(%s) error: %s", m.platforms[i].String(), err.Error())
+		}
+	}
+	return nil
+}
+
+// ticks of all platforms, platform is set on each PairExt
+func (m *MultiEx) GetTicks() (map[PairExt]Tick, error) {
+	var mu sync.Mutex
+	r := map[PairExt]Tick{}
+	err := m.each(func(platform Platform, e Ex) error {
+		ticks, err := e.GetTicks()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for pe, tick := range ticks {
+			r[pe.SetPlatform(platform)] = tick
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (m *MultiEx) GetAccounts() (map[Platform]*Account, error) {
+	var mu sync.Mutex
+	r := map[Platform]*Account{}
+	err := m.each(func(platform Platform, e Ex) error {
+		acc, err := e.GetAccount()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		r[platform] = acc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// balances of all platforms joined
+func (m *MultiEx) GetAccount() (*Account, error) {
+	accs, err := m.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	var toJoin []Account
+	for _, platform := range m.platforms {
+		toJoin = append(toJoin, *accs[platform])
+	}
+	return JoinAccounts(toJoin...), nil
+}
+
+func (m *MultiEx) GetDepth(target PairExt, limit int) (*Depth, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.GetDepth(target.Market(), target.Pair(), limit)
+}
+
+// target requires period too
+func (m *MultiEx) GetKline(target PairExt, since *time.Time) (*Kline, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	if !target.HasPeriod() {
+		return nil, errorz.Errorf("target(%s) requires period", target.String())
+	}
+	return e.GetKline(target.Market(), target.Pair(), target.Period(), since)
+}
+
+func (m *MultiEx) GetFills(target PairExt, fromId *int64, limit int) ([]Fill, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.GetFills(target.Market(), target.Pair(), fromId, limit)
+}
+
+func (m *MultiEx) Trade(target PairExt, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.Trade(target.Market(), target.Pair(), t, amount, price)
+}
+
+// request is routed by platform like Trade
+func (m *MultiEx) TradeWithOptions(platform Platform, req TradeRequest) (*OrderId, error) {
+	e, err := m.Ex(platform)
+	if err != nil {
+		return nil, err
+	}
+	return TradeWithOptions(e, req)
+}
+
+func (m *MultiEx) GetAllOrders(target PairExt) ([]Order, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.GetAllOrders(target.Market(), target.Pair())
+}
+
+func (m *MultiEx) GetOpenOrders(target PairExt) ([]Order, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.GetOpenOrders(target.Market(), target.Pair())
+}
+
+// OrderId has no platform, it is given by caller
+func (m *MultiEx) GetOrder(platform Platform, id OrderId) (*Order, error) {
+	e, err := m.Ex(platform)
+	if err != nil {
+		return nil, err
+	}
+	return e.GetOrder(id)
+}
+
+func (m *MultiEx) CancelAllOrders(target PairExt) ([]OrderId, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return CancelAllOrders(e, target.Market(), target.Pair(), 0)
+}
+
+func (m *MultiEx) GetOrderByClientId(target PairExt, clientId string) (*Order, error) {
+	e, err := m.route(target)
+	if err != nil {
+		return nil, err
+	}
+	return GetOrderByClientId(e, target.Market(), target.Pair(), clientId)
+}
+
+func (m *MultiEx) CancelOrder(platform Platform, id OrderId) error {
+	e, err := m.Ex(platform)
+	if err != nil {
+		return err
+	}
+	return e.CancelOrder(id)
+}
+
+// Move withdraws amount of asset from platform to deposit address of the other platform on network,
+// id of withdrawal on from platform is returned
+func (m *MultiEx) Move(asset, network string, amount decimals.Decimal, from, to Platform) (string, error) {
+	if from == to {
+		return "", errorz.Errorf("move asset(%s) to the same platform(%s)", asset, from.String())
+	}
+	src, err := m.wallet(from)
+	if err != nil {
+		return "", err
+	}
+	dst, err := m.wallet(to)
+	if err != nil {
+		return "", err
+	}
+	address, err := dst.GetDepositAddress(asset, network)
+	if err != nil {
+		return "", err
+	}
+	return src.Withdraw(amount, *address)
+}
+
+func (m *MultiEx) wallet(platform Platform) (WalletEx, error) {
+	e, err := m.Ex(platform)
+	if err != nil {
+		return nil, err
+	}
+	return WalletOf(e)
+}
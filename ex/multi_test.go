@@ -0,0 +1,63 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func TestMultiEx(t *testing.T) {
+	binance, _ := newTestPaperEx(t)
+	krakenReal := newFakeEx()
+	krakenReal.config.Name = Kraken
+	krakenReal.ticks[testPair.SetMarket(MarketSpot)] = Tick{Last: decimals.NewFromInt(100)}
+	acc := NewEmptyAccount()
+	acc.SetSpot("USDT", decimals.NewFromInt(500), decimals.Zero)
+	kraken, err := NewPaperEx(krakenReal, acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewMultiEx(binance, binance); err == nil {
+		t.Fatal("duplicate platform should fail")
+	}
+	m, err := NewMultiEx(kraken, binance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := m.Platforms(); len(ps) != 2 || ps[0] != Binance || ps[1] != Kraken {
+		t.Fatalf("unexpected platforms %v", ps)
+	}
+
+	ticks, err := m.GetTicks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tick, ok := ticks[testPair.SetMarket(MarketSpot).SetPlatform(Kraken)]; !ok || !tick.Last.Equal(decimals.NewFromInt(100)) {
+		t.Fatalf("kraken tick not merged: %v", ticks)
+	}
+
+	total, err := m.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !total.Spot["USDT"].Free.Equal(decimals.NewFromInt(1500)) || !total.Spot["BTC"].Free.Equal(decimals.NewFromInt(2)) {
+		t.Fatalf("accounts not joined: %s", total.String())
+	}
+
+	// routed to kraken only
+	target := testPair.SetMarket(MarketSpot).SetPlatform(Kraken)
+	id, err := m.Trade(target, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetOrder(Kraken, *id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetOrder(Binance, *id); err == nil {
+		t.Fatal("order should not exist in binance")
+	}
+	if _, err := m.Trade(testPair.SetMarket(MarketSpot), TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99)); err == nil {
+		t.Fatal("target without platform should fail")
+	}
+}
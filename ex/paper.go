@@ -0,0 +1,435 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPaperDepth = 20
+)
+
+type (
+	// PaperEx is a paper trading exchange, market data comes from a wrapped real exchange,
+	// orders are matched against the latest Depth snapshot and settled in a local Account.
+	//
+	// A limit order which crosses the book when placed fills as taker at book prices,
+	// the rest of it stays open and fills as maker at its own price once the book trades through it.
+	// Market orders never rest, unfilled part is canceled (or expired if nothing filled).
+	// Liquidity taken from a snapshot is not available again until a snapshot of another Time arrives.
+	PaperEx struct {
+		real       Ex
+		config     *ExConfig
+		mu         sync.Mutex
+		ledger     *localLedger
+		marketInfo *MarketInfo
+		taken      map[PairExt]*bookTaken
+	}
+
+	// unit amount taken from levels of a depth snapshot
+	bookTaken struct {
+		time   time.Time
+		levels map[string]decimals.Decimal // by side and price
+	}
+)
+
+// real: exchange to read market data from, it never receives orders
+// initAccount: initial balances, nil means empty account
+func NewPaperEx(real Ex, initAccount *Account) (*PaperEx, error) {
+	if real == nil {
+		return nil, errorz.Errorf("nil real exchange")
+	}
+	if real.Config() == nil {
+		return nil, errorz.Errorf("nil config of real exchange")
+	}
+	return &PaperEx{
+		real:   real,
+		config: real.Config(),
+		ledger: newLocalLedger(real.Config(), initAccount),
+		taken:  map[PairExt]*bookTaken{},
+	}, nil
+}
+
+func (p *PaperEx) Config() *ExConfig {
+	return p.config
+}
+
+// market data methods, periods and depth of real exchange, simulated trading of spot and margin markets
+func (p *PaperEx) Capabilities() Capabilities {
+	real := p.real.Capabilities()
+	c := DefaultCapabilities(p)
+	var methods []ExMethod
+	for _, method := range c.Methods {
+		switch method {
+		case ExMethodGetMarketInfo, ExMethodGetDepth, ExMethodGetTicks, ExMethodGetKline, ExMethodGetFills:
+			if !real.HasMethod(method) {
+				continue
+			}
+		}
+		methods = append(methods, method)
+	}
+	c.Methods = methods
+	c.Markets = intersectMarkets(real, []Market{MarketSpot, MarketMargin})
+	c.TradeTypes = []TradeTypeSide{TradeTypeSideLimitBuy, TradeTypeSideLimitSell, TradeTypeSideMarketBuy, TradeTypeSideMarketSell}
+	c.OrderTypes = []OrderType{OrderTypeLimit, OrderTypeMarket}
+	c.TimeInForces = []TimeInForce{TimeInForceGTC, TimeInForceIOC, TimeInForceFOK, TimeInForcePostOnly}
+	c.Periods, c.MaxDepth = real.Periods, real.MaxDepth
+	return SortCapabilities(c)
+}
+
+func (p *PaperEx) GetMarketInfo() (*MarketInfo, error) {
+	return p.real.GetMarketInfo()
+}
+
+func (p *PaperEx) GetAccount() (*Account, error) {
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return copyAccount(p.ledger.account), nil
+}
+
+func (p *PaperEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	return p.real.GetDepth(market, target, limit)
+}
+
+func (p *PaperEx) GetTicks() (map[PairExt]Tick, error) {
+	return p.real.GetTicks()
+}
+
+func (p *PaperEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	return p.real.GetKline(market, target, period, since)
+}
+
+func (p *PaperEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	return p.real.GetFills(market, target, fromId, limit)
+}
+
+func (p *PaperEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	return decimals.Zero, ErrFunctionNotSupported
+}
+
+func (p *PaperEx) Borrow(asset string, amount decimals.Decimal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.borrow(asset, amount)
+}
+
+func (p *PaperEx) Repay(asset string, amount decimals.Decimal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.repay(asset, amount)
+}
+
+func (p *PaperEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.transfer(asset, amount, target)
+}
+
+func (p *PaperEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	return p.trade(market, target, t, amount, price, TimeInForceGTC, "")
+}
+
+// limit and market orders with any time in force and client order id are supported
+func (p *PaperEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	if err := req.Verify(); err != nil {
+		return nil, err
+	}
+	if (req.Type != OrderTypeLimit && req.Type != OrderTypeMarket) || req.ReduceOnly {
+		return nil, NewUnsupportedRequestError(p, req)
+	}
+	return p.trade(req.Market, req.Pair, req.TypeSide(), req.Amount, req.Price, req.TimeInForce, req.ClientId)
+}
+
+// existing order is returned if clientId is taken
+func (p *PaperEx) trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal, tif TimeInForce, clientId string) (*OrderId, error) {
+	if id, ok := p.clientOrder(clientId); ok {
+		return &id, nil
+	}
+	info, err := p.pairInfo(market, target)
+	if err != nil {
+		return nil, err
+	}
+	amount, price, err = verifyTrade(market, t, amount, price, info)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := p.depth(market, target)
+	if err != nil {
+		return nil, err
+	}
+	if tif == TimeInForcePostOnly && fillable(depth, nil, t, price).IsPositive() {
+		return nil, errorz.Errorf("post only order would immediately match")
+	}
+
+	toFreeze := amount
+	if t.IsBuy() {
+		if t.IsLimit() {
+			toFreeze = amount.Mul(price)
+		} else {
+			toFreeze = marketBuyCost(depth.Sells, amount)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := p.ledger.clientOrder(clientId); ok {
+		return &id, nil // placed by a concurrent request
+	}
+	order, err := p.ledger.open(market, target, t, amount, price, toFreeze, clientId)
+	if err != nil {
+		return nil, err
+	}
+	if tif == TimeInForceFOK && fillable(depth, p.takenOf(market, target, depth), t, price).LessThan(amount) {
+		p.ledger.update(order, true) // killed without any deal
+	} else {
+		p.match(order, market, depth, info, true, t.IsMarket() || tif == TimeInForceIOC || tif == TimeInForceFOK)
+	}
+	id := order.Id
+	return &id, nil
+}
+
+func (p *PaperEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.list(market, target, false), nil
+}
+
+func (p *PaperEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.list(market, target, true), nil
+}
+
+func (p *PaperEx) GetOrder(id OrderId) (*Order, error) {
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.get(id)
+}
+
+func (p *PaperEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	id, ok := p.clientOrder(clientId)
+	if !ok || id.Market() != market || id.Pair() != target {
+		return nil, NewExError(ErrorKindOrderNotFound, "no order with client id(%s)", clientId)
+	}
+	return p.GetOrder(id)
+}
+
+func (p *PaperEx) CancelOrder(id OrderId) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.cancel(id)
+}
+
+func (p *PaperEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var r []OrderId
+	for _, order := range p.ledger.list(market, target, true) {
+		if err := p.ledger.cancel(order.Id); err != nil {
+			return r, err
+		}
+		r = append(r, order.Id)
+	}
+	return r, nil
+}
+
+func (p *PaperEx) clientOrder(clientId string) (OrderId, bool) {
+	if clientId == "" {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ledger.clientOrder(clientId)
+}
+
+func (p *PaperEx) pairInfo(market Market, target Pair) (*PairInfo, error) {
+	p.mu.Lock()
+	mi := p.marketInfo
+	p.mu.Unlock()
+	if mi == nil {
+		var err error
+		mi, err = p.real.GetMarketInfo()
+		if err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		p.marketInfo = mi
+		p.mu.Unlock()
+	}
+	return findPairInfo(mi, market, target)
+}
+
+func (p *PaperEx) depth(market Market, target Pair) (*Depth, error) {
+	limit := p.config.MaxDepth
+	if limit <= 0 {
+		limit = defaultPaperDepth
+	}
+	depth, err := p.real.GetDepth(market, target, limit)
+	if err != nil {
+		return nil, err
+	}
+	// never modify what real exchange returned
+	cpy := &Depth{Time: depth.Time}
+	cpy.Buys = RemoveInvalidOrders(depth.Buys)
+	cpy.Sells = RemoveInvalidOrders(depth.Sells)
+	cpy.Sort()
+	return cpy, nil
+}
+
+// match resting limit orders against latest depth snapshots
+func (p *PaperEx) refresh() error {
+	type key struct {
+		market Market
+		pair   Pair
+	}
+	p.mu.Lock()
+	pending := map[key]bool{}
+	for _, order := range p.ledger.pending() {
+		pending[key{market: p.ledger.markets[order.Id], pair: order.Pair}] = true
+	}
+	p.mu.Unlock()
+
+	for k := range pending {
+		info, err := p.pairInfo(k.market, k.pair)
+		if err != nil {
+			return err
+		}
+		depth, err := p.depth(k.market, k.pair)
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		for _, order := range p.ledger.pending() {
+			if p.ledger.markets[order.Id] == k.market && order.Pair == k.pair {
+				p.match(order, k.market, depth, info, false, false)
+			}
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// p.mu must be held
+// closeUnfilled: unfilled part is canceled after matching instead of resting
+func (p *PaperEx) match(order *Order, market Market, depth *Depth, info *PairInfo, taker, closeUnfilled bool) {
+	book := depth.Buys
+	if order.TypeSide.IsBuy() {
+		book = depth.Sells
+	}
+	taken := p.takenOf(market, order.Pair, depth)
+	feeRate := p.config.MakerFee
+	if taker {
+		feeRate = p.config.TakerFee
+	}
+
+	left := order.Amount.Sub(order.DealAmount)
+	for _, ob := range book {
+		if !left.IsPositive() {
+			break
+		}
+		if order.TypeSide.IsLimit() {
+			if order.TypeSide.IsBuy() && ob.Price.GreaterThan(order.Price) {
+				break
+			}
+			if order.TypeSide.IsSell() && ob.Price.LessThan(order.Price) {
+				break
+			}
+		}
+		dealPrice := ob.Price
+		if !taker {
+			dealPrice = order.Price
+		}
+		level := takenLevel(order.TypeSide, ob.Price)
+		deal := roundAmount(decimals.Min(ob.Amount.Sub(taken.levels[level]), left), info)
+		if !deal.IsPositive() {
+			continue // taken by earlier matches of this snapshot
+		}
+		if order.TypeSide.IsBuy() {
+			// market buy may run out of frozen quote if book moved
+			if frozen := p.ledger.frozen[order.Id]; deal.Mul(dealPrice).GreaterThan(frozen) {
+				deal = roundAmount(frozen.Div(dealPrice), info)
+			}
+		}
+		if !deal.IsPositive() {
+			break
+		}
+		p.ledger.settle(order, deal, dealPrice, feeRate)
+		taken.levels[level] = taken.levels[level].Add(deal)
+		left = left.Sub(deal)
+	}
+	p.ledger.update(order, closeUnfilled)
+}
+
+// p.mu must be held
+// liquidity taken from depth, reset if it is another snapshot
+func (p *PaperEx) takenOf(market Market, target Pair, depth *Depth) *bookTaken {
+	key := target.SetMarket(market)
+	taken := p.taken[key]
+	if taken == nil || !taken.time.Equal(depth.Time) {
+		taken = &bookTaken{time: depth.Time, levels: map[string]decimals.Decimal{}}
+		p.taken[key] = taken
+	}
+	return taken
+}
+
+// level of book which order of t takes from
+func takenLevel(t TradeTypeSide, price decimals.Decimal) string {
+	if t.IsBuy() {
+		return "sell@" + price.String()
+	}
+	return "buy@" + price.String()
+}
+
+// unit amount in book which order can take at once, limit price is ignored by market orders
+// taken: liquidity already taken from depth, nil means none
+func fillable(depth *Depth, taken *bookTaken, t TradeTypeSide, price decimals.Decimal) decimals.Decimal {
+	book := depth.Buys
+	if t.IsBuy() {
+		book = depth.Sells
+	}
+	r := decimals.Zero
+	for _, ob := range book {
+		if t.IsLimit() && ((t.IsBuy() && ob.Price.GreaterThan(price)) || (t.IsSell() && ob.Price.LessThan(price))) {
+			break
+		}
+		left := ob.Amount
+		if taken != nil {
+			left = left.Sub(taken.levels[takenLevel(t, ob.Price)])
+		}
+		if left.IsPositive() {
+			r = r.Add(left)
+		}
+	}
+	return r
+}
+
+// quote amount required to market buy unit amount, limited by depth
+func marketBuyCost(sells OrderBookList, amount decimals.Decimal) decimals.Decimal {
+	cost := decimals.Zero
+	left := amount
+	for _, ob := range sells {
+		if !left.IsPositive() {
+			break
+		}
+		deal := decimals.Min(ob.Amount, left)
+		cost = cost.Add(deal.Mul(ob.Price))
+		left = left.Sub(deal)
+	}
+	return cost
+}
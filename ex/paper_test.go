@@ -0,0 +1,128 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+func newTestPaperEx(t *testing.T) (*PaperEx, *fakeEx) {
+	real := newFakeEx()
+	acc := NewEmptyAccount()
+	acc.SetSpot("USDT", decimals.NewFromInt(1000), decimals.Zero)
+	acc.SetSpot("BTC", decimals.NewFromInt(2), decimals.Zero)
+	p, err := NewPaperEx(real, acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, real
+}
+
+func TestPaperEx_MarketBuy(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.NewFromFloat64(1.5), decimals.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != TradeStatusFilled || !order.DealAmount.Equal(decimals.NewFromFloat64(1.5)) {
+		t.Fatalf("unexpected order %s", order.String())
+	}
+	// 1 x 101 + 0.5 x 102
+	if !order.AvgPrice.Mul(order.DealAmount).EqualInt(152) {
+		t.Fatalf("unexpected avg price %s", order.AvgPrice.String())
+	}
+	acc, err := p.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Spot["USDT"].Free.EqualInt(848) || !acc.Spot["USDT"].Locked.IsZero() {
+		t.Fatalf("unexpected USDT balance %v", acc.Spot["USDT"])
+	}
+	// taker fee 0.2% charged in BTC
+	if !acc.Spot["BTC"].Free.Equal(decimals.NewFromFloat64(3.497)) {
+		t.Fatalf("unexpected BTC balance %v", acc.Spot["BTC"])
+	}
+}
+
+func TestPaperEx_LimitSellRestThenFill(t *testing.T) {
+	p, real := newTestPaperEx(t)
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.NewFromFloat64(1.23456), decimals.NewFromFloat64(105.123))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != TradeStatusNew || !order.Amount.Equal(decimals.NewFromFloat64(1.234)) || !order.Price.Equal(decimals.NewFromFloat64(105.12)) {
+		t.Fatalf("lot step and precision not respected %s", order.String())
+	}
+	acc, _ := p.GetAccount()
+	if !acc.Spot["BTC"].Locked.Equal(decimals.NewFromFloat64(1.234)) {
+		t.Fatalf("unexpected BTC balance %v", acc.Spot["BTC"])
+	}
+
+	real.setDepth(testPair, [][2]float64{{106, 1}}, [][2]float64{{106, 1}})
+	order, err = p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != TradeStatusPartiallyFilled || !order.DealAmount.EqualInt(1) || !order.AvgPrice.Equal(decimals.NewFromFloat64(105.12)) {
+		t.Fatalf("unexpected order %s", order.String())
+	}
+	if err := p.CancelOrder(*id); err != nil {
+		t.Fatal(err)
+	}
+	acc, _ = p.GetAccount()
+	if !acc.Spot["BTC"].Locked.IsZero() || !acc.Spot["BTC"].Free.EqualInt(1) {
+		t.Fatalf("unexpected BTC balance %v", acc.Spot["BTC"])
+	}
+	// maker fee 0.1% charged in USDT
+	expectUSDT := decimals.NewFromInt(1000).Add(decimals.NewFromFloat64(105.12).Mul(decimals.NewFromFloat64(0.999)))
+	if !acc.Spot["USDT"].Free.Equal(expectUSDT) {
+		t.Fatalf("unexpected USDT balance %v", acc.Spot["USDT"])
+	}
+	open, err := p.GetOpenOrders(MarketSpot, testPair)
+	if err != nil || len(open) != 0 {
+		t.Fatalf("unexpected open orders %v %v", open, err)
+	}
+}
+
+func TestPaperEx_InsufficientBalance(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	if _, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(20), decimals.NewFromInt(100)); err == nil {
+		t.Fatal("insufficient balance expected")
+	}
+}
+
+func TestPaperEx_SnapshotTakenOnce(t *testing.T) {
+	p, real := newTestPaperEx(t)
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.NewFromFloat64(1.5), decimals.NewFromInt(105))
+	if err != nil {
+		t.Fatal(err)
+	}
+	real.setDepth(testPair, [][2]float64{{107, 1}}, [][2]float64{{106, 1}})
+	for i := 0; i < 3; i++ {
+		order, err := p.GetOrder(*id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !order.DealAmount.EqualInt(1) {
+			t.Fatalf("poll %d of the same snapshot: deal amount %s, 1 expected", i, order.DealAmount.String())
+		}
+	}
+	// a new snapshot brings liquidity again
+	real.depths[testPair.SetMarket(MarketSpot)].Time = real.depths[testPair.SetMarket(MarketSpot)].Time.Add(time.Second)
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != TradeStatusFilled || !order.DealAmount.Equal(decimals.NewFromFloat64(1.5)) {
+		t.Fatalf("unexpected order %s", order.String())
+	}
+}
@@ -0,0 +1,414 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"time"
+)
+
+const (
+	EndpointDefault EndpointClass = "default" // limited by ExConfig.RateLimit
+	EndpointFill    EndpointClass = "fill"    // limited by ExConfig.FillRateLimit
+	EndpointKline   EndpointClass = "kline"   // limited by ExConfig.KlineRateLimit
+)
+
+type (
+	EndpointClass string
+
+	RateLimitOption struct {
+		FailFast bool           // return ErrRateLimited at once instead of waiting for budget
+		Burst    int            // max weight consumed without waiting, 1 by default
+		Weights  map[string]int // Ex method name -> request weight, 1 by default
+	}
+
+	// token bucket, one token refilled each interval
+	tokenBucket struct {
+		mu       sync.Mutex
+		interval time.Duration
+		capacity float64
+		tokens   float64
+		last     time.Time
+	}
+
+	// RateLimitedEx wraps an Ex and spaces requests by rate limits of its config,
+	// each endpoint class has its own budget.
+	RateLimitedEx struct {
+		inner   Ex
+		option  RateLimitOption
+		buckets map[EndpointClass]*tokenBucket
+		now     func() time.Time
+		sleep   func(time.Duration)
+	}
+)
+
+func NewRateLimitedEx(inner Ex, option RateLimitOption) (*RateLimitedEx, error) {
+	if inner == nil || inner.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to rate limit")
+	}
+	if option.Burst <= 0 {
+		option.Burst = 1
+	}
+	config := inner.Config()
+	r := &RateLimitedEx{
+		inner:  inner,
+		option: option,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+	r.buckets = map[EndpointClass]*tokenBucket{
+		EndpointDefault: newTokenBucket(config.RateLimit, option.Burst),
+		EndpointFill:    newTokenBucket(config.FillRateLimit, option.Burst),
+		EndpointKline:   newTokenBucket(config.KlineRateLimit, option.Burst),
+	}
+	return r, nil
+}
+
+func newTokenBucket(interval time.Duration, burst int) *tokenBucket {
+	return &tokenBucket{interval: interval, capacity: float64(burst), tokens: float64(burst)}
+}
+
+// take tokens of weight, returns how long to wait before request could be sent.
+// Full weight is always charged, tokens go negative in debt and later callers wait longer.
+// In fail fast mode, nothing is taken if budget is not enough, a request heavier than burst needs a full bucket.
+func (tb *tokenBucket) take(weight int, now time.Time, failFast bool) (time.Duration, bool) {
+	if tb.interval <= 0 {
+		return 0, true
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if !tb.last.IsZero() {
+		tb.tokens += float64(now.Sub(tb.last)) / float64(tb.interval)
+		if tb.tokens > tb.capacity {
+			tb.tokens = tb.capacity
+		}
+	}
+	tb.last = now
+
+	need := float64(weight)
+	if tb.tokens >= need {
+		tb.tokens -= need
+		return 0, true
+	}
+	wait := time.Duration((need - tb.tokens) * float64(tb.interval))
+	if failFast {
+		if tb.tokens < tb.capacity {
+			return wait, false
+		}
+		wait = 0 // heavier than burst, sent with a full bucket and the rest in debt
+	}
+	tb.tokens -= need
+	return wait, true
+}
+
+func (r *RateLimitedEx) wait(class EndpointClass, method string) error {
+	weight, ok := r.option.Weights[method]
+	if !ok || weight <= 0 {
+		weight = 1
+	}
+	wait, ok := r.buckets[class].take(weight, r.now(), r.option.FailFast)
+	if !ok {
+		return NewExError(ErrorKindRateLimited, "rate limited, %s needs to wait %s", method, wait)
+	}
+	if wait > 0 {
+		r.sleep(wait)
+	}
+	return nil
+}
+
+func (r *RateLimitedEx) Config() *ExConfig {
+	return r.inner.Config()
+}
+
+func (r *RateLimitedEx) Capabilities() Capabilities {
+	return r.inner.Capabilities()
+}
+
+func (r *RateLimitedEx) GetMarketInfo() (*MarketInfo, error) {
+	if err := r.wait(EndpointDefault, "GetMarketInfo"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetMarketInfo()
+}
+
+func (r *RateLimitedEx) GetAccount() (*Account, error) {
+	if err := r.wait(EndpointDefault, "GetAccount"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetAccount()
+}
+
+func (r *RateLimitedEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	if err := r.wait(EndpointDefault, "GetDepth"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetDepth(market, target, limit)
+}
+
+func (r *RateLimitedEx) GetTicks() (map[PairExt]Tick, error) {
+	if err := r.wait(EndpointDefault, "GetTicks"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetTicks()
+}
+
+func (r *RateLimitedEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	if err := r.wait(EndpointKline, "GetKline"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetKline(market, target, period, since)
+}
+
+func (r *RateLimitedEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	if err := r.wait(EndpointFill, "GetFills"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetFills(market, target, fromId, limit)
+}
+
+func (r *RateLimitedEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	if err := r.wait(EndpointDefault, "GetBorrowable"); err != nil {
+		return decimals.Zero, err
+	}
+	return r.inner.GetBorrowable(asset)
+}
+
+func (r *RateLimitedEx) Borrow(asset string, amount decimals.Decimal) error {
+	if err := r.wait(EndpointDefault, "Borrow"); err != nil {
+		return err
+	}
+	return r.inner.Borrow(asset, amount)
+}
+
+func (r *RateLimitedEx) Repay(asset string, amount decimals.Decimal) error {
+	if err := r.wait(EndpointDefault, "Repay"); err != nil {
+		return err
+	}
+	return r.inner.Repay(asset, amount)
+}
+
+func (r *RateLimitedEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	if err := r.wait(EndpointDefault, "Transfer"); err != nil {
+		return err
+	}
+	return r.inner.Transfer(asset, amount, target)
+}
+
+func (r *RateLimitedEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	if err := r.wait(EndpointDefault, "Trade"); err != nil {
+		return nil, err
+	}
+	return r.inner.Trade(market, target, t, amount, price)
+}
+
+func (r *RateLimitedEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	if err := r.wait(EndpointDefault, "Trade"); err != nil {
+		return nil, err
+	}
+	return TradeWithOptions(r.inner, req)
+}
+
+func (r *RateLimitedEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	if err := r.wait(EndpointDefault, "GetAllOrders"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetAllOrders(market, target)
+}
+
+func (r *RateLimitedEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	if err := r.wait(EndpointDefault, "GetOpenOrders"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetOpenOrders(market, target)
+}
+
+func (r *RateLimitedEx) GetOrder(id OrderId) (*Order, error) {
+	if err := r.wait(EndpointDefault, "GetOrder"); err != nil {
+		return nil, err
+	}
+	return r.inner.GetOrder(id)
+}
+
+// each request is limited if inner exchange cancels orders one by one
+func (r *RateLimitedEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	ac, ok := r.inner.(AllOrdersCanceler)
+	if !ok {
+		return cancelAllOrders(r, market, target, 0)
+	}
+	if err := r.wait(EndpointDefault, "CancelOrder"); err != nil {
+		return nil, err
+	}
+	return ac.CancelAllOrders(market, target)
+}
+
+// each request is limited if inner exchange gets open orders pair by pair
+func (r *RateLimitedEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	g, ok := r.inner.(OpenOrdersGetter)
+	if !ok {
+		return getAllOpenOrders(r, market, 0)
+	}
+	if err := r.wait(EndpointDefault, "GetAllOpenOrders"); err != nil {
+		return nil, err
+	}
+	return g.GetAllOpenOrders(market)
+}
+
+func (r *RateLimitedEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	if err := r.wait(EndpointDefault, "GetOrder"); err != nil {
+		return nil, err
+	}
+	return GetOrderByClientId(r.inner, market, target, clientId)
+}
+
+func (r *RateLimitedEx) CancelOrder(id OrderId) error {
+	if err := r.wait(EndpointDefault, "CancelOrder"); err != nil {
+		return err
+	}
+	return r.inner.CancelOrder(id)
+}
+
+func (r *RateLimitedEx) GetPositions(market Market) ([]Position, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetPositions"); err != nil {
+		return nil, err
+	}
+	return de.GetPositions(market)
+}
+
+func (r *RateLimitedEx) SetLeverage(market Market, target Pair, leverage int) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	if err := r.wait(EndpointDefault, "SetLeverage"); err != nil {
+		return err
+	}
+	return de.SetLeverage(market, target, leverage)
+}
+
+func (r *RateLimitedEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	if err := r.wait(EndpointDefault, "SetMarginMode"); err != nil {
+		return err
+	}
+	return de.SetMarginMode(market, target, mode)
+}
+
+func (r *RateLimitedEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetFundingRate"); err != nil {
+		return nil, err
+	}
+	return de.GetFundingRate(target)
+}
+
+func (r *RateLimitedEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetFundingHistory"); err != nil {
+		return nil, err
+	}
+	return de.GetFundingHistory(target, since, limit)
+}
+
+func (r *RateLimitedEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetMarkPrice"); err != nil {
+		return nil, err
+	}
+	return de.GetMarkPrice(market, target)
+}
+
+func (r *RateLimitedEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetDepositAddress"); err != nil {
+		return nil, err
+	}
+	return we.GetDepositAddress(asset, network)
+}
+
+func (r *RateLimitedEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return "", err
+	}
+	if err := r.wait(EndpointDefault, "Withdraw"); err != nil {
+		return "", err
+	}
+	return we.Withdraw(amount, to)
+}
+
+func (r *RateLimitedEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetDeposits"); err != nil {
+		return nil, err
+	}
+	return we.GetDeposits(asset, since)
+}
+
+func (r *RateLimitedEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetWithdrawals"); err != nil {
+		return nil, err
+	}
+	return we.GetWithdrawals(asset, since)
+}
+
+func (r *RateLimitedEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointDefault, "GetTransfers"); err != nil {
+		return nil, err
+	}
+	return we.GetTransfers(asset, since)
+}
+
+func (r *RateLimitedEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	fg, err := FillTimeGetterOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.wait(EndpointFill, "GetFillsByTime"); err != nil {
+		return nil, err
+	}
+	return fg.GetFillsByTime(market, target, begin, duration)
+}
+
+func (r *RateLimitedEx) GetServerTime() (time.Time, error) {
+	st, err := ServerTimerOf(r.inner)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := r.wait(EndpointDefault, "GetServerTime"); err != nil {
+		return time.Time{}, err
+	}
+	return st.GetServerTime()
+}
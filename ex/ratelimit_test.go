@@ -0,0 +1,90 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedEx(t *testing.T) {
+	real := newFakeEx()
+	real.config.RateLimit = time.Second
+	real.config.KlineRateLimit = 10 * time.Second
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	slept := time.Duration(0)
+
+	r, err := NewRateLimitedEx(real, RateLimitOption{Burst: 2, Weights: map[string]int{"GetTicks": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { slept += d; now = now.Add(d) }
+
+	// burst of 2 passes, the 3rd waits 1 second
+	for i := 0; i < 3; i++ {
+		if _, err := r.GetDepth(MarketSpot, testPair, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slept != time.Second {
+		t.Fatalf("1s wait expected, got %s", slept)
+	}
+	// kline has its own budget
+	if _, err := r.GetKline(MarketSpot, testPair, Period1Min, nil); err != nil {
+		t.Fatal(err)
+	}
+	if slept != time.Second {
+		t.Fatalf("kline should not wait, got %s", slept)
+	}
+	// weight 2 needs a full bucket
+	if _, err := r.GetTicks(); err != nil {
+		t.Fatal(err)
+	}
+	if slept != 3*time.Second {
+		t.Fatalf("3s wait expected, got %s", slept)
+	}
+
+	r.option.FailFast = true
+	if _, err := r.GetTicks(); err == nil {
+		t.Fatal("rate limited error expected")
+	}
+}
+
+func TestRateLimitedEx_WeightOverBurst(t *testing.T) {
+	real := newFakeEx()
+	real.config.RateLimit = time.Second
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	slept := time.Duration(0)
+
+	r, err := NewRateLimitedEx(real, RateLimitOption{Weights: map[string]int{"GetTicks": 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { slept += d; now = now.Add(d) }
+
+	// weight 5 with default burst 1 costs 5 tokens, not 1
+	if _, err := r.GetTicks(); err != nil {
+		t.Fatal(err)
+	}
+	if slept != 4*time.Second {
+		t.Fatalf("4s wait expected, got %s", slept)
+	}
+	if _, err := r.GetDepth(MarketSpot, testPair, 5); err != nil {
+		t.Fatal(err)
+	}
+	if slept != 5*time.Second {
+		t.Fatalf("5s wait expected, got %s", slept)
+	}
+
+	// fail fast sends a heavy request with a full bucket, then the debt is paid before others
+	r.option.FailFast = true
+	now = now.Add(time.Second)
+	if _, err := r.GetTicks(); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err := r.GetDepth(MarketSpot, testPair, 5); err == nil {
+		t.Fatal("rate limited error expected while in debt")
+	}
+}
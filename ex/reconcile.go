@@ -0,0 +1,527 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	DriftCause string
+
+	// OwnFill is a fill of our own order booked into expected account
+	OwnFill struct {
+		Market   Market
+		Pair     Pair
+		Side     TradeSide
+		Amount   decimals.Decimal // unit amount
+		Price    decimals.Decimal
+		Fee      decimals.Decimal
+		FeeAsset string // asset received by default
+	}
+
+	// BalanceDrift is the difference of an asset between exchange and expected account
+	BalanceDrift struct {
+		Market   Market
+		Asset    string
+		Expected Balance // Free of it is the expected total, expected account doesn't follow locked amounts
+		Actual   Balance
+		Total    decimals.Decimal // actual minus expected total
+		Borrowed decimals.Decimal // actual minus expected borrowed
+		Interest decimals.Decimal // actual minus expected interest
+		Cause    DriftCause
+	}
+
+	ReconcileReport struct {
+		Time   time.Time
+		Drifts []BalanceDrift // sorted by market and asset
+	}
+
+	ReconcilerOption struct {
+		Tolerance  decimals.Decimal            // max absolute difference of an asset not reported
+		Tolerances map[string]decimals.Decimal // tolerance by asset, Tolerance for others
+		Interval   time.Duration               // interval of GetAccount, 1 minute by default
+		BufferSize int
+
+		// transfers to explain drifts are fetched since the oldest pending one seen or TransferLookback ago,
+		// whichever is earlier, but never more than 90 days ago or before reconciler created, 1 day by default
+		TransferLookback time.Duration
+	}
+
+	// Reconciler keeps an expected account updated by booked fills, transfers, borrows and repays,
+	// and diffs it with GetAccount of exchange by market and asset. Only spot and margin accounts are compared.
+	Reconciler struct {
+		e        Ex
+		option   ReconcilerOption
+		since    time.Time  // reconciler created
+		pending  *time.Time // oldest pending transfer seen by last fetch
+		mu       sync.Mutex
+		expected *Account
+		fees     map[string]decimals.Decimal // booked fees by market and asset
+		booked   map[string]TransferStatus   // last booked status of transfers by kind and id
+		reports  chan ReconcileReport
+		errs     chan error
+		done     chan struct{}
+		once     sync.Once
+		started  sync.Once
+		wg       sync.WaitGroup
+	}
+)
+
+const (
+	DriftCauseUnknown    DriftCause = "unknown"
+	DriftCauseInterest   DriftCause = "interest"    // interest accrued but not booked
+	DriftCauseBorrow     DriftCause = "borrow"      // borrow or repay not booked
+	DriftCauseTransfer   DriftCause = "transfer"    // deposit, withdrawal or transfer between markets not booked
+	DriftCauseFee        DriftCause = "fee"         // fee charged differs from booked, or charged in another asset
+	DriftCauseMissedFill DriftCause = "missed_fill" // unit and quote of a pair drift in opposite directions
+
+	defaultReconcileInterval = time.Minute
+	defaultTransferLookback  = 24 * time.Hour
+	maxTransferLookback      = 90 * 24 * time.Hour // history window of transfers of most exchanges
+)
+
+func (c DriftCause) String() string {
+	return string(c)
+}
+
+func (r ReconcileReport) OK() bool {
+	return len(r.Drifts) == 0
+}
+
+// NewReconciler starts from expected account, or from GetAccount of exchange if expected is nil
+func NewReconciler(e Ex, expected *Account, option ReconcilerOption) (*Reconciler, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to reconcile")
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultReconcileInterval
+	}
+	if option.TransferLookback <= 0 {
+		option.TransferLookback = defaultTransferLookback
+	}
+	if expected == nil {
+		acc, err := e.GetAccount()
+		if err != nil {
+			return nil, err
+		}
+		expected = acc
+	}
+	r := &Reconciler{
+		e:        e,
+		option:   option,
+		since:    nowOf(e.Config()),
+		expected: NewEmptyAccount(),
+		fees:     map[string]decimals.Decimal{},
+		booked:   map[string]TransferStatus{},
+		reports:  make(chan ReconcileReport, option.BufferSize),
+		errs:     make(chan error, 16),
+		done:     make(chan struct{}),
+	}
+	// totals only, locked amounts move with orders which are not booked
+	for market, blcs := range map[Market]map[string]Balance{MarketSpot: expected.Spot, MarketMargin: expected.Margin} {
+		for asset, blc := range blcs {
+			r.balances(market)[asset] = Balance{Free: blc.Total(), Borrowed: blc.Borrowed, Interest: blc.Interest}
+		}
+	}
+	return r, nil
+}
+
+// Expected account now
+func (r *Reconciler) Expected() *Account {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyAccount(r.expected)
+}
+
+// BookFill books a fill of our own order, fee is charged in asset received unless FeeAsset is set
+func (r *Reconciler) BookFill(fill OwnFill) error {
+	if fill.Side != TradeSideBuy && fill.Side != TradeSideSell {
+		return errorz.Errorf("invalid fill side(%s)", fill.Side)
+	}
+	if !fill.Amount.IsPositive() || !fill.Price.IsPositive() {
+		return errorz.Errorf("invalid fill amount(%s) or price(%s)", fill.Amount.String(), fill.Price.String())
+	}
+	unit, quote := fill.Pair.Unit(), fill.Pair.Quote()
+	quoteAmount := fill.Amount.Mul(fill.Price)
+	unitDelta, quoteDelta, received := fill.Amount, decimals.Zero.Sub(quoteAmount), unit
+	if fill.Side == TradeSideSell {
+		unitDelta, quoteDelta, received = decimals.Zero.Sub(fill.Amount), quoteAmount, quote
+	}
+	feeAsset := fill.FeeAsset
+	if feeAsset == "" {
+		feeAsset = received
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(fill.Market, unit, unitDelta)
+	r.add(fill.Market, quote, quoteDelta)
+	if fill.Fee.IsPositive() {
+		r.add(fill.Market, feeAsset, decimals.Zero.Sub(fill.Fee))
+		key := string(fill.Market) + "/" + feeAsset
+		if _, ok := r.fees[key]; !ok {
+			r.fees[key] = decimals.Zero
+		}
+		r.fees[key] = r.fees[key].Add(fill.Fee)
+	}
+	return nil
+}
+
+// BookTransfer books a deposit, withdrawal or transfer between markets, deposits are booked to spot account.
+// Deposits are booked after success, withdrawals unless failed or canceled. Updates of a transfer with the same kind and id
+// book the difference only, so a pending withdrawal booked before is reversed when it fails or is canceled.
+func (r *Reconciler) BookTransfer(transfer AssetTransfer) error {
+	if transfer.Asset == "" || !transfer.Amount.IsPositive() {
+		return errorz.Errorf("invalid transfer(%s)", transfer.String())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for market, amount := range r.transferDelta(transfer) {
+		r.add(market, transfer.Asset, amount)
+	}
+	if transfer.Id != "" {
+		r.booked[transferKey(transfer)] = transfer.Status
+	}
+	return nil
+}
+
+func transferKey(transfer AssetTransfer) string {
+	return string(transfer.Kind) + "/" + transfer.Id
+}
+
+// effects of transfer not booked yet, with effects of its last booked status taken back, r.mu should be locked
+func (r *Reconciler) transferDelta(transfer AssetTransfer) map[Market]decimals.Decimal {
+	effects, _ := transferEffects(transfer)
+	if transfer.Id == "" {
+		return effects
+	}
+	status, ok := r.booked[transferKey(transfer)]
+	if !ok {
+		return effects
+	}
+	last := transfer
+	last.Status = status
+	lastEffects, _ := transferEffects(last)
+	delta := map[Market]decimals.Decimal{}
+	for market, amount := range effects {
+		delta[market] = amount
+	}
+	for market, amount := range lastEffects {
+		if _, ok := delta[market]; !ok {
+			delta[market] = decimals.Zero
+		}
+		delta[market] = delta[market].Sub(amount)
+	}
+	for market, amount := range delta {
+		if amount.IsZero() {
+			delete(delta, market)
+		}
+	}
+	return delta
+}
+
+func (r *Reconciler) BookBorrow(asset string, amount decimals.Decimal) error {
+	if !amount.IsPositive() {
+		return errorz.Errorf("invalid borrow amount(%s)", amount.String())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blc := r.expected.Margin[asset]
+	blc.Free = blc.Free.Add(amount)
+	blc.Borrowed = blc.Borrowed.Add(amount)
+	r.expected.Margin[asset] = blc
+	return nil
+}
+
+// BookRepay pays interest first, then principal, like exchanges do
+func (r *Reconciler) BookRepay(asset string, amount decimals.Decimal) error {
+	if !amount.IsPositive() {
+		return errorz.Errorf("invalid repay amount(%s)", amount.String())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blc := r.expected.Margin[asset]
+	payInterest := decimals.Min(blc.Interest, amount)
+	payBorrowed := decimals.Min(blc.Borrowed, amount.Sub(payInterest))
+	blc.Interest = blc.Interest.Sub(payInterest)
+	blc.Borrowed = blc.Borrowed.Sub(payBorrowed)
+	blc.Free = blc.Free.Sub(payInterest).Sub(payBorrowed)
+	r.expected.Margin[asset] = blc
+	return nil
+}
+
+func (r *Reconciler) BookInterest(asset string, amount decimals.Decimal) error {
+	if !amount.IsPositive() {
+		return errorz.Errorf("invalid interest amount(%s)", amount.String())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blc := r.expected.Margin[asset]
+	blc.Interest = blc.Interest.Add(amount)
+	r.expected.Margin[asset] = blc
+	return nil
+}
+
+// Reconcile diffs GetAccount of exchange with expected account once, drifts above tolerance are reported with likely causes
+func (r *Reconciler) Reconcile() (*ReconcileReport, error) {
+	actual, err := r.e.GetAccount()
+	if err != nil {
+		return nil, err
+	}
+	expected := r.Expected()
+	report := &ReconcileReport{Time: nowOf(r.e.Config())}
+	for _, market := range []Market{MarketSpot, MarketMargin} {
+		exp, act := expected.Spot, actual.Spot
+		if market == MarketMargin {
+			exp, act = expected.Margin, actual.Margin
+		}
+		assets := map[string]bool{}
+		for asset := range exp {
+			assets[asset] = true
+		}
+		for asset := range act {
+			assets[asset] = true
+		}
+		for asset := range assets {
+			d := BalanceDrift{Market: market, Asset: asset, Expected: exp[asset], Actual: act[asset], Cause: DriftCauseUnknown}
+			d.Total = d.Actual.Total().Sub(d.Expected.Total())
+			d.Borrowed = d.Actual.Borrowed.Sub(d.Expected.Borrowed)
+			d.Interest = d.Actual.Interest.Sub(d.Expected.Interest)
+			tolerance := r.tolerance(asset)
+			if abs(d.Total).GreaterThan(tolerance) || abs(d.Borrowed).GreaterThan(tolerance) || abs(d.Interest).GreaterThan(tolerance) {
+				report.Drifts = append(report.Drifts, d)
+			}
+		}
+	}
+	sort.Slice(report.Drifts, func(i, j int) bool {
+		if report.Drifts[i].Market == report.Drifts[j].Market {
+			return report.Drifts[i].Asset < report.Drifts[j].Asset
+		}
+		return report.Drifts[i].Market < report.Drifts[j].Market
+	})
+	if err := r.explain(report.Drifts); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// guess causes of drifts, the first matched one of interest, borrow, transfer, fee and missed fill
+func (r *Reconciler) explain(drifts []BalanceDrift) error {
+	if len(drifts) == 0 {
+		return nil
+	}
+	unbooked, err := r.unbookedTransfers()
+	if err != nil {
+		return err
+	}
+	var unexplained []int
+	for i := range drifts {
+		d := &drifts[i]
+		tolerance := r.tolerance(d.Asset)
+		key := string(d.Market) + "/" + d.Asset
+		r.mu.Lock()
+		fee, hasFee := r.fees[key]
+		r.mu.Unlock()
+		transfer, hasTransfer := unbooked[key]
+		switch {
+		case abs(d.Interest).GreaterThan(tolerance) && abs(d.Borrowed).LessThanOrEqual(tolerance):
+			d.Cause = DriftCauseInterest
+		case abs(d.Borrowed).GreaterThan(tolerance):
+			d.Cause = DriftCauseBorrow
+		case hasTransfer && !transfer.IsZero() && abs(d.Total.Sub(transfer)).LessThanOrEqual(tolerance):
+			d.Cause = DriftCauseTransfer
+		case hasFee && abs(d.Total).LessThanOrEqual(fee):
+			d.Cause = DriftCauseFee
+		default:
+			unexplained = append(unexplained, i)
+		}
+	}
+	if len(unexplained) < 2 {
+		return nil
+	}
+
+	mi, err := r.e.GetMarketInfo()
+	if err != nil {
+		return err
+	}
+	for _, i := range unexplained {
+		for _, j := range unexplained {
+			a, b := &drifts[i], &drifts[j]
+			if i == j || a.Market != b.Market || !a.Total.Mul(b.Total).LessThan(decimals.Zero) {
+				continue
+			}
+			_, listed := mi.Infos[NewPair(a.Asset, b.Asset).SetMarket(a.Market)]
+			_, reversed := mi.Infos[NewPair(b.Asset, a.Asset).SetMarket(a.Market)]
+			if listed || reversed {
+				a.Cause, b.Cause = DriftCauseMissedFill, DriftCauseMissedFill
+			}
+		}
+	}
+	return nil
+}
+
+// effects of recent transfers which are not booked, by market and asset, see TransferLookback,
+// empty if exchange doesn't support wallet
+func (r *Reconciler) unbookedTransfers() (map[string]decimals.Decimal, error) {
+	res := map[string]decimals.Decimal{}
+	we, err := WalletOf(r.e)
+	if err != nil {
+		return res, nil
+	}
+	since := r.transferSince()
+	var all []AssetTransfer
+	for _, get := range []func(string, *time.Time) ([]AssetTransfer, error){we.GetDeposits, we.GetWithdrawals, we.GetTransfers} {
+		transfers, err := get("", &since)
+		if err != nil && ErrorKindOf(err) != ErrorKindNotSupported {
+			return nil, err
+		}
+		all = append(all, transfers...)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = nil
+	for _, transfer := range all {
+		if !transfer.Status.End() && (r.pending == nil || transfer.Time.Before(*r.pending)) {
+			t := transfer.Time
+			r.pending = &t
+		}
+		for market, amount := range r.transferDelta(transfer) {
+			key := string(market) + "/" + transfer.Asset
+			if _, ok := res[key]; !ok {
+				res[key] = decimals.Zero
+			}
+			res[key] = res[key].Add(amount)
+		}
+	}
+	return res, nil
+}
+
+// balance changes of transfer by market, false if it doesn't change balance
+// watermark of fetching transfers, it moves with time but stays before pending transfers
+func (r *Reconciler) transferSince() time.Time {
+	now := nowOf(r.e.Config())
+	since := now.Add(-r.option.TransferLookback)
+	r.mu.Lock()
+	if r.pending != nil && r.pending.Before(since) {
+		since = *r.pending
+	}
+	r.mu.Unlock()
+	if floor := now.Add(-maxTransferLookback); since.Before(floor) {
+		since = floor
+	}
+	if since.Before(r.since) {
+		since = r.since
+	}
+	return since
+}
+
+func transferEffects(transfer AssetTransfer) (map[Market]decimals.Decimal, bool) {
+	switch transfer.Kind {
+	case TransferKindDeposit:
+		if transfer.Status != TransferStatusSuccess {
+			return nil, false
+		}
+		return map[Market]decimals.Decimal{MarketSpot: transfer.Amount}, true
+	case TransferKindWithdrawal:
+		if transfer.Status == TransferStatusFailed || transfer.Status == TransferStatusCanceled {
+			return nil, false
+		}
+		return map[Market]decimals.Decimal{MarketSpot: decimals.Zero.Sub(transfer.Amount.Add(transfer.Fee))}, true
+	case TransferKindInternal:
+		if transfer.Status != TransferStatusSuccess || transfer.From == transfer.To {
+			return nil, false
+		}
+		return map[Market]decimals.Decimal{transfer.From: decimals.Zero.Sub(transfer.Amount), transfer.To: transfer.Amount}, true
+	default:
+		return nil, false
+	}
+}
+
+// Reports of periodic reconciling with drifts, reconciler blocks on slow reader until Close
+func (r *Reconciler) Reports() <-chan ReconcileReport {
+	return r.reports
+}
+
+// errors of periodic reconciling, dropped if nobody reads
+func (r *Reconciler) Errors() <-chan error {
+	return r.errs
+}
+
+// Start reconciling every Interval in background
+func (r *Reconciler) Start() error {
+	r.started.Do(func() {
+		r.wg.Add(1)
+		go r.run()
+	})
+	return nil
+}
+
+func (r *Reconciler) Close() error {
+	r.once.Do(func() { close(r.done) })
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Reconciler) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.option.Interval)
+	defer ticker.Stop()
+	for {
+		report, err := r.Reconcile()
+		if err != nil {
+			select {
+			case r.errs <- err:
+			default:
+			}
+		}
+		if report != nil && !report.OK() {
+			select {
+			case r.reports <- *report:
+			case <-r.done:
+				return
+			}
+		}
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reconciler) tolerance(asset string) decimals.Decimal {
+	if t, ok := r.option.Tolerances[asset]; ok {
+		return t
+	}
+	if r.option.Tolerance.IsPositive() {
+		return r.option.Tolerance
+	}
+	return decimals.Zero
+}
+
+// caller holds r.mu
+func (r *Reconciler) balances(market Market) map[string]Balance {
+	if market == MarketMargin {
+		return r.expected.Margin
+	}
+	return r.expected.Spot
+}
+
+// caller holds r.mu
+func (r *Reconciler) add(market Market, asset string, amount decimals.Decimal) {
+	blcs := r.balances(market)
+	blc := blcs[asset]
+	blc.Free = blc.Free.Add(amount)
+	blcs[asset] = blc
+}
+
+func abs(d decimals.Decimal) decimals.Decimal {
+	if d.LessThan(decimals.Zero) {
+		return decimals.Zero.Sub(d)
+	}
+	return d
+}
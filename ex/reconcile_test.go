@@ -0,0 +1,257 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+type walletPaperEx struct {
+	*PaperEx
+	transfers []AssetTransfer
+	since     time.Time // of the last GetTransfers
+}
+
+func (w *walletPaperEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+// paper capabilities with wallet functions
+func (w *walletPaperEx) Capabilities() Capabilities {
+	c := w.PaperEx.Capabilities()
+	c.Methods = append(c.Methods, ExMethodGetDeposits, ExMethodGetWithdrawals, ExMethodGetTransfers)
+	return SortCapabilities(c)
+}
+
+func (w *walletPaperEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	return "", ErrFunctionNotSupported
+}
+
+func (w *walletPaperEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	return nil, nil
+}
+
+func (w *walletPaperEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	return nil, ErrFunctionNotSupported
+}
+
+func (w *walletPaperEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	w.since = *since
+	return w.transfers, nil
+}
+
+func buyForReconcile(t *testing.T, p *PaperEx) *Order {
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.One, decimals.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func requireDrifts(t *testing.T, r *Reconciler, expected map[string]DriftCause) {
+	t.Helper()
+	report, err := r.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drifts) != len(expected) {
+		t.Fatalf("expected %d drifts, got %v", len(expected), report.Drifts)
+	}
+	for _, d := range report.Drifts {
+		if cause, ok := expected[string(d.Market)+"/"+d.Asset]; !ok || cause != d.Cause {
+			t.Fatalf("unexpected drift %+v", d)
+		}
+	}
+}
+
+func TestReconciler_Fills(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	r, err := NewReconciler(p, nil, ReconcilerOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 BTC at 101, fee 0.002 BTC
+	order := buyForReconcile(t, p)
+	requireDrifts(t, r, map[string]DriftCause{"spot/BTC": DriftCauseMissedFill, "spot/USDT": DriftCauseMissedFill})
+
+	// fee booked twice as much
+	fill := OwnFill{Market: MarketSpot, Pair: testPair, Side: TradeSideBuy, Amount: order.DealAmount, Price: order.AvgPrice, Fee: order.Fee.Add(order.Fee)}
+	if err := r.BookFill(fill); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{"spot/BTC": DriftCauseFee})
+
+	// tolerated
+	r.option.Tolerances = map[string]decimals.Decimal{"BTC": decimals.NewFromFloat64(0.01)}
+	requireDrifts(t, r, map[string]DriftCause{})
+	if blc := r.Expected().AssetInSpot("USDT"); !blc.Free.EqualInt(899) {
+		t.Fatalf("unexpected expected USDT %s", blc.Free.String())
+	}
+}
+
+func TestReconciler_Margin(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	w := &walletPaperEx{PaperEx: p}
+	r, err := NewReconciler(w, nil, ReconcilerOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Transfer("USDT", decimals.NewFromInt(100), MarketMargin); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{"spot/USDT": DriftCauseUnknown, "margin/USDT": DriftCauseUnknown})
+	transfer := AssetTransfer{Id: "t1", Kind: TransferKindInternal, Asset: "USDT", Amount: decimals.NewFromInt(100),
+		Status: TransferStatusSuccess, From: MarketSpot, To: MarketMargin}
+	w.transfers = []AssetTransfer{transfer}
+	requireDrifts(t, r, map[string]DriftCause{"spot/USDT": DriftCauseTransfer, "margin/USDT": DriftCauseTransfer})
+	if err := r.BookTransfer(transfer); err != nil {
+		t.Fatal(err)
+	}
+	// booked only once
+	if err := r.BookTransfer(transfer); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{})
+
+	if err := p.Borrow("USDT", decimals.NewFromInt(50)); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{"margin/USDT": DriftCauseBorrow})
+	if err := r.BookBorrow("USDT", decimals.NewFromInt(50)); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{})
+
+	if err := r.BookInterest("USDT", decimals.One); err != nil {
+		t.Fatal(err)
+	}
+	requireDrifts(t, r, map[string]DriftCause{"margin/USDT": DriftCauseInterest})
+	if err := r.BookRepay("USDT", decimals.NewFromInt(51)); err != nil {
+		t.Fatal(err)
+	}
+	if blc := r.Expected().AssetInMargin("USDT"); !blc.Free.EqualInt(99) || !blc.Borrowed.IsZero() || !blc.Interest.IsZero() {
+		t.Fatalf("unexpected expected margin USDT %+v", blc)
+	}
+}
+
+func TestReconciler_TransferUpdates(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	r, err := NewReconciler(p, nil, ReconcilerOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT := func(expected int) {
+		t.Helper()
+		if blc := r.Expected().AssetInSpot("USDT"); !blc.Free.EqualInt(expected) {
+			t.Fatalf("expected USDT %d, got %s", expected, blc.Free.String())
+		}
+	}
+
+	withdrawal := AssetTransfer{Id: "1", Kind: TransferKindWithdrawal, Asset: "USDT", Amount: decimals.NewFromInt(100),
+		Fee: decimals.One, Status: TransferStatusPending}
+	if err := r.BookTransfer(withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(899)
+	withdrawal.Status = TransferStatusSuccess
+	if err := r.BookTransfer(withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(899)
+	// pending one reversed
+	withdrawal.Id, withdrawal.Status = "2", TransferStatusPending
+	if err := r.BookTransfer(withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(798)
+	withdrawal.Status = TransferStatusCanceled
+	if err := r.BookTransfer(withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(899)
+
+	// same id of another kind is another transfer
+	deposit := AssetTransfer{Id: "1", Kind: TransferKindDeposit, Asset: "USDT", Amount: decimals.NewFromInt(50), Status: TransferStatusPending}
+	if err := r.BookTransfer(deposit); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(899)
+	deposit.Status = TransferStatusSuccess
+	if err := r.BookTransfer(deposit); err != nil {
+		t.Fatal(err)
+	}
+	requireSpotUSDT(949)
+}
+
+func TestReconciler_TransferLookback(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	c := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p.Config().Clock = c
+	w := &walletPaperEx{PaperEx: p}
+	r, err := NewReconciler(w, nil, ReconcilerOption{TransferLookback: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := c.now
+	requireSince := func(expected time.Time) {
+		t.Helper()
+		if _, err := r.unbookedTransfers(); err != nil {
+			t.Fatal(err)
+		}
+		if !w.since.Equal(expected) {
+			t.Fatalf("transfers fetched since %s, %s expected", w.since, expected)
+		}
+	}
+
+	// never before created
+	c.now = created.Add(30 * time.Minute)
+	requireSince(created)
+	c.now = created.Add(10 * time.Hour)
+	requireSince(c.now.Add(-time.Hour))
+
+	// kept before the pending one
+	pendingTime := created.Add(5 * time.Hour)
+	w.transfers = []AssetTransfer{{Id: "t1", Kind: TransferKindInternal, Asset: "USDT", Amount: decimals.One,
+		Status: TransferStatusPending, Time: pendingTime, From: MarketSpot, To: MarketMargin}}
+	requireSince(c.now.Add(-time.Hour))
+	requireSince(pendingTime)
+	w.transfers[0].Status = TransferStatusSuccess
+	requireSince(pendingTime)
+	requireSince(c.now.Add(-time.Hour))
+
+	// bounded even if pending for long
+	w.transfers[0].Status = TransferStatusPending
+	requireSince(c.now.Add(-time.Hour))
+	c.now = created.Add(100 * 24 * time.Hour)
+	requireSince(c.now.Add(-90 * 24 * time.Hour))
+}
+
+func TestReconciler_Start(t *testing.T) {
+	p, _ := newTestPaperEx(t)
+	r, err := NewReconciler(p, nil, ReconcilerOption{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyForReconcile(t, p)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	select {
+	case report := <-r.Reports():
+		if len(report.Drifts) != 2 {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	case err := <-r.Errors():
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("no report")
+	}
+}
@@ -0,0 +1,355 @@
+package ex
+
+import (
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	recordConfigMethod = "Config"
+)
+
+type (
+	// one Ex call in a record file, file is JSON lines and the first line is always the Config call
+	RecordedCall struct {
+		Seq    int
+		Time   time.Time // exchange time when call returned
+		Method string
+		Args   json.RawMessage `json:",omitempty"` // JSON array of arguments
+		Result json.RawMessage `json:",omitempty"`
+		Error  *RecordedError  `json:",omitempty"`
+	}
+
+	RecordedError struct {
+		Kind ErrorKind
+		Msg  string
+	}
+
+	// RecordEx wraps an Ex and writes every call with its arguments, results, errors and timestamp into a file,
+	// ReplayEx serves the file back as a deterministic Ex.
+	RecordEx struct {
+		inner Ex
+		mu    sync.Mutex
+		file  *os.File
+		enc   *json.Encoder
+		seq   int
+		err   error // first write error, returned by Close
+	}
+)
+
+// path: record file, it is truncated if exists
+func NewRecordEx(inner Ex, path string) (*RecordEx, error) {
+	if inner == nil {
+		return nil, errorz.Errorf("nil exchange to record")
+	}
+	if inner.Config() == nil {
+		return nil, errorz.Errorf("nil config of exchange to record")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &RecordEx{inner: inner, file: file, enc: json.NewEncoder(file)}
+
+	// clock can't be saved, replayed config has its own clock
+	config := inner.Config().Clone()
+	config.Clock = nil
+	r.record(recordConfigMethod, nil, config, nil)
+	if r.err != nil {
+		file.Close()
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// flush and close record file
+func (r *RecordEx) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *RecordEx) record(method string, args []interface{}, result interface{}, err error) {
+	call := RecordedCall{Time: nowOf(r.inner.Config()), Method: method}
+	if err != nil {
+		call.Error = &RecordedError{Kind: ErrorKindOf(err), Msg: err.Error()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if args != nil {
+		if call.Args, r.err = json.Marshal(args); r.err != nil {
+			return
+		}
+	}
+	if err == nil && result != nil {
+		if call.Result, r.err = json.Marshal(result); r.err != nil {
+			return
+		}
+	}
+	call.Seq = r.seq
+	r.seq++
+	r.err = r.enc.Encode(call)
+}
+
+func (r *RecordEx) Config() *ExConfig {
+	return r.inner.Config()
+}
+
+func (r *RecordEx) Capabilities() Capabilities {
+	return r.inner.Capabilities()
+}
+
+func (r *RecordEx) GetMarketInfo() (*MarketInfo, error) {
+	mi, err := r.inner.GetMarketInfo()
+	r.record("GetMarketInfo", []interface{}{}, mi, err)
+	return mi, err
+}
+
+func (r *RecordEx) GetAccount() (*Account, error) {
+	acc, err := r.inner.GetAccount()
+	r.record("GetAccount", []interface{}{}, acc, err)
+	return acc, err
+}
+
+func (r *RecordEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	depth, err := r.inner.GetDepth(market, target, limit)
+	r.record("GetDepth", []interface{}{market, target, limit}, depth, err)
+	return depth, err
+}
+
+func (r *RecordEx) GetTicks() (map[PairExt]Tick, error) {
+	ticks, err := r.inner.GetTicks()
+	r.record("GetTicks", []interface{}{}, ticks, err)
+	return ticks, err
+}
+
+func (r *RecordEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	k, err := r.inner.GetKline(market, target, period, since)
+	r.record("GetKline", []interface{}{market, target, period, since}, k, err)
+	return k, err
+}
+
+func (r *RecordEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	fills, err := r.inner.GetFills(market, target, fromId, limit)
+	r.record("GetFills", []interface{}{market, target, fromId, limit}, fills, err)
+	return fills, err
+}
+
+func (r *RecordEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	amount, err := r.inner.GetBorrowable(asset)
+	r.record("GetBorrowable", []interface{}{asset}, amount, err)
+	return amount, err
+}
+
+func (r *RecordEx) Borrow(asset string, amount decimals.Decimal) error {
+	err := r.inner.Borrow(asset, amount)
+	r.record("Borrow", []interface{}{asset, amount}, nil, err)
+	return err
+}
+
+func (r *RecordEx) Repay(asset string, amount decimals.Decimal) error {
+	err := r.inner.Repay(asset, amount)
+	r.record("Repay", []interface{}{asset, amount}, nil, err)
+	return err
+}
+
+func (r *RecordEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	err := r.inner.Transfer(asset, amount, target)
+	r.record("Transfer", []interface{}{asset, amount, target}, nil, err)
+	return err
+}
+
+func (r *RecordEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	id, err := r.inner.Trade(market, target, t, amount, price)
+	r.record("Trade", []interface{}{market, target, t, amount, price}, id, err)
+	return id, err
+}
+
+func (r *RecordEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	id, err := TradeWithOptions(r.inner, req)
+	r.record("TradeWithOptions", []interface{}{req}, id, err)
+	return id, err
+}
+
+func (r *RecordEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	orders, err := r.inner.GetAllOrders(market, target)
+	r.record("GetAllOrders", []interface{}{market, target}, orders, err)
+	return orders, err
+}
+
+func (r *RecordEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	orders, err := r.inner.GetOpenOrders(market, target)
+	r.record("GetOpenOrders", []interface{}{market, target}, orders, err)
+	return orders, err
+}
+
+func (r *RecordEx) GetOrder(id OrderId) (*Order, error) {
+	order, err := r.inner.GetOrder(id)
+	r.record("GetOrder", []interface{}{id}, order, err)
+	return order, err
+}
+
+func (r *RecordEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	ids, err := CancelAllOrders(r.inner, market, target, 0)
+	r.record("CancelAllOrders", []interface{}{market, target}, ids, err)
+	return ids, err
+}
+
+func (r *RecordEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	orders, err := GetAllOpenOrders(r.inner, market, 0)
+	r.record("GetAllOpenOrders", []interface{}{market}, orders, err)
+	return orders, err
+}
+
+func (r *RecordEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	order, err := GetOrderByClientId(r.inner, market, target, clientId)
+	r.record("GetOrderByClientId", []interface{}{market, target, clientId}, order, err)
+	return order, err
+}
+
+func (r *RecordEx) CancelOrder(id OrderId) error {
+	err := r.inner.CancelOrder(id)
+	r.record("CancelOrder", []interface{}{id}, nil, err)
+	return err
+}
+
+func (r *RecordEx) GetPositions(market Market) ([]Position, error) {
+	var positions []Position
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		positions, err = de.GetPositions(market)
+	}
+	r.record("GetPositions", []interface{}{market}, positions, err)
+	return positions, err
+}
+
+func (r *RecordEx) SetLeverage(market Market, target Pair, leverage int) error {
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		err = de.SetLeverage(market, target, leverage)
+	}
+	r.record("SetLeverage", []interface{}{market, target, leverage}, nil, err)
+	return err
+}
+
+func (r *RecordEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		err = de.SetMarginMode(market, target, mode)
+	}
+	r.record("SetMarginMode", []interface{}{market, target, mode}, nil, err)
+	return err
+}
+
+func (r *RecordEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	var rate *FundingRate
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		rate, err = de.GetFundingRate(target)
+	}
+	r.record("GetFundingRate", []interface{}{target}, rate, err)
+	return rate, err
+}
+
+func (r *RecordEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	var rates []FundingRate
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		rates, err = de.GetFundingHistory(target, since, limit)
+	}
+	r.record("GetFundingHistory", []interface{}{target, since, limit}, rates, err)
+	return rates, err
+}
+
+func (r *RecordEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	var price *MarkPrice
+	de, err := DerivativesOf(r.inner)
+	if err == nil {
+		price, err = de.GetMarkPrice(market, target)
+	}
+	r.record("GetMarkPrice", []interface{}{market, target}, price, err)
+	return price, err
+}
+
+func (r *RecordEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	var address *DepositAddress
+	we, err := WalletOf(r.inner)
+	if err == nil {
+		address, err = we.GetDepositAddress(asset, network)
+	}
+	r.record("GetDepositAddress", []interface{}{asset, network}, address, err)
+	return address, err
+}
+
+func (r *RecordEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	var id string
+	we, err := WalletOf(r.inner)
+	if err == nil {
+		id, err = we.Withdraw(amount, to)
+	}
+	r.record("Withdraw", []interface{}{amount, to}, id, err)
+	return id, err
+}
+
+func (r *RecordEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var deposits []AssetTransfer
+	we, err := WalletOf(r.inner)
+	if err == nil {
+		deposits, err = we.GetDeposits(asset, since)
+	}
+	r.record("GetDeposits", []interface{}{asset, since}, deposits, err)
+	return deposits, err
+}
+
+func (r *RecordEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var withdrawals []AssetTransfer
+	we, err := WalletOf(r.inner)
+	if err == nil {
+		withdrawals, err = we.GetWithdrawals(asset, since)
+	}
+	r.record("GetWithdrawals", []interface{}{asset, since}, withdrawals, err)
+	return withdrawals, err
+}
+
+func (r *RecordEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var transfers []AssetTransfer
+	we, err := WalletOf(r.inner)
+	if err == nil {
+		transfers, err = we.GetTransfers(asset, since)
+	}
+	r.record("GetTransfers", []interface{}{asset, since}, transfers, err)
+	return transfers, err
+}
+
+func (r *RecordEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	var fills []Fill
+	fg, err := FillTimeGetterOf(r.inner)
+	if err == nil {
+		fills, err = fg.GetFillsByTime(market, target, begin, duration)
+	}
+	r.record("GetFillsByTime", []interface{}{market, target, begin, duration}, fills, err)
+	return fills, err
+}
+
+func (r *RecordEx) GetServerTime() (time.Time, error) {
+	var t time.Time
+	st, err := ServerTimerOf(r.inner)
+	if err == nil {
+		t, err = st.GetServerTime()
+	}
+	r.record("GetServerTime", []interface{}{}, t, err)
+	return t, err
+}
@@ -0,0 +1,78 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	p, _ := newTestPaperEx(t)
+	rec, err := NewRecordEx(p, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := rec.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(100), decimals.NewFromInt(100)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("ErrInsufficientBalance expected, got %v", err)
+	}
+	order, err := rec.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth, err := rec.GetDepth(MarketSpot, testPair, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := NewReplayEx(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Config().Name != p.Config().Name || !rep.Config().TakerFee.Equal(p.Config().TakerFee) {
+		t.Fatal("config not replayed")
+	}
+	if c := rep.Capabilities(); len(c.Methods) != 3 || c.HasMethod(ExMethod(recordConfigMethod)) {
+		t.Fatalf("recorded Trade, GetOrder and GetDepth expected, got %v", c.Methods)
+	}
+	rid, err := rep.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99))
+	if err != nil || *rid != *id {
+		t.Fatalf("order id %v expected, got %v, err %v", *id, rid, err)
+	}
+	// arguments must match the recorded ones
+	if _, err := rep.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(99), decimals.NewFromInt(100)); err == nil {
+		t.Fatal("mismatch error expected")
+	}
+	if _, err := rep.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(100), decimals.NewFromInt(100)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("replayed ErrInsufficientBalance expected, got %v", err)
+	}
+	rorder, err := rep.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rorder.Status != order.Status || !rorder.Price.Equal(order.Price) || !rorder.Time.Equal(order.Time) {
+		t.Fatalf("order %s expected, got %s", order.String(), rorder.String())
+	}
+	rdepth, err := rep.GetDepth(MarketSpot, testPair, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rdepth.DepthRawData.Equal(&depth.DepthRawData) {
+		t.Fatal("depth not replayed")
+	}
+	if rep.Remaining() != 0 {
+		t.Fatalf("all calls should be served, %d left", rep.Remaining())
+	}
+	if _, err := rep.GetTicks(); err == nil {
+		t.Fatal("exhausted error expected")
+	}
+}
@@ -0,0 +1,69 @@
+package ex
+
+import (
+	"fmt"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+	"sync"
+)
+
+type (
+	// ExFactory creates an exchange adapter from a config prepared by NewEx,
+	// config is a copy of the registered default one with Name, Email and Clock filled.
+	ExFactory func(config ExConfig, apiKey, apiSecret, proxy string) (Ex, error)
+
+	exRegistration struct {
+		factory ExFactory
+		config  ExConfig
+	}
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[Platform]exRegistration{}
+)
+
+// RegisterEx makes an exchange adapter available by NewEx, it is supposed to be called in init() of adapter package.
+// It panics if factory is nil or platform registered twice.
+func RegisterEx(name Platform, defaultConfig ExConfig, factory ExFactory) {
+	if name == PlatformUnknown {
+		panic("ex: register exchange with unknown platform")
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("ex: register exchange(%s) with nil factory", name.String()))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("ex: register exchange(%s) twice", name.String()))
+	}
+	defaultConfig.Name = name
+	registry[name] = exRegistration{factory: factory, config: defaultConfig.Clone()}
+}
+
+// all platforms registered by RegisterEx, sorted by name
+func ListRegisteredEx() []Platform {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var r []Platform
+	for name := range registry {
+		r = append(r, name)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].String() < r[j].String()
+	})
+	return r
+}
+
+// copy of default config registered by adapter
+func DefaultExConfig(name Platform) (*ExConfig, error) {
+	registryMu.RLock()
+	reg, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, errorz.Errorf("unsupported exchange(%s)", name.String())
+	}
+	config := reg.config.Clone()
+	return &config, nil
+}
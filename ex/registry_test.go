@@ -0,0 +1,50 @@
+package ex
+
+import (
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func TestRegisterEx(t *testing.T) {
+	config := newFakeEx().config
+	RegisterEx(Kraken, config, func(config ExConfig, apiKey, apiSecret, proxy string) (Ex, error) {
+		f := newFakeEx()
+		f.config = config
+		return f, nil
+	})
+	t.Cleanup(func() { unregisterEx(Kraken) })
+
+	found := false
+	for _, v := range ListRegisteredEx() {
+		if v == Kraken {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("%s not listed", Kraken)
+	}
+
+	e, err := NewEx(Kraken, "key", "secret", "", nil, "buffett@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Config().Name != Kraken || e.Config().Email != "buffett@gmail.com" {
+		t.Fatalf("unexpected config %+v", e.Config())
+	}
+	// adapters must not modify registered default config
+	e.Config().Periods[Period1Hour] = "1h"
+	if def, _ := DefaultExConfig(Kraken); len(def.Periods) != 1 {
+		t.Fatalf("default config modified")
+	}
+
+	if _, err := NewEx(Gemini, "", "", "", nil, ""); err == nil {
+		t.Fatal("unregistered exchange should fail")
+	}
+}
+
+// undo RegisterEx so that tests can run more than once
+func unregisterEx(name Platform) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
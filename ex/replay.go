@@ -0,0 +1,368 @@
+package ex
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	"github.com/shawnwyckoff/commpkg/sys/clock"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// ReplayEx serves calls recorded by RecordEx in the same order, without touching network.
+	// Calls must be made in recorded order with the same arguments, or an error is returned and nothing is consumed.
+	// Now of Config().Clock returns the recorded time of the last served call, other methods of it are not supported.
+	ReplayEx struct {
+		config *ExConfig
+		clock  *replayClock
+		mu     sync.Mutex
+		calls  []RecordedCall
+		pos    int
+	}
+
+	replayClock struct {
+		clock.Clock
+		mu  sync.Mutex
+		now time.Time
+	}
+)
+
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *replayClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// path: file written by RecordEx
+func NewReplayEx(path string) (*ReplayEx, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var calls []RecordedCall
+	dec := json.NewDecoder(file)
+	for {
+		var call RecordedCall
+		if err := dec.Decode(&call); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errorz.Errorf("invalid record file(%s): %s", path, err.Error())
+		}
+		calls = append(calls, call)
+	}
+	if len(calls) == 0 || calls[0].Method != recordConfigMethod {
+		return nil, errorz.Errorf("record file(%s) doesn't begin with config", path)
+	}
+
+	config := &ExConfig{}
+	if err := json.Unmarshal(calls[0].Result, config); err != nil {
+		return nil, errorz.Errorf("invalid config in record file(%s): %s", path, err.Error())
+	}
+	rc := &replayClock{now: calls[0].Time}
+	config.Clock = rc
+	return &ReplayEx{config: config, clock: rc, calls: calls, pos: 1}, nil
+}
+
+// calls not served yet, a finished replay should have none
+func (r *ReplayEx) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls) - r.pos
+}
+
+// serve next call, result must be a pointer to unmarshal recorded result into
+func (r *ReplayEx) replay(method string, result interface{}, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	buf, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if r.pos >= len(r.calls) {
+		r.mu.Unlock()
+		return errorz.Errorf("replay exhausted, unexpected call %s%s", method, string(buf))
+	}
+	call := r.calls[r.pos]
+	if call.Method != method || !bytes.Equal(call.Args, buf) {
+		r.mu.Unlock()
+		return errorz.Errorf("replay mismatch at call %d, recorded %s%s, got %s%s", call.Seq, call.Method, string(call.Args), method, string(buf))
+	}
+	r.pos++
+	r.mu.Unlock()
+	r.clock.set(call.Time)
+
+	if call.Error != nil {
+		return &ExError{Kind: call.Error.Kind, Msg: call.Error.Msg}
+	}
+	if result != nil && len(call.Result) > 0 {
+		return json.Unmarshal(call.Result, result)
+	}
+	return nil
+}
+
+func (r *ReplayEx) Config() *ExConfig {
+	return r.config
+}
+
+// methods are the recorded ones, others are as DefaultCapabilities of recorded config
+func (r *ReplayEx) Capabilities() Capabilities {
+	c := DefaultCapabilities(r)
+	recorded := map[ExMethod]bool{}
+	for _, call := range r.calls[1:] { // the first one is config
+		recorded[ExMethod(call.Method)] = true
+	}
+	c.Methods = nil
+	for method := range recorded {
+		c.Methods = append(c.Methods, method)
+	}
+	return SortCapabilities(c)
+}
+
+func (r *ReplayEx) GetMarketInfo() (*MarketInfo, error) {
+	var mi *MarketInfo
+	if err := r.replay("GetMarketInfo", &mi); err != nil {
+		return nil, err
+	}
+	return mi, nil
+}
+
+func (r *ReplayEx) GetAccount() (*Account, error) {
+	var acc *Account
+	if err := r.replay("GetAccount", &acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (r *ReplayEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	var depth *Depth
+	if err := r.replay("GetDepth", &depth, market, target, limit); err != nil {
+		return nil, err
+	}
+	return depth, nil
+}
+
+func (r *ReplayEx) GetTicks() (map[PairExt]Tick, error) {
+	var ticks map[PairExt]Tick
+	if err := r.replay("GetTicks", &ticks); err != nil {
+		return nil, err
+	}
+	return ticks, nil
+}
+
+func (r *ReplayEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	var k *Kline
+	if err := r.replay("GetKline", &k, market, target, period, since); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (r *ReplayEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	var fills []Fill
+	if err := r.replay("GetFills", &fills, market, target, fromId, limit); err != nil {
+		return nil, err
+	}
+	return fills, nil
+}
+
+func (r *ReplayEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	amount := decimals.Zero
+	if err := r.replay("GetBorrowable", &amount, asset); err != nil {
+		return decimals.Zero, err
+	}
+	return amount, nil
+}
+
+func (r *ReplayEx) Borrow(asset string, amount decimals.Decimal) error {
+	return r.replay("Borrow", nil, asset, amount)
+}
+
+func (r *ReplayEx) Repay(asset string, amount decimals.Decimal) error {
+	return r.replay("Repay", nil, asset, amount)
+}
+
+func (r *ReplayEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	return r.replay("Transfer", nil, asset, amount, target)
+}
+
+func (r *ReplayEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	var id *OrderId
+	if err := r.replay("Trade", &id, market, target, t, amount, price); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (r *ReplayEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	var id *OrderId
+	if err := r.replay("TradeWithOptions", &id, req); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (r *ReplayEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	var orders []Order
+	if err := r.replay("GetAllOrders", &orders, market, target); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *ReplayEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	var orders []Order
+	if err := r.replay("GetOpenOrders", &orders, market, target); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *ReplayEx) GetOrder(id OrderId) (*Order, error) {
+	var order *Order
+	if err := r.replay("GetOrder", &order, id); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (r *ReplayEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	var ids []OrderId
+	if err := r.replay("CancelAllOrders", &ids, market, target); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *ReplayEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	var orders []Order
+	if err := r.replay("GetAllOpenOrders", &orders, market); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *ReplayEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	var order *Order
+	if err := r.replay("GetOrderByClientId", &order, market, target, clientId); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (r *ReplayEx) CancelOrder(id OrderId) error {
+	return r.replay("CancelOrder", nil, id)
+}
+
+func (r *ReplayEx) GetPositions(market Market) ([]Position, error) {
+	var positions []Position
+	if err := r.replay("GetPositions", &positions, market); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+func (r *ReplayEx) SetLeverage(market Market, target Pair, leverage int) error {
+	return r.replay("SetLeverage", nil, market, target, leverage)
+}
+
+func (r *ReplayEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	return r.replay("SetMarginMode", nil, market, target, mode)
+}
+
+func (r *ReplayEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	var rate *FundingRate
+	if err := r.replay("GetFundingRate", &rate, target); err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
+
+func (r *ReplayEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	var rates []FundingRate
+	if err := r.replay("GetFundingHistory", &rates, target, since, limit); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func (r *ReplayEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	var price *MarkPrice
+	if err := r.replay("GetMarkPrice", &price, market, target); err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+func (r *ReplayEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	var address *DepositAddress
+	if err := r.replay("GetDepositAddress", &address, asset, network); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+func (r *ReplayEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	var id string
+	if err := r.replay("Withdraw", &id, amount, to); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *ReplayEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var deposits []AssetTransfer
+	if err := r.replay("GetDeposits", &deposits, asset, since); err != nil {
+		return nil, err
+	}
+	return deposits, nil
+}
+
+func (r *ReplayEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var withdrawals []AssetTransfer
+	if err := r.replay("GetWithdrawals", &withdrawals, asset, since); err != nil {
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+func (r *ReplayEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	var transfers []AssetTransfer
+	if err := r.replay("GetTransfers", &transfers, asset, since); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+func (r *ReplayEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	var fills []Fill
+	if err := r.replay("GetFillsByTime", &fills, market, target, begin, duration); err != nil {
+		return nil, err
+	}
+	return fills, nil
+}
+
+func (r *ReplayEx) GetServerTime() (time.Time, error) {
+	var t time.Time
+	if err := r.replay("GetServerTime", &t); err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
@@ -0,0 +1,493 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryMaxRetries       = 3
+	defaultRetryBaseBackoff      = 500 * time.Millisecond
+	defaultRetryMaxBackoff       = 30 * time.Second
+	defaultRetryBreakerThreshold = 5
+	defaultRetryBreakerCooldown  = 30 * time.Second
+
+	NoRetry = -1 // MaxRetries of RetryEx which never retries
+)
+
+type (
+	RetryOption struct {
+		MaxRetries       int           // retries after first failure, 3 if zero, NoRetry or any negative disables retries
+		BaseBackoff      time.Duration // wait before first retry, doubled each retry
+		MaxBackoff       time.Duration
+		BreakerThreshold int           // consecutive transient failures to open circuit
+		BreakerCooldown  time.Duration // open circuit lets one request through after cooldown
+		// Trade with network transient error may have been placed already, it is retried only if this is set.
+		// Rate limited Trade is always retried because exchange rejected it.
+		// TradeWithOptions with client order id is always retried, it is looked up by client id before resubmission.
+		RetryTradeOnNetworkError bool
+	}
+
+	// RetryEx wraps an Ex, retries transient errors with exponential backoff,
+	// and fails fast with ErrCircuitOpen after too many consecutive transient failures.
+	RetryEx struct {
+		inner    Ex
+		option   RetryOption
+		mu       sync.Mutex
+		failures int
+		openAt   time.Time // zero if circuit closed
+		now      func() time.Time
+		sleep    func(time.Duration)
+	}
+)
+
+func NewRetryEx(inner Ex, option RetryOption) (*RetryEx, error) {
+	if inner == nil {
+		return nil, errorz.Errorf("nil exchange to retry")
+	}
+	if option.MaxRetries < 0 {
+		option.MaxRetries = 0
+	} else if option.MaxRetries == 0 {
+		option.MaxRetries = defaultRetryMaxRetries
+	}
+	if option.BaseBackoff <= 0 {
+		option.BaseBackoff = defaultRetryBaseBackoff
+	}
+	if option.MaxBackoff <= 0 {
+		option.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if option.BreakerThreshold <= 0 {
+		option.BreakerThreshold = defaultRetryBreakerThreshold
+	}
+	if option.BreakerCooldown <= 0 {
+		option.BreakerCooldown = defaultRetryBreakerCooldown
+	}
+	return &RetryEx{inner: inner, option: option, now: time.Now, sleep: time.Sleep}, nil
+}
+
+// whether circuit is open now
+func (r *RetryEx) CircuitOpen() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.openAt.IsZero() && r.now().Sub(r.openAt) < r.option.BreakerCooldown
+}
+
+// check circuit before each attempt, half open after cooldown lets one attempt through
+func (r *RetryEx) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.openAt.IsZero() {
+		return nil
+	}
+	if r.now().Sub(r.openAt) < r.option.BreakerCooldown {
+		return ErrCircuitOpen
+	}
+	r.openAt = r.now().Add(r.option.BreakerCooldown) // reopen at once if this attempt failed
+	return nil
+}
+
+func (r *RetryEx) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !IsTransientError(err) {
+		r.failures = 0
+		r.openAt = time.Time{}
+		return
+	}
+	r.failures++
+	if r.failures >= r.option.BreakerThreshold {
+		r.openAt = r.now()
+	}
+}
+
+func (r *RetryEx) do(retryable func(error) bool, call func() error) error {
+	backoff := r.option.BaseBackoff
+	for i := 0; ; i++ {
+		if err := r.allow(); err != nil {
+			return err
+		}
+		err := call()
+		r.record(err)
+		if err == nil || !retryable(err) || i >= r.option.MaxRetries {
+			return err
+		}
+		r.sleep(backoff)
+		backoff *= 2
+		if backoff > r.option.MaxBackoff {
+			backoff = r.option.MaxBackoff
+		}
+	}
+}
+
+func (r *RetryEx) Config() *ExConfig {
+	return r.inner.Config()
+}
+
+func (r *RetryEx) Capabilities() Capabilities {
+	return r.inner.Capabilities()
+}
+
+func (r *RetryEx) GetMarketInfo() (*MarketInfo, error) {
+	var res *MarketInfo
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetMarketInfo()
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetAccount() (*Account, error) {
+	var res *Account
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetAccount()
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	var res *Depth
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetDepth(market, target, limit)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetTicks() (map[PairExt]Tick, error) {
+	var res map[PairExt]Tick
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetTicks()
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	var res *Kline
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetKline(market, target, period, since)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	var res []Fill
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetFills(market, target, fromId, limit)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	var res decimals.Decimal
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetBorrowable(asset)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) Borrow(asset string, amount decimals.Decimal) error {
+	return r.do(r.retryableWrite, func() error {
+		return r.inner.Borrow(asset, amount)
+	})
+}
+
+func (r *RetryEx) Repay(asset string, amount decimals.Decimal) error {
+	return r.do(r.retryableWrite, func() error {
+		return r.inner.Repay(asset, amount)
+	})
+}
+
+func (r *RetryEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	return r.do(r.retryableWrite, func() error {
+		return r.inner.Transfer(asset, amount, target)
+	})
+}
+
+func (r *RetryEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	var res *OrderId
+	err := r.do(r.retryableWrite, func() (err error) {
+		res, err = r.inner.Trade(market, target, t, amount, price)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	if req.ClientId == "" {
+		var res *OrderId
+		err := r.do(r.retryableWrite, func() (err error) {
+			res, err = TradeWithOptions(r.inner, req)
+			return err
+		})
+		return res, err
+	}
+
+	var res *OrderId
+	submitted := false
+	err := r.do(IsTransientError, func() error {
+		// previous attempt may have been placed before connection broke
+		if submitted {
+			order, err := GetOrderByClientId(r.inner, req.Market, req.Pair, req.ClientId)
+			if err == nil {
+				res = &order.Id
+				return nil
+			}
+			if ErrorKindOf(err) != ErrorKindOrderNotFound {
+				return err
+			}
+		}
+		submitted = true
+		var err error
+		res, err = TradeWithOptions(r.inner, req)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	var res []Order
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetAllOrders(market, target)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	var res []Order
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetOpenOrders(market, target)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetOrder(id OrderId) (*Order, error) {
+	var res *Order
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = r.inner.GetOrder(id)
+		return err
+	})
+	return res, err
+}
+
+// each request is retried if inner exchange cancels orders one by one
+func (r *RetryEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	ac, ok := r.inner.(AllOrdersCanceler)
+	if !ok {
+		return cancelAllOrders(r, market, target, 0)
+	}
+	var res []OrderId
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = ac.CancelAllOrders(market, target)
+		return err
+	})
+	return res, err
+}
+
+// each request is retried if inner exchange gets open orders pair by pair
+func (r *RetryEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	g, ok := r.inner.(OpenOrdersGetter)
+	if !ok {
+		return getAllOpenOrders(r, market, 0)
+	}
+	var res []Order
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = g.GetAllOpenOrders(market)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	var res *Order
+	err := r.do(IsTransientError, func() (err error) {
+		res, err = GetOrderByClientId(r.inner, market, target, clientId)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) CancelOrder(id OrderId) error {
+	return r.do(IsTransientError, func() error {
+		return r.inner.CancelOrder(id)
+	})
+}
+
+// requests which move money are not idempotent, network errors are unsafe to retry
+func (r *RetryEx) retryableWrite(err error) bool {
+	kind := ErrorKindOf(err)
+	return kind == ErrorKindRateLimited || (kind == ErrorKindNetworkTransient && r.option.RetryTradeOnNetworkError)
+}
+
+func (r *RetryEx) GetPositions(market Market) ([]Position, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []Position
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = de.GetPositions(market)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) SetLeverage(market Market, target Pair, leverage int) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	return r.do(IsTransientError, func() error {
+		return de.SetLeverage(market, target, leverage)
+	})
+}
+
+func (r *RetryEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	return r.do(IsTransientError, func() error {
+		return de.SetMarginMode(market, target, mode)
+	})
+}
+
+func (r *RetryEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res *FundingRate
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = de.GetFundingRate(target)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []FundingRate
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = de.GetFundingHistory(target, since, limit)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res *MarkPrice
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = de.GetMarkPrice(market, target)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res *DepositAddress
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = we.GetDepositAddress(asset, network)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return "", err
+	}
+	var res string
+	err = r.do(r.retryableWrite, func() (err error) {
+		res, err = we.Withdraw(amount, to)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []AssetTransfer
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = we.GetDeposits(asset, since)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []AssetTransfer
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = we.GetWithdrawals(asset, since)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []AssetTransfer
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = we.GetTransfers(asset, since)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	fg, err := FillTimeGetterOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	var res []Fill
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = fg.GetFillsByTime(market, target, begin, duration)
+		return err
+	})
+	return res, err
+}
+
+func (r *RetryEx) GetServerTime() (time.Time, error) {
+	st, err := ServerTimerOf(r.inner)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var res time.Time
+	err = r.do(IsTransientError, func() (err error) {
+		res, err = st.GetServerTime()
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,98 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+// fails first n calls of GetTicks and Trade with err
+type flakyEx struct {
+	*fakeEx
+	err   error
+	n     int
+	calls int
+}
+
+func (f *flakyEx) GetTicks() (map[PairExt]Tick, error) {
+	f.calls++
+	if f.calls <= f.n {
+		return nil, f.err
+	}
+	return f.fakeEx.GetTicks()
+}
+
+func (f *flakyEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	f.calls++
+	if f.calls <= f.n {
+		return nil, f.err
+	}
+	id := NewOrderId(market, target, "1")
+	return &id, nil
+}
+
+func newTestRetryEx(t *testing.T, inner Ex, option RetryOption) (*RetryEx, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := NewRetryEx(inner, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { now = now.Add(d) }
+	return r, &now
+}
+
+func TestRetryEx_RetryTransient(t *testing.T) {
+	inner := &flakyEx{fakeEx: newFakeEx(), err: ErrNetworkTransient, n: 2}
+	r, _ := newTestRetryEx(t, inner, RetryOption{})
+	if _, err := r.GetTicks(); err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("3 calls expected, got %d", inner.calls)
+	}
+
+	// business errors are never retried
+	inner = &flakyEx{fakeEx: newFakeEx(), err: ErrInsufficientBalance, n: 1}
+	r, _ = newTestRetryEx(t, inner, RetryOption{})
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.One, decimals.Zero); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// trade may be placed when network failed
+	inner = &flakyEx{fakeEx: newFakeEx(), err: ErrNetworkTransient, n: 1}
+	r, _ = newTestRetryEx(t, inner, RetryOption{})
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.One, decimals.Zero); err == nil || inner.calls != 1 {
+		t.Fatalf("trade should not be retried, calls %d", inner.calls)
+	}
+	// retries disabled
+	inner = &flakyEx{fakeEx: newFakeEx(), err: ErrNetworkTransient, n: 1}
+	r, _ = newTestRetryEx(t, inner, RetryOption{MaxRetries: NoRetry})
+	if _, err := r.GetTicks(); err == nil || inner.calls != 1 {
+		t.Fatalf("no retry expected, calls %d", inner.calls)
+	}
+}
+
+func TestRetryEx_CircuitBreaker(t *testing.T) {
+	inner := &flakyEx{fakeEx: newFakeEx(), err: ErrNetworkTransient, n: 100}
+	r, now := newTestRetryEx(t, inner, RetryOption{MaxRetries: 1, BreakerThreshold: 3, BreakerCooldown: time.Minute})
+	r.GetTicks()
+	r.GetTicks()
+	if !r.CircuitOpen() {
+		t.Fatal("circuit should be open")
+	}
+	calls := inner.calls
+	if _, err := r.GetTicks(); !errors.Is(err, ErrCircuitOpen) || inner.calls != calls {
+		t.Fatalf("circuit open error expected, got %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	inner.n = 0
+	if _, err := r.GetTicks(); err != nil {
+		t.Fatal(err)
+	}
+	if r.CircuitOpen() {
+		t.Fatal("circuit should be closed")
+	}
+}
@@ -0,0 +1,509 @@
+package ex
+
+import (
+	"fmt"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RiskReasonKilled     RiskReason = "killed"
+	RiskReasonMarket     RiskReason = "market_not_allowed"
+	RiskReasonNotional   RiskReason = "max_notional"
+	RiskReasonPosition   RiskReason = "max_position"
+	RiskReasonOpenOrders RiskReason = "max_open_orders"
+	RiskReasonDailyLoss  RiskReason = "max_daily_loss"
+)
+
+type (
+	RiskReason string
+
+	// RiskLimits of RiskEx, zero value of each limit means no limit
+	RiskLimits struct {
+		Markets          []Market                    // allowed markets
+		MaxOrderNotional decimals.Decimal            // amount * price of an order, in quote asset of pair
+		MaxPosition      map[string]decimals.Decimal // asset -> max total balance in market of order, open orders assumed filled
+		MaxOpenOrders    int                         // per pair of a market
+		MaxDailyLoss     decimals.Decimal            // in USD, drop of account net value since first check of the UTC day
+	}
+
+	// RiskError is returned when RiskEx rejects an order, its ErrorKind is ErrorKindRiskRejected
+	RiskError struct {
+		Reason RiskReason
+		Msg    string
+	}
+
+	// RiskEx wraps an Ex and checks every order against RiskLimits before it is sent.
+	// Orders are checked and sent one by one, so limits hold for concurrent callers.
+	RiskEx struct {
+		inner     Ex
+		limits    RiskLimits
+		tradeMu   sync.Mutex // held while an order is checked and sent
+		mu        sync.Mutex
+		killed    bool
+		traded    map[PairExt]bool // pairs with market which orders were sent to, canceled by Kill
+		day       time.Time
+		dayEquity decimals.Decimal
+	}
+)
+
+func (rr RiskReason) String() string {
+	return string(rr)
+}
+
+func newRiskError(reason RiskReason, format string, args ...interface{}) error {
+	return &RiskError{Reason: reason, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("risk rejected(%s): %s", e.Reason, e.Msg)
+}
+
+// errors.Is(err, ErrRiskRejected) and ErrorKindOf work through it
+func (e *RiskError) Unwrap() error {
+	return &ExError{Kind: ErrorKindRiskRejected, Msg: e.Error()}
+}
+
+func NewRiskEx(inner Ex, limits RiskLimits) (*RiskEx, error) {
+	if inner == nil || inner.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to check risk")
+	}
+	if limits.MaxOpenOrders < 0 {
+		return nil, errorz.Errorf("invalid max open orders(%d)", limits.MaxOpenOrders)
+	}
+	return &RiskEx{inner: inner, limits: limits, traded: map[PairExt]bool{}}, nil
+}
+
+// Kill blocks new orders, waits for the order being sent, and cancels open orders of pairs traded through r first.
+// Then other open orders of enabled markets are found by GetAllOpenOrders and canceled,
+// including ones placed before r existed or by other paths. Ids of canceled orders are returned with the first error.
+func (r *RiskEx) Kill() ([]OrderId, error) {
+	r.mu.Lock()
+	r.killed = true
+	var traded []PairExt
+	for target := range r.traded {
+		traded = append(traded, target)
+	}
+	r.mu.Unlock()
+	sortPairExts(traded)
+
+	r.tradeMu.Lock()
+	r.tradeMu.Unlock()
+
+	var canceled []OrderId
+	var firstErr error
+	done := map[PairExt]bool{}
+	cancel := func(targets []PairExt) {
+		for _, target := range targets {
+			done[target] = true
+			ids, err := CancelAllOrders(r.inner, target.Market(), target.Pair(), 0)
+			canceled = append(canceled, ids...)
+			// a traded pair may have nothing open
+			if err != nil && ErrorKindOf(err) != ErrorKindOrderNotFound && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	cancel(traded)
+
+	var markets []Market
+	for market, enabled := range r.inner.Config().MarketEnabled {
+		if enabled {
+			markets = append(markets, market)
+		}
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i] < markets[j] })
+	for _, market := range markets {
+		// orders got are canceled even if some pairs failed
+		orders, err := GetAllOpenOrders(r.inner, market, 0)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		var others []PairExt
+		for _, order := range orders {
+			if target := order.Id.Pair().SetMarket(market); !done[target] {
+				done[target] = true
+				others = append(others, target)
+			}
+		}
+		sortPairExts(others)
+		cancel(others)
+	}
+	return canceled, firstErr
+}
+
+func sortPairExts(targets []PairExt) {
+	sort.Slice(targets, func(i, j int) bool { return targets[i].String() < targets[j].String() })
+}
+
+// Resume accepts new orders after Kill
+func (r *RiskEx) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.killed = false
+}
+
+func (r *RiskEx) Killed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.killed
+}
+
+// nil if req passes all limits, caller holds tradeMu
+func (r *RiskEx) check(req TradeRequest) error {
+	if r.Killed() {
+		return newRiskError(RiskReasonKilled, "kill switch is on")
+	}
+	if len(r.limits.Markets) > 0 {
+		allowed := false
+		for _, m := range r.limits.Markets {
+			allowed = allowed || m == req.Market
+		}
+		if !allowed {
+			return newRiskError(RiskReasonMarket, "%s market not allowed", req.Market)
+		}
+	}
+
+	price := decimals.Zero
+	if r.limits.MaxOrderNotional.IsPositive() || len(r.limits.MaxPosition) > 0 {
+		var err error
+		if price, err = r.priceOf(req); err != nil {
+			return err
+		}
+	}
+	if max := r.limits.MaxOrderNotional; max.IsPositive() {
+		if notional := req.Amount.Mul(price); notional.GreaterThan(max) {
+			return newRiskError(RiskReasonNotional, "notional %s of %s over %s", notional.String(), req.Pair.String(), max.String())
+		}
+	}
+
+	var open []Order
+	if r.limits.MaxOpenOrders > 0 || len(r.limits.MaxPosition) > 0 {
+		var err error
+		if open, err = r.inner.GetOpenOrders(req.Market, req.Pair); err != nil {
+			return err
+		}
+	}
+	if max := r.limits.MaxOpenOrders; max > 0 && len(open) >= max {
+		return newRiskError(RiskReasonOpenOrders, "%d open orders of %s in %s market, max %d", len(open), req.Pair.String(), req.Market, max)
+	}
+	if err := r.checkPosition(req, price, open); err != nil {
+		return err
+	}
+	return r.checkDailyLoss()
+}
+
+// limit price, or stop price, or best price of depth for market orders
+func (r *RiskEx) priceOf(req TradeRequest) (decimals.Decimal, error) {
+	if req.Price.IsPositive() {
+		return req.Price, nil
+	}
+	if req.StopPrice.IsPositive() {
+		return req.StopPrice, nil
+	}
+	depth, err := r.inner.GetDepth(req.Market, req.Pair, 5)
+	if err != nil {
+		return decimals.Zero, err
+	}
+	depth.Sort()
+	books := depth.Sells
+	if req.Side == TradeSideSell {
+		books = depth.Buys
+	}
+	if len(books) == 0 {
+		return decimals.Zero, errorz.Errorf("no depth to price market order of %s", req.Pair.String())
+	}
+	return books[0].Price, nil
+}
+
+// asset received by order is checked, reduce only orders never add position
+func (r *RiskEx) checkPosition(req TradeRequest, price decimals.Decimal, open []Order) error {
+	if len(r.limits.MaxPosition) == 0 || req.ReduceOnly {
+		return nil
+	}
+	asset, add := req.Pair.Unit(), req.Amount
+	if req.Side == TradeSideSell {
+		asset, add = req.Pair.Quote(), req.Amount.Mul(price)
+	}
+	max, ok := r.limits.MaxPosition[asset]
+	if !ok {
+		return nil
+	}
+	acc, err := r.inner.GetAccount()
+	if err != nil {
+		return err
+	}
+	blc := acc.AssetInSpot(asset)
+	if req.Market == MarketMargin {
+		blc = acc.AssetInMargin(asset)
+	}
+	position := blc.Total().Add(add)
+	for _, order := range open {
+		left := order.Amount.Sub(order.DealAmount)
+		if order.TypeSide.IsBuy() && req.Side == TradeSideBuy {
+			position = position.Add(left)
+		}
+		if order.TypeSide.IsSell() && req.Side == TradeSideSell {
+			position = position.Add(left.Mul(order.Price))
+		}
+	}
+	if position.GreaterThan(max) {
+		return newRiskError(RiskReasonPosition, "%s position %s in %s market over %s", asset, position.String(), req.Market, max.String())
+	}
+	return nil
+}
+
+func (r *RiskEx) checkDailyLoss() error {
+	max := r.limits.MaxDailyLoss
+	if !max.IsPositive() {
+		return nil
+	}
+	acc, err := r.inner.GetAccount()
+	if err != nil {
+		return err
+	}
+	ticks, err := r.inner.GetTicks()
+	if err != nil {
+		return err
+	}
+	prices := map[PairExt]decimals.Decimal{}
+	for target, tick := range ticks {
+		prices[target] = tick.Last
+	}
+	total, err := acc.TotalInUSD(prices)
+	if err != nil {
+		return err
+	}
+	equity := total.Net()
+
+	day := nowOf(r.inner.Config()).UTC().Truncate(24 * time.Hour)
+	r.mu.Lock()
+	if !r.day.Equal(day) {
+		r.day, r.dayEquity = day, equity
+	}
+	loss := r.dayEquity.Sub(equity)
+	r.mu.Unlock()
+	if !loss.LessThan(max) {
+		return newRiskError(RiskReasonDailyLoss, "loss %s USD today reaches %s", loss.String(), max.String())
+	}
+	return nil
+}
+
+func (r *RiskEx) markTraded(market Market, target Pair) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traded[target.SetMarket(market)] = true
+}
+
+func (r *RiskEx) Config() *ExConfig {
+	return r.inner.Config()
+}
+
+// capabilities of inner exchange, markets are limited by RiskLimits.Markets if set
+func (r *RiskEx) Capabilities() Capabilities {
+	c := r.inner.Capabilities()
+	if len(r.limits.Markets) > 0 {
+		c.Markets = intersectMarkets(c, r.limits.Markets)
+	}
+	return SortCapabilities(c)
+}
+
+func (r *RiskEx) GetMarketInfo() (*MarketInfo, error) {
+	return r.inner.GetMarketInfo()
+}
+
+func (r *RiskEx) GetAccount() (*Account, error) {
+	return r.inner.GetAccount()
+}
+
+func (r *RiskEx) GetDepth(market Market, target Pair, limit int) (*Depth, error) {
+	return r.inner.GetDepth(market, target, limit)
+}
+
+func (r *RiskEx) GetTicks() (map[PairExt]Tick, error) {
+	return r.inner.GetTicks()
+}
+
+func (r *RiskEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	return r.inner.GetKline(market, target, period, since)
+}
+
+func (r *RiskEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	return r.inner.GetFills(market, target, fromId, limit)
+}
+
+func (r *RiskEx) GetBorrowable(asset string) (decimals.Decimal, error) {
+	return r.inner.GetBorrowable(asset)
+}
+
+func (r *RiskEx) Borrow(asset string, amount decimals.Decimal) error {
+	return r.inner.Borrow(asset, amount)
+}
+
+func (r *RiskEx) Repay(asset string, amount decimals.Decimal) error {
+	return r.inner.Repay(asset, amount)
+}
+
+func (r *RiskEx) Transfer(asset string, amount decimals.Decimal, target Market) error {
+	return r.inner.Transfer(asset, amount, target)
+}
+
+func (r *RiskEx) Trade(market Market, target Pair, t TradeTypeSide, amount, price decimals.Decimal) (*OrderId, error) {
+	r.tradeMu.Lock()
+	defer r.tradeMu.Unlock()
+	if err := r.check(NewTradeRequest(market, target, t, amount, price)); err != nil {
+		return nil, err
+	}
+	r.markTraded(market, target)
+	return r.inner.Trade(market, target, t, amount, price)
+}
+
+func (r *RiskEx) TradeWithOptions(req TradeRequest) (*OrderId, error) {
+	if err := req.Verify(); err != nil {
+		return nil, err
+	}
+	r.tradeMu.Lock()
+	defer r.tradeMu.Unlock()
+	if err := r.check(req); err != nil {
+		return nil, err
+	}
+	r.markTraded(req.Market, req.Pair)
+	return TradeWithOptions(r.inner, req)
+}
+
+func (r *RiskEx) GetAllOrders(market Market, target Pair) ([]Order, error) {
+	return r.inner.GetAllOrders(market, target)
+}
+
+func (r *RiskEx) GetOpenOrders(market Market, target Pair) ([]Order, error) {
+	return r.inner.GetOpenOrders(market, target)
+}
+
+func (r *RiskEx) GetOrder(id OrderId) (*Order, error) {
+	return r.inner.GetOrder(id)
+}
+
+func (r *RiskEx) CancelAllOrders(market Market, target Pair) ([]OrderId, error) {
+	return CancelAllOrders(r.inner, market, target, 0)
+}
+
+func (r *RiskEx) GetAllOpenOrders(market Market) ([]Order, error) {
+	return GetAllOpenOrders(r.inner, market, 0)
+}
+
+func (r *RiskEx) GetOrderByClientId(market Market, target Pair, clientId string) (*Order, error) {
+	return GetOrderByClientId(r.inner, market, target, clientId)
+}
+
+func (r *RiskEx) CancelOrder(id OrderId) error {
+	return r.inner.CancelOrder(id)
+}
+
+func (r *RiskEx) GetPositions(market Market) ([]Position, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return de.GetPositions(market)
+}
+
+func (r *RiskEx) SetLeverage(market Market, target Pair, leverage int) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	return de.SetLeverage(market, target, leverage)
+}
+
+func (r *RiskEx) SetMarginMode(market Market, target Pair, mode MarginMode) error {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return err
+	}
+	return de.SetMarginMode(market, target, mode)
+}
+
+func (r *RiskEx) GetFundingRate(target Pair) (*FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return de.GetFundingRate(target)
+}
+
+func (r *RiskEx) GetFundingHistory(target Pair, since *time.Time, limit int) ([]FundingRate, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return de.GetFundingHistory(target, since, limit)
+}
+
+func (r *RiskEx) GetMarkPrice(market Market, target Pair) (*MarkPrice, error) {
+	de, err := DerivativesOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return de.GetMarkPrice(market, target)
+}
+
+func (r *RiskEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return we.GetDepositAddress(asset, network)
+}
+
+func (r *RiskEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return "", err
+	}
+	return we.Withdraw(amount, to)
+}
+
+func (r *RiskEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return we.GetDeposits(asset, since)
+}
+
+func (r *RiskEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return we.GetWithdrawals(asset, since)
+}
+
+func (r *RiskEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	we, err := WalletOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return we.GetTransfers(asset, since)
+}
+
+func (r *RiskEx) GetFillsByTime(market Market, target Pair, begin time.Time, duration time.Duration) ([]Fill, error) {
+	fg, err := FillTimeGetterOf(r.inner)
+	if err != nil {
+		return nil, err
+	}
+	return fg.GetFillsByTime(market, target, begin, duration)
+}
+
+func (r *RiskEx) GetServerTime() (time.Time, error) {
+	st, err := ServerTimerOf(r.inner)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.GetServerTime()
+}
@@ -0,0 +1,124 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+func newTestRiskEx(t *testing.T, limits RiskLimits) (*RiskEx, *PaperEx, *fakeEx) {
+	p, real := newTestPaperEx(t)
+	real.config.Clock = &testClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	real.ticks[testPair.SetMarket(MarketSpot)] = Tick{Last: decimals.NewFromInt(100)}
+	r, err := NewRiskEx(p, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, p, real
+}
+
+func requireRiskReason(t *testing.T, err error, reason RiskReason) {
+	t.Helper()
+	var riskErr *RiskError
+	if !errors.As(err, &riskErr) || riskErr.Reason != reason {
+		t.Fatalf("expected %s rejection, got %v", reason, err)
+	}
+	if !errors.Is(err, ErrRiskRejected) || ErrorKindOf(err) != ErrorKindRiskRejected {
+		t.Fatalf("unexpected error kind of %v", err)
+	}
+}
+
+func TestRiskEx_Limits(t *testing.T) {
+	r, _, _ := newTestRiskEx(t, RiskLimits{
+		Markets:          []Market{MarketSpot},
+		MaxOrderNotional: decimals.NewFromInt(300),
+		MaxOpenOrders:    2,
+		MaxPosition:      map[string]decimals.Decimal{"BTC": decimals.NewFromFloat64(4.5)},
+	})
+
+	_, err := r.Trade(MarketMargin, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90))
+	requireRiskReason(t, err, RiskReasonMarket)
+	_, err = r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(3), decimals.NewFromInt(101))
+	requireRiskReason(t, err, RiskReasonNotional)
+	// market buy is priced by best ask 101
+	_, err = r.TradeWithOptions(NewTradeRequest(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.NewFromInt(3), decimals.Zero))
+	requireRiskReason(t, err, RiskReasonNotional)
+
+	// BTC 2 in account, 2 open buy orders
+	for i := 0; i < 2; i++ {
+		if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = r.Trade(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(110))
+	requireRiskReason(t, err, RiskReasonOpenOrders)
+
+	r.limits.MaxOpenOrders = 0
+	_, err = r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90))
+	requireRiskReason(t, err, RiskReasonPosition)
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromFloat64(0.5), decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+	// USDT is not limited
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(110)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRiskEx_Kill(t *testing.T) {
+	r, p, _ := newTestRiskEx(t, RiskLimits{})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// placed around r
+	if _, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+	canceled, err := r.Kill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 3 || !r.Killed() {
+		t.Fatalf("unexpected canceled orders %v", canceled)
+	}
+	if open, err := p.GetOpenOrders(MarketSpot, testPair); err != nil || len(open) != 0 {
+		t.Fatalf("orders left open %v %v", open, err)
+	}
+	_, err = r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90))
+	requireRiskReason(t, err, RiskReasonKilled)
+
+	r.Resume()
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+
+	// orders placed before the wrapper existed
+	fresh, err := NewRiskEx(p, RiskLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceled, err := fresh.Kill(); err != nil || len(canceled) != 1 {
+		t.Fatalf("unexpected canceled orders %v %v", canceled, err)
+	}
+}
+
+func TestRiskEx_DailyLoss(t *testing.T) {
+	r, _, real := newTestRiskEx(t, RiskLimits{MaxDailyLoss: decimals.NewFromInt(50)})
+	// net value 1000 + 2 * 100 at first check
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+	real.ticks[testPair.SetMarket(MarketSpot)] = Tick{Last: decimals.NewFromInt(60)}
+	_, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90))
+	requireRiskReason(t, err, RiskReasonDailyLoss)
+
+	// new day, new baseline
+	real.config.Clock.(*testClock).now = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(90)); err != nil {
+		t.Fatal(err)
+	}
+}
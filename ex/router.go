@@ -0,0 +1,301 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sort"
+	"sync"
+)
+
+type (
+	// RouteLeg is the child order of a parent order on one platform
+	RouteLeg struct {
+		Platform Platform
+		Amount   decimals.Decimal // unit amount
+		Price    decimals.Decimal // limit price, the worst level taken on platform
+		Cost     decimals.Decimal // estimated quote amount with taker fee, paid if buy, received if sell
+
+		// set by Execute
+		Id    *OrderId
+		Order *Order // final state after unfilled part canceled, nil if not got
+		Err   error
+	}
+
+	// RoutePlan splits a parent order across platforms, legs are sorted by platform
+	RoutePlan struct {
+		Market    Market
+		Pair      Pair
+		TypeSide  TradeTypeSide // limit-buy or limit-sell of every leg
+		Requested decimals.Decimal
+		Amount    decimals.Decimal // sum of legs, less than Requested if depth is not enough
+		Cost      decimals.Decimal // sum of legs
+		Legs      []RouteLeg
+	}
+
+	// RouteResult is the aggregate fill of an executed RoutePlan
+	RouteResult struct {
+		Plan       RoutePlan
+		DealAmount decimals.Decimal // filled unit amount of all legs
+		AvgPrice   decimals.Decimal // volume weighted by legs, zero if nothing filled
+		Fee        decimals.Decimal
+	}
+
+	// SmartRouter splits orders of a pair listed on platforms of a MultiEx
+	// by consolidated depth, cheapest levels with taker fee first.
+	SmartRouter struct {
+		m *MultiEx
+	}
+
+	routeLevel struct {
+		platform  Platform
+		price     decimals.Decimal
+		amount    decimals.Decimal
+		effective decimals.Decimal // price with taker fee
+	}
+)
+
+func NewSmartRouter(m *MultiEx) (*SmartRouter, error) {
+	if m == nil {
+		return nil, errorz.Errorf("nil MultiEx to route orders")
+	}
+	return &SmartRouter{m: m}, nil
+}
+
+// pairs of market listed on more than one platform
+func (r *SmartRouter) SamePairs(market Market) (map[Pair][]Platform, error) {
+	var mu sync.Mutex
+	listed := map[Platform][]Pair{}
+	err := r.m.each(func(platform Platform, e Ex) error {
+		mi, err := e.GetMarketInfo()
+		if err != nil {
+			return err
+		}
+		var pairs []Pair
+		for pe, info := range mi.Infos {
+			if pe.Market() == market && info.Enabled {
+				pairs = append(pairs, pe.Pair())
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		listed[platform] = pairs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	same := FindSamePairs(listed)
+	for _, platforms := range same {
+		sort.Slice(platforms, func(i, j int) bool { return platforms[i] < platforms[j] })
+	}
+	return same, nil
+}
+
+// Plan splits amount of target by depth of every platform listing it, one platform is enough.
+// Levels are taken by price with taker fee of ExConfig, amounts of legs are truncated by lot of platform.
+func (r *SmartRouter) Plan(market Market, target Pair, side TradeTypeSide, amount decimals.Decimal) (*RoutePlan, error) {
+	if err := side.Verify(); err != nil {
+		return nil, err
+	}
+	if !amount.IsPositive() {
+		return nil, errorz.Errorf("invalid route amount(%s)", amount.String())
+	}
+
+	all := r.m.Platforms()
+	allDepths := make([]*Depth, len(all))
+	allInfos := make([]*PairInfo, len(all))
+	errs := make([]error, len(all))
+	parallel(len(all), len(all), func(i int) {
+		e := r.m.exs[all[i]]
+		mi, err := e.GetMarketInfo()
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		info, err := findPairInfo(mi, market, target)
+		if err != nil || !info.Enabled {
+			return // not listed
+		}
+		allInfos[i] = info
+		allDepths[i], errs[i] = e.GetDepth(market, target, e.Config().MaxDepth)
+	})
+	var platforms []Platform
+	var depths []*Depth
+	var infos []*PairInfo
+	for i, err := range errs {
+		if err != nil {
+			return nil, errorz.Errorf("platform(%s) error: %s", all[i].String(), err.Error())
+		}
+		if allInfos[i] != nil {
+			platforms = append(platforms, all[i])
+			depths = append(depths, allDepths[i])
+			infos = append(infos, allInfos[i])
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, errorz.Errorf("%s of %s market is not listed on any platform", target.String(), market)
+	}
+
+	var levels []routeLevel
+	for i, platform := range platforms {
+		fee := r.m.exs[platform].Config().TakerFee
+		depths[i].Sort()
+		books, factor := depths[i].Sells, decimals.One.Add(fee)
+		if side.IsSell() {
+			books, factor = depths[i].Buys, decimals.One.Sub(fee)
+		}
+		for _, book := range RemoveInvalidOrders(books) {
+			levels = append(levels, routeLevel{platform: platform, price: book.Price, amount: book.Amount, effective: book.Price.Mul(factor)})
+		}
+	}
+	// platforms are sorted, equal levels go to the first platform
+	sort.SliceStable(levels, func(i, j int) bool {
+		if side.IsSell() {
+			return levels[i].effective.GreaterThan(levels[j].effective)
+		}
+		return levels[i].effective.LessThan(levels[j].effective)
+	})
+
+	legs := map[Platform]*RouteLeg{}
+	left := amount
+	for _, level := range levels {
+		if !left.IsPositive() {
+			break
+		}
+		take := decimals.Min(level.amount, left)
+		leg, ok := legs[level.platform]
+		if !ok {
+			leg = &RouteLeg{Platform: level.platform, Amount: decimals.Zero, Price: level.price}
+			legs[level.platform] = leg
+		}
+		leg.Amount = leg.Amount.Add(take)
+		// levels of a platform are taken from best to worst
+		leg.Price = level.price
+		left = left.Sub(take)
+	}
+
+	plan := &RoutePlan{Market: market, Pair: target, TypeSide: TradeTypeSideLimitBuy, Requested: amount, Amount: decimals.Zero, Cost: decimals.Zero}
+	if side.IsSell() {
+		plan.TypeSide = TradeTypeSideLimitSell
+	}
+	for i, platform := range platforms {
+		leg, ok := legs[platform]
+		if !ok {
+			continue
+		}
+		leg.Amount = roundAmount(leg.Amount, infos[i])
+		if !leg.Amount.IsPositive() || leg.Amount.LessThan(infos[i].LotMin) {
+			continue
+		}
+		leg.Price = roundPrice(leg.Price, infos[i])
+		leg.Cost = routeCost(depths[i], side, leg.Amount, r.m.exs[platform].Config().TakerFee)
+		plan.Amount = plan.Amount.Add(leg.Amount)
+		plan.Cost = plan.Cost.Add(leg.Cost)
+		plan.Legs = append(plan.Legs, *leg)
+	}
+	if len(plan.Legs) == 0 {
+		return nil, errorz.Errorf("no depth to route %s %s of %s", side, amount.String(), target.String())
+	}
+	return plan, nil
+}
+
+// quote amount of taking amount from sorted depth, with taker fee
+func routeCost(depth *Depth, side TradeTypeSide, amount, fee decimals.Decimal) decimals.Decimal {
+	books, factor := depth.Sells, decimals.One.Add(fee)
+	if side.IsSell() {
+		books, factor = depth.Buys, decimals.One.Sub(fee)
+	}
+	r := decimals.Zero
+	left := amount
+	for _, book := range RemoveInvalidOrders(books) {
+		if !left.IsPositive() {
+			break
+		}
+		take := decimals.Min(book.Amount, left)
+		r = r.Add(take.Mul(book.Price))
+		left = left.Sub(take)
+	}
+	return r.Mul(factor)
+}
+
+// Execute places legs of plan concurrently as immediate or cancel orders, nothing is left resting if book moved.
+// Platforms which don't support IOC place legs by Trade, and cancel unfilled part at once.
+// Orders of legs are got after that, so the result is final.
+// Failed legs keep their errors, the first one is returned with the result of others.
+func (r *SmartRouter) Execute(plan RoutePlan) (*RouteResult, error) {
+	res := &RouteResult{Plan: plan, DealAmount: decimals.Zero, AvgPrice: decimals.Zero, Fee: decimals.Zero}
+	res.Plan.Legs = append([]RouteLeg(nil), plan.Legs...)
+	legs := res.Plan.Legs
+	parallel(len(legs), len(legs), func(i int) {
+		e, err := r.m.Ex(legs[i].Platform)
+		if err != nil {
+			legs[i].Err = err
+			return
+		}
+		legs[i].Id, legs[i].Order, legs[i].Err = executeLeg(e, plan, legs[i])
+	})
+
+	var firstErr error
+	quote := decimals.Zero
+	for _, leg := range legs {
+		if leg.Err != nil {
+			if firstErr == nil {
+				firstErr = errorz.Errorf("platform(%s) error: %s", leg.Platform.String(), leg.Err.Error())
+			}
+		}
+		if leg.Order == nil || !leg.Order.DealAmount.IsPositive() {
+			continue
+		}
+		price := leg.Order.AvgPrice
+		if !price.IsPositive() {
+			price = leg.Price
+		}
+		res.DealAmount = res.DealAmount.Add(leg.Order.DealAmount)
+		quote = quote.Add(leg.Order.DealAmount.Mul(price))
+		res.Fee = res.Fee.Add(leg.Order.Fee)
+	}
+	if res.DealAmount.IsPositive() {
+		res.AvgPrice = quote.Div(res.DealAmount)
+	}
+	return res, firstErr
+}
+
+// place leg as IOC, or by Trade and cancel, then get its final order
+func executeLeg(e Ex, plan RoutePlan, leg RouteLeg) (*OrderId, *Order, error) {
+	ioc := e.Capabilities().HasTimeInForce(TimeInForceIOC)
+	var id *OrderId
+	var err error
+	if ioc {
+		id, err = TradeWithOptions(e, NewTradeRequest(plan.Market, plan.Pair, plan.TypeSide, leg.Amount, leg.Price, WithTimeInForce(TimeInForceIOC)))
+	} else {
+		id, err = e.Trade(plan.Market, plan.Pair, plan.TypeSide, leg.Amount, leg.Price)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var cancelErr error
+	if !ioc {
+		cancelErr = e.CancelOrder(*id) // fails if already filled
+	}
+	order, err := e.GetOrder(*id)
+	if err != nil {
+		return id, nil, err
+	}
+	if !order.Status.End() {
+		if cancelErr == nil {
+			cancelErr = errorz.Errorf("order(%s) is still %s", id.String(), order.Status)
+		}
+		return id, order, cancelErr
+	}
+	return id, order, nil
+}
+
+// Trade plans and executes a parent order
+func (r *SmartRouter) Trade(market Market, target Pair, side TradeTypeSide, amount decimals.Decimal) (*RouteResult, error) {
+	plan, err := r.Plan(market, target, side, amount)
+	if err != nil {
+		return nil, err
+	}
+	return r.Execute(*plan)
+}
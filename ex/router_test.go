@@ -0,0 +1,152 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func newTestRouter(t *testing.T) (*SmartRouter, *PaperEx, *PaperEx) {
+	binance, binanceReal := newTestPaperEx(t)
+	binanceReal.setDepth(testPair, [][2]float64{{100, 1}, {103, 5}}, [][2]float64{{99, 1}, {96, 5}})
+
+	krakenReal := newFakeEx()
+	krakenReal.config.Name = Kraken
+	krakenReal.config.TakerFee = decimals.NewFromFloat64(0.001)
+	krakenReal.setDepth(testPair, [][2]float64{{101, 1}, {102, 5}}, [][2]float64{{98, 1}, {97, 5}})
+	acc := NewEmptyAccount()
+	acc.SetSpot("USDT", decimals.NewFromInt(1000), decimals.Zero)
+	acc.SetSpot("BTC", decimals.NewFromInt(2), decimals.Zero)
+	kraken, err := NewPaperEx(krakenReal, acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMultiEx(binance, kraken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSmartRouter(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, binance, kraken
+}
+
+func TestSmartRouter_Plan(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+
+	same, err := r.SamePairs(MarketSpot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := same[testPair]; len(ps) != 2 || ps[0] != Binance || ps[1] != Kraken {
+		t.Fatalf("unexpected same pairs %v", same)
+	}
+
+	// with fee, binance 100 < kraken 101 < kraken 102 < binance 103
+	plan, err := r.Plan(MarketSpot, testPair, TradeTypeSideMarketBuy, decimals.NewFromInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Legs) != 2 || plan.TypeSide != TradeTypeSideLimitBuy || !plan.Amount.EqualInt(3) {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if leg := plan.Legs[0]; leg.Platform != Binance || !leg.Amount.EqualInt(1) || !leg.Price.EqualInt(100) {
+		t.Fatalf("unexpected binance leg %+v", leg)
+	}
+	if leg := plan.Legs[1]; leg.Platform != Kraken || !leg.Amount.EqualInt(2) || !leg.Price.EqualInt(102) {
+		t.Fatalf("unexpected kraken leg %+v", leg)
+	}
+	// 100 * 1.002 + (101 + 102) * 1.001
+	if !plan.Cost.Equal(decimals.NewFromFloat64(303.403)) {
+		t.Fatalf("unexpected cost %s", plan.Cost.String())
+	}
+
+	// sell takes highest bids, more than depth is cut
+	plan, err = r.Plan(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.NewFromInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Amount.EqualInt(12) || !plan.Requested.EqualInt(20) || plan.TypeSide != TradeTypeSideLimitSell {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	if _, err := r.Plan(MarketSpot, NewPair("ETH", "USDT"), TradeTypeSideLimitBuy, decimals.One); err == nil {
+		t.Fatal("pair listed nowhere routed")
+	}
+
+	// a pair listed on one platform is routed to it
+	eth := NewPair("ETH", "USDT")
+	binance, _ := r.m.Ex(Binance)
+	binanceReal := binance.(*PaperEx).real.(*fakeEx)
+	binanceReal.info.Infos[eth.SetMarket(MarketSpot)] = binanceReal.info.Infos[testPair.SetMarket(MarketSpot)]
+	binanceReal.setDepth(eth, [][2]float64{{10, 5}}, [][2]float64{{9, 5}})
+	plan, err = r.Plan(MarketSpot, eth, TradeTypeSideLimitBuy, decimals.One)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Legs) != 1 || plan.Legs[0].Platform != Binance {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+func TestSmartRouter_Trade(t *testing.T) {
+	r, binance, kraken := newTestRouter(t)
+	res, err := r.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.DealAmount.EqualInt(3) || !res.AvgPrice.EqualInt(101) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	for _, leg := range res.Plan.Legs {
+		if leg.Id == nil || leg.Order == nil || leg.Order.Status != TradeStatusFilled {
+			t.Fatalf("unexpected leg %+v", leg)
+		}
+	}
+
+	acc, err := binance.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Spot["USDT"].Free.EqualInt(900) {
+		t.Fatalf("unexpected binance account %s", acc.String())
+	}
+	acc, err = kraken.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Spot["USDT"].Free.EqualInt(797) {
+		t.Fatalf("unexpected kraken account %s", acc.String())
+	}
+}
+
+func TestSmartRouter_BookMoved(t *testing.T) {
+	r, binance, _ := newTestRouter(t)
+	plan, err := r.Plan(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// binance level of 100 is gone before execution
+	binance.real.(*fakeEx).setDepth(testPair, [][2]float64{{103, 5}}, [][2]float64{{99, 1}})
+	res, err := r.Execute(*plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.DealAmount.EqualInt(2) {
+		t.Fatalf("only kraken leg expected to fill, got %+v", res)
+	}
+	for _, leg := range res.Plan.Legs {
+		if leg.Order == nil || !leg.Order.Status.End() {
+			t.Fatalf("leg %+v left open", leg)
+		}
+	}
+	if open, _ := binance.GetOpenOrders(MarketSpot, testPair); len(open) != 0 {
+		t.Fatalf("unfilled leg rests on binance %v", open)
+	}
+	acc, _ := binance.GetAccount()
+	if !acc.Spot["USDT"].Free.EqualInt(1000) || acc.Spot["USDT"].Locked.IsPositive() {
+		t.Fatalf("unexpected binance account %s", acc.String())
+	}
+}
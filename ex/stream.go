@@ -0,0 +1,324 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+)
+
+const (
+	StreamDepth StreamKind = "depth"
+	StreamTick  StreamKind = "tick"
+	StreamFill  StreamKind = "fill"
+	StreamKline StreamKind = "kline"
+
+	defaultStreamBufferSize = 64
+)
+
+type (
+	StreamKind string
+
+	StreamTopic struct {
+		Kind   StreamKind
+		Target PairExt // market is required, period is required by kline
+	}
+
+	DepthEvent struct {
+		Target PairExt
+		Depth  Depth
+	}
+
+	TickEvent struct {
+		Target PairExt
+		Tick   Tick
+	}
+
+	FillEvent struct {
+		Target PairExt
+		Fill   Fill
+	}
+
+	// only closed kline dots are pushed
+	KlineEvent struct {
+		Target PairExt
+		Dot    KDot
+	}
+
+	// ExStream pushes market data, it is the streaming alternative of polling market data methods of Ex.
+	// Implementations reconnect and resubscribe by themselves, subscribers never need to subscribe again.
+	// Only fills and closed klines after subscribing are pushed, history is got by GetFills and GetKline.
+	// Events are dropped for a subscriber whose channel is full, and reported to Errors.
+	// All subscribed channels are closed after Close.
+	ExStream interface {
+		SubscribeDepth(target PairExt) (<-chan DepthEvent, error)
+
+		SubscribeTicks(target PairExt) (<-chan TickEvent, error)
+
+		SubscribeFills(target PairExt) (<-chan FillEvent, error)
+
+		SubscribeKline(target PairExt) (<-chan KlineEvent, error)
+
+		// connection and polling errors, they are informational, stream keeps working
+		Errors() <-chan error
+
+		Close() error
+	}
+
+	// fan out events to subscribers
+	streamHub struct {
+		mu         sync.Mutex
+		bufferSize int
+		depths     map[PairExt][]chan DepthEvent
+		ticks      map[PairExt][]chan TickEvent
+		fills      map[PairExt][]chan FillEvent
+		klines     map[PairExt][]chan KlineEvent
+		orders     []chan OrderEvent
+		balances   []chan BalanceEvent
+		errs       chan error
+		done       chan struct{}
+		closed     bool
+	}
+)
+
+func (t StreamTopic) Verify() error {
+	if t.Target.Pair() == PairErr || t.Target.Market() == MarketError {
+		return errorz.Errorf("stream target(%s) requires pair and market", t.Target.String())
+	}
+	if t.Kind == StreamKline && t.Target.Period() == PeriodError {
+		return errorz.Errorf("kline stream target(%s) requires period", t.Target.String())
+	}
+	if t.Kind != StreamDepth && t.Kind != StreamTick && t.Kind != StreamFill && t.Kind != StreamKline {
+		return errorz.Errorf("invalid StreamKind(%s)", string(t.Kind))
+	}
+	return nil
+}
+
+func newStreamHub(bufferSize int) *streamHub {
+	if bufferSize <= 0 {
+		bufferSize = defaultStreamBufferSize
+	}
+	return &streamHub{
+		bufferSize: bufferSize,
+		depths:     map[PairExt][]chan DepthEvent{},
+		ticks:      map[PairExt][]chan TickEvent{},
+		fills:      map[PairExt][]chan FillEvent{},
+		klines:     map[PairExt][]chan KlineEvent{},
+		errs:       make(chan error, bufferSize),
+		done:       make(chan struct{}),
+	}
+}
+
+func (h *streamHub) addDepth(target PairExt) (chan DepthEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan DepthEvent, h.bufferSize)
+	h.depths[target] = append(h.depths[target], ch)
+	return ch, nil
+}
+
+func (h *streamHub) addTick(target PairExt) (chan TickEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan TickEvent, h.bufferSize)
+	h.ticks[target] = append(h.ticks[target], ch)
+	return ch, nil
+}
+
+func (h *streamHub) addOrder() (chan OrderEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan OrderEvent, h.bufferSize)
+	h.orders = append(h.orders, ch)
+	return ch, nil
+}
+
+func (h *streamHub) addBalance() (chan BalanceEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan BalanceEvent, h.bufferSize)
+	h.balances = append(h.balances, ch)
+	return ch, nil
+}
+
+func (h *streamHub) addFill(target PairExt) (chan FillEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan FillEvent, h.bufferSize)
+	h.fills[target] = append(h.fills[target], ch)
+	return ch, nil
+}
+
+func (h *streamHub) addKline(target PairExt) (chan KlineEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errorz.Errorf("stream closed")
+	}
+	ch := make(chan KlineEvent, h.bufferSize)
+	h.klines[target] = append(h.klines[target], ch)
+	return ch, nil
+}
+
+// deliver event to all subscribers of its target without blocking, a slow subscriber never stalls others or the connection.
+// Event is dropped for subscribers whose buffer is full, and the overflow is reported to Errors.
+// Private events carry deltas and are never dropped, they are sent by publishOrder and publishBalance.
+func (h *streamHub) publish(ev interface{}) {
+	switch v := ev.(type) {
+	case OrderEvent:
+		h.publishOrder(v)
+		return
+	case BalanceEvent:
+		h.publishBalance(v)
+		return
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	dropped := 0
+	switch v := ev.(type) {
+	case DepthEvent:
+		for _, ch := range h.depths[v.Target] {
+			select {
+			case ch <- v:
+			default:
+				dropped++
+			}
+		}
+	case TickEvent:
+		for _, ch := range h.ticks[v.Target] {
+			select {
+			case ch <- v:
+			default:
+				dropped++
+			}
+		}
+	case FillEvent:
+		for _, ch := range h.fills[v.Target] {
+			select {
+			case ch <- v:
+			default:
+				dropped++
+			}
+		}
+	case KlineEvent:
+		for _, ch := range h.klines[v.Target] {
+			select {
+			case ch <- v:
+			default:
+				dropped++
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	if dropped > 0 {
+		h.reportError(errorz.Errorf("%T dropped by %d slow subscribers", ev, dropped))
+	}
+}
+
+// blocks until every subscriber takes ev or hub is stopped, subscribers are only appended so a copy of them is safe to range
+func (h *streamHub) publishOrder(ev OrderEvent) {
+	h.mu.Lock()
+	subs := h.orders
+	h.mu.Unlock()
+	for _, ch := range subs {
+		select {
+		case ch <- ev:
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *streamHub) publishBalance(ev BalanceEvent) {
+	h.mu.Lock()
+	subs := h.balances
+	h.mu.Unlock()
+	for _, ch := range subs {
+		select {
+		case ch <- ev:
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// errors are dropped if nobody reads them
+func (h *streamHub) reportError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.errs <- err:
+	default:
+	}
+}
+
+func (h *streamHub) Errors() <-chan error {
+	return h.errs
+}
+
+// stop publishing, caller must wait all publishers exit before closeChannels
+func (h *streamHub) stop() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.closed = true
+	close(h.done)
+	return true
+}
+
+func (h *streamHub) closeChannels() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, chs := range h.depths {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	for _, chs := range h.ticks {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	for _, chs := range h.fills {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	for _, chs := range h.klines {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	for _, ch := range h.orders {
+		close(ch)
+	}
+	for _, ch := range h.balances {
+		close(ch)
+	}
+	close(h.errs)
+}
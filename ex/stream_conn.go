@@ -0,0 +1,278 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStreamHeartbeatInterval = 15 * time.Second
+	defaultStreamHeartbeatTimeout  = time.Minute
+	defaultStreamReconnectBackoff  = time.Second
+	defaultStreamMaxBackoff        = time.Minute
+)
+
+type (
+	// StreamConn is one connection to exchange push server, like a websocket connection.
+	// Adapters implement it and get reconnect, resubscribe and heartbeat from NewConnStream.
+	StreamConn interface {
+		// never called on user data connections, they are authorized and subscribed when dialed
+		Subscribe(topic StreamTopic) error
+
+		// block until next message, returns DepthEvent, TickEvent, FillEvent, KlineEvent,
+		// OrderEvent, BalanceEvent, or nil for control messages like pong
+		Read() (interface{}, error)
+
+		Ping() error
+
+		// unblock Read
+		Close() error
+	}
+
+	StreamDialer func() (StreamConn, error)
+
+	StreamOption struct {
+		BufferSize        int           // buffer size of each subscribed channel
+		HeartbeatInterval time.Duration // ping interval
+		HeartbeatTimeout  time.Duration // reconnect if nothing received for this long
+		ReconnectBackoff  time.Duration // wait before first reconnect, doubled each failure
+		MaxBackoff        time.Duration
+	}
+
+	// keeps a StreamConn alive and feeds messages into hub, shared by market data and user data streams
+	connKeeper struct {
+		hub       *streamHub
+		dial      StreamDialer
+		option    StreamOption
+		onConnect func(conn StreamConn) // called with mu held on every new connection
+		mu        sync.Mutex
+		conn      StreamConn
+		lastRead  int64 // unix nano
+		wg        sync.WaitGroup
+	}
+
+	connStream struct {
+		*streamHub
+		keeper *connKeeper
+		topics map[StreamTopic]bool
+	}
+)
+
+func (o *StreamOption) setDefaults() {
+	if o.HeartbeatInterval <= 0 {
+		o.HeartbeatInterval = defaultStreamHeartbeatInterval
+	}
+	if o.HeartbeatTimeout <= 0 {
+		o.HeartbeatTimeout = defaultStreamHeartbeatTimeout
+	}
+	if o.ReconnectBackoff <= 0 {
+		o.ReconnectBackoff = defaultStreamReconnectBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultStreamMaxBackoff
+	}
+}
+
+// NewConnStream makes an ExStream from a push connection dialer, connection is kept alive until Close.
+func NewConnStream(dial StreamDialer, option StreamOption) (ExStream, error) {
+	if dial == nil {
+		return nil, errorz.Errorf("nil stream dialer")
+	}
+	option.setDefaults()
+	s := &connStream{
+		streamHub: newStreamHub(option.BufferSize),
+		topics:    map[StreamTopic]bool{},
+	}
+	s.keeper = &connKeeper{hub: s.streamHub, dial: dial, option: option, onConnect: s.resubscribe}
+	s.keeper.start()
+	return s, nil
+}
+
+func (s *connStream) SubscribeDepth(target PairExt) (<-chan DepthEvent, error) {
+	if err := s.subscribe(StreamTopic{Kind: StreamDepth, Target: target}); err != nil {
+		return nil, err
+	}
+	return s.addDepth(target)
+}
+
+func (s *connStream) SubscribeTicks(target PairExt) (<-chan TickEvent, error) {
+	if err := s.subscribe(StreamTopic{Kind: StreamTick, Target: target}); err != nil {
+		return nil, err
+	}
+	return s.addTick(target)
+}
+
+func (s *connStream) SubscribeFills(target PairExt) (<-chan FillEvent, error) {
+	if err := s.subscribe(StreamTopic{Kind: StreamFill, Target: target}); err != nil {
+		return nil, err
+	}
+	return s.addFill(target)
+}
+
+func (s *connStream) SubscribeKline(target PairExt) (<-chan KlineEvent, error) {
+	if err := s.subscribe(StreamTopic{Kind: StreamKline, Target: target}); err != nil {
+		return nil, err
+	}
+	return s.addKline(target)
+}
+
+func (s *connStream) Close() error {
+	s.keeper.close()
+	return nil
+}
+
+// remember topic, it is subscribed again after each reconnect
+func (s *connStream) subscribe(topic StreamTopic) error {
+	if err := topic.Verify(); err != nil {
+		return err
+	}
+	s.keeper.mu.Lock()
+	defer s.keeper.mu.Unlock()
+	if s.topics[topic] {
+		return nil
+	}
+	s.topics[topic] = true
+	if s.keeper.conn != nil {
+		if err := s.keeper.conn.Subscribe(topic); err != nil {
+			s.reportError(err) // retried after reconnect
+		}
+	}
+	return nil
+}
+
+// keeper.mu is held
+func (s *connStream) resubscribe(conn StreamConn) {
+	for topic := range s.topics {
+		if err := conn.Subscribe(topic); err != nil {
+			s.reportError(err)
+		}
+	}
+}
+
+func (k *connKeeper) start() {
+	k.wg.Add(1)
+	go k.run()
+}
+
+func (k *connKeeper) close() {
+	if !k.hub.stop() {
+		return
+	}
+	k.mu.Lock()
+	if k.conn != nil {
+		k.conn.Close()
+	}
+	k.mu.Unlock()
+	k.wg.Wait()
+	k.hub.closeChannels()
+}
+
+func (k *connKeeper) run() {
+	defer k.wg.Done()
+	backoff := k.option.ReconnectBackoff
+	for {
+		select {
+		case <-k.hub.done:
+			return
+		default:
+		}
+
+		conn, err := k.dial()
+		if err != nil {
+			k.hub.reportError(errorz.Errorf("stream dial error: %s", err.Error()))
+			if !k.wait(backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > k.option.MaxBackoff {
+				backoff = k.option.MaxBackoff
+			}
+			continue
+		}
+		backoff = k.option.ReconnectBackoff
+		k.serve(conn)
+	}
+}
+
+// read conn until it breaks
+func (k *connKeeper) serve(conn StreamConn) {
+	k.mu.Lock()
+	select {
+	case <-k.hub.done:
+		k.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	k.conn = conn
+	if k.onConnect != nil {
+		k.onConnect(conn)
+	}
+	k.mu.Unlock()
+	atomic.StoreInt64(&k.lastRead, time.Now().UnixNano())
+
+	connDone := make(chan struct{})
+	k.wg.Add(1)
+	go k.heartbeat(conn, connDone)
+
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			select {
+			case <-k.hub.done:
+			default:
+				k.hub.reportError(errorz.Errorf("stream read error: %s", err.Error()))
+			}
+			break
+		}
+		atomic.StoreInt64(&k.lastRead, time.Now().UnixNano())
+		if msg != nil {
+			k.hub.publish(msg)
+		}
+	}
+
+	close(connDone)
+	k.mu.Lock()
+	k.conn = nil
+	k.mu.Unlock()
+	conn.Close()
+}
+
+func (k *connKeeper) heartbeat(conn StreamConn, connDone chan struct{}) {
+	defer k.wg.Done()
+	ticker := time.NewTicker(k.option.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-connDone:
+			return
+		case <-k.hub.done:
+			return
+		case <-ticker.C:
+			silence := time.Since(time.Unix(0, atomic.LoadInt64(&k.lastRead)))
+			if silence > k.option.HeartbeatTimeout {
+				k.hub.reportError(errorz.Errorf("stream heartbeat timeout, nothing received in %s", silence))
+				conn.Close() // Read fails and reconnect follows
+				return
+			}
+			if err := conn.Ping(); err != nil {
+				k.hub.reportError(errorz.Errorf("stream ping error: %s", err.Error()))
+			}
+		}
+	}
+}
+
+// false if stream closed while waiting
+func (k *connKeeper) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-k.hub.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,194 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollingInterval = time.Second
+	defaultPollingDepth    = 20
+	defaultPollingFills    = 500
+)
+
+type (
+	PollingOption struct {
+		BufferSize int
+		Interval   time.Duration // interval between two polls of one topic, ExConfig.RateLimit by default
+		DepthLimit int
+	}
+
+	// pollingStream adapts a plain Ex into an ExStream, each topic is polled by its own goroutine
+	pollingStream struct {
+		*streamHub
+		ex      Ex
+		option  PollingOption
+		mu      sync.Mutex
+		started map[StreamTopic]bool
+		wg      sync.WaitGroup
+	}
+)
+
+// NewPollingStream makes an ExStream by polling e, it is the fallback if exchange has no push API.
+func NewPollingStream(e Ex, option PollingOption) (ExStream, error) {
+	if e == nil {
+		return nil, errorz.Errorf("nil exchange to poll")
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultPollingInterval
+		if e.Config() != nil && e.Config().RateLimit > 0 {
+			option.Interval = e.Config().RateLimit
+		}
+	}
+	if option.DepthLimit <= 0 {
+		option.DepthLimit = defaultPollingDepth
+	}
+	return &pollingStream{
+		streamHub: newStreamHub(option.BufferSize),
+		ex:        e,
+		option:    option,
+		started:   map[StreamTopic]bool{},
+	}, nil
+}
+
+func (s *pollingStream) SubscribeDepth(target PairExt) (<-chan DepthEvent, error) {
+	ch, err := s.addDepth(target)
+	if err != nil {
+		return nil, err
+	}
+	return ch, s.start(StreamTopic{Kind: StreamDepth, Target: target})
+}
+
+func (s *pollingStream) SubscribeTicks(target PairExt) (<-chan TickEvent, error) {
+	ch, err := s.addTick(target)
+	if err != nil {
+		return nil, err
+	}
+	return ch, s.start(StreamTopic{Kind: StreamTick, Target: target})
+}
+
+func (s *pollingStream) SubscribeFills(target PairExt) (<-chan FillEvent, error) {
+	ch, err := s.addFill(target)
+	if err != nil {
+		return nil, err
+	}
+	return ch, s.start(StreamTopic{Kind: StreamFill, Target: target})
+}
+
+func (s *pollingStream) SubscribeKline(target PairExt) (<-chan KlineEvent, error) {
+	ch, err := s.addKline(target)
+	if err != nil {
+		return nil, err
+	}
+	return ch, s.start(StreamTopic{Kind: StreamKline, Target: target})
+}
+
+func (s *pollingStream) Close() error {
+	if !s.stop() {
+		return nil
+	}
+	s.wg.Wait()
+	s.closeChannels()
+	return nil
+}
+
+func (s *pollingStream) start(topic StreamTopic) error {
+	if err := topic.Verify(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started[topic] {
+		return nil
+	}
+	s.started[topic] = true
+	s.wg.Add(1)
+	go s.poll(topic)
+	return nil
+}
+
+func (s *pollingStream) poll(topic StreamTopic) {
+	defer s.wg.Done()
+	market, pair := topic.Target.Market(), topic.Target.Pair()
+
+	// last state, only changes are pushed
+	var lastDepth *Depth
+	var lastTick *Tick
+	var nextFillId *int64
+	var klineSince *time.Time
+	// the first poll of fills and klines only sets cursors, history is never pushed as live events
+	primed := false
+
+	ticker := time.NewTicker(s.option.Interval)
+	defer ticker.Stop()
+	for {
+		var err error
+		switch topic.Kind {
+		case StreamDepth:
+			var d *Depth
+			if d, err = s.ex.GetDepth(market, pair, s.option.DepthLimit); err == nil {
+				if lastDepth == nil || !lastDepth.DepthRawData.Equal(&d.DepthRawData) {
+					lastDepth = d
+					s.publish(DepthEvent{Target: topic.Target, Depth: *d})
+				}
+			}
+		case StreamTick:
+			var ticks map[PairExt]Tick
+			if ticks, err = s.ex.GetTicks(); err == nil {
+				if tick, ok := ticks[pair.SetMarket(market)]; ok {
+					if lastTick == nil || !lastTick.Time.Equal(tick.Time) || !lastTick.Last.Equal(tick.Last) {
+						lastTick = &tick
+						s.publish(TickEvent{Target: topic.Target, Tick: tick})
+					}
+				}
+			}
+		case StreamFill:
+			var fills []Fill
+			if fills, err = s.ex.GetFills(market, pair, nextFillId, defaultPollingFills); err == nil {
+				for _, fill := range fills {
+					if nextFillId != nil && fill.Id < *nextFillId {
+						continue
+					}
+					next := fill.Id + 1
+					nextFillId = &next
+					if primed {
+						s.publish(FillEvent{Target: topic.Target, Fill: fill})
+					}
+				}
+			}
+		case StreamKline:
+			period := topic.Target.Period()
+			var k *Kline
+			if k, err = s.ex.GetKline(market, pair, period, klineSince); err == nil {
+				now := nowOf(s.ex.Config())
+				for _, dot := range k.Items {
+					if klineSince != nil && dot.Time.Before(*klineSince) {
+						continue
+					}
+					if dot.Time.Add(period.ToDurationExact(dot.Time, time.UTC)).After(now) {
+						break // not closed yet
+					}
+					next := dot.Time.Add(time.Nanosecond)
+					klineSince = &next
+					if primed {
+						s.publish(KlineEvent{Target: topic.Target, Dot: dot})
+					}
+				}
+			}
+		}
+		if err != nil {
+			s.reportError(errorz.Errorf("poll %s of %s error: %s", topic.Kind, topic.Target.String(), err.Error()))
+		} else {
+			primed = true
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
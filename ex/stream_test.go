@@ -0,0 +1,293 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	. "github.com/shawnwyckoff/foxs/frame"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scripted push connection, fails Read after its messages are consumed if broken
+type fakeStreamConn struct {
+	mu         sync.Mutex
+	subscribed []StreamTopic
+	msgs       chan interface{}
+	broken     bool
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func newFakeStreamConn(broken bool, msgs ...interface{}) *fakeStreamConn {
+	c := &fakeStreamConn{msgs: make(chan interface{}, len(msgs)), broken: broken, closed: make(chan struct{})}
+	for _, msg := range msgs {
+		c.msgs <- msg
+	}
+	return c
+}
+
+func (c *fakeStreamConn) Subscribe(topic StreamTopic) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = append(c.subscribed, topic)
+	return nil
+}
+
+func (c *fakeStreamConn) Read() (interface{}, error) {
+	select {
+	case msg := <-c.msgs:
+		return msg, nil
+	default:
+	}
+	if c.broken {
+		return nil, errors.New("connection reset")
+	}
+	<-c.closed
+	return nil, errors.New("use of closed connection")
+}
+
+func (c *fakeStreamConn) Ping() error { return nil }
+
+func (c *fakeStreamConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeStreamConn) topics() []StreamTopic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]StreamTopic(nil), c.subscribed...)
+}
+
+func TestConnStream_Resubscribe(t *testing.T) {
+	target := testPair.SetMarket(MarketSpot)
+	tick := TickEvent{Target: target, Tick: Tick{Last: decimals.NewFromFloat64(100)}}
+
+	// first connection breaks after one message, second one stays
+	conns := make(chan *fakeStreamConn, 2)
+	conns <- newFakeStreamConn(true, tick)
+	conns <- newFakeStreamConn(false, tick)
+	dialed := make(chan *fakeStreamConn, 2)
+	dial := func() (StreamConn, error) {
+		select {
+		case c := <-conns:
+			dialed <- c
+			return c, nil
+		default:
+			return nil, errors.New("no more connections")
+		}
+	}
+
+	s, err := NewConnStream(dial, StreamOption{ReconnectBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := s.SubscribeTicks(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-ch:
+			if !ev.Tick.Last.Equal(tick.Tick.Last) {
+				t.Fatalf("unexpected tick %s", ev.Tick.Last.String())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tick %d not received", i)
+		}
+	}
+
+	<-dialed
+	second := <-dialed
+	if topics := second.topics(); len(topics) != 1 || topics[0].Target != target {
+		t.Fatalf("topic not subscribed again after reconnect: %v", topics)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed")
+	}
+}
+
+// fills and klines change while polled
+type liveEx struct {
+	*fakeEx
+	mu         sync.Mutex
+	fills      []Fill
+	dots       []KDot
+	fillPolls  int
+	klinePolls int
+}
+
+func (e *liveEx) GetFills(market Market, target Pair, fromId *int64, limit int) ([]Fill, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fillPolls++
+	return append([]Fill(nil), e.fills...), nil
+}
+
+func (e *liveEx) GetKline(market Market, target Pair, period Period, since *time.Time) (*Kline, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.klinePolls++
+	return &Kline{Items: append([]KDot(nil), e.dots...)}, nil
+}
+
+// wait until both fills and klines are polled
+func (e *liveEx) waitPolled(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.mu.Lock()
+		polled := e.fillPolls > 0 && e.klinePolls > 0
+		e.mu.Unlock()
+		if polled {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fills and klines not polled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPollingStream(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	f := &liveEx{fakeEx: newFakeEx()}
+	target := testPair.SetMarket(MarketSpot)
+	f.fills = []Fill{
+		{Id: 1, Price: decimals.NewFromFloat64(100), UnitQty: decimals.One},
+		{Id: 2, Price: decimals.NewFromFloat64(101), UnitQty: decimals.One},
+	}
+	f.dots = []KDot{{Time: base, Close: decimals.NewFromInt(100)}, {Time: base.Add(time.Minute), Close: decimals.NewFromInt(101)}}
+
+	s, err := NewPollingStream(f, PollingOption{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SubscribeKline(target); err == nil {
+		t.Fatal("kline target without period should fail")
+	}
+	depths, err := s.SubscribeDepth(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fills, err := s.SubscribeFills(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	klines, err := s.SubscribeKline(target.SetPeriod(Period1Min))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ev := <-depths:
+		if len(ev.Depth.Sells) != 2 {
+			t.Fatalf("2 sells expected, got %d", len(ev.Depth.Sells))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("depth not received")
+	}
+
+	// history is not pushed, new ones are
+	f.waitPolled(t)
+	f.mu.Lock()
+	f.fills = append(f.fills, Fill{Id: 3, Price: decimals.NewFromFloat64(102), UnitQty: decimals.One})
+	f.dots = append(f.dots, KDot{Time: base.Add(2 * time.Minute), Close: decimals.NewFromInt(102)})
+	f.mu.Unlock()
+	select {
+	case ev := <-fills:
+		if ev.Fill.Id != 3 {
+			t.Fatalf("fill 3 expected, got %d", ev.Fill.Id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fill 3 not received")
+	}
+	select {
+	case ev := <-klines:
+		if !ev.Dot.Time.Equal(base.Add(2 * time.Minute)) {
+			t.Fatalf("unexpected kline dot at %s", ev.Dot.Time)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("kline dot not received")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// unchanged depth and old fills are never pushed again
+	for range depths {
+		t.Fatal("unchanged depth pushed again")
+	}
+	for range fills {
+		t.Fatal("fill pushed twice")
+	}
+	for range klines {
+		t.Fatal("kline dot pushed twice")
+	}
+}
+
+func TestStreamHub_SlowSubscriber(t *testing.T) {
+	h := newStreamHub(1)
+	target := testPair.SetMarket(MarketSpot)
+	slow, _ := h.addTick(target)
+	fast, _ := h.addTick(target)
+	for i := 1; i <= 3; i++ {
+		h.publish(TickEvent{Target: target, Tick: Tick{Last: decimals.NewFromInt(int64(i))}})
+		if ev := <-fast; !ev.Tick.Last.EqualInt(i) {
+			t.Fatalf("fast subscriber got tick %s, %d expected", ev.Tick.Last.String(), i)
+		}
+	}
+	if ev := <-slow; !ev.Tick.Last.EqualInt(1) {
+		t.Fatalf("slow subscriber got tick %s, 1 expected", ev.Tick.Last.String())
+	}
+	select {
+	case err := <-h.Errors():
+		if err == nil {
+			t.Fatal("overflow error expected")
+		}
+	default:
+		t.Fatal("overflow of slow subscriber not reported")
+	}
+	if h.stop() {
+		h.closeChannels()
+	}
+}
+
+func TestStreamHub_PrivateLossless(t *testing.T) {
+	h := newStreamHub(1)
+	orders, _ := h.addOrder()
+	sent := make(chan struct{})
+	go func() {
+		for i := 1; i <= 3; i++ {
+			h.publish(OrderEvent{DealDelta: decimals.NewFromInt(int64(i))})
+		}
+		close(sent)
+	}()
+	for i := 1; i <= 3; i++ {
+		if ev := <-orders; !ev.DealDelta.EqualInt(i) {
+			t.Fatalf("order event %d expected, got %s", i, ev.DealDelta.String())
+		}
+	}
+	<-sent
+	if len(h.Errors()) != 0 {
+		t.Fatal("private event reported as dropped")
+	}
+
+	// blocked publisher exits on stop
+	h.publish(OrderEvent{})
+	blocked := make(chan struct{})
+	go func() {
+		h.publish(OrderEvent{})
+		close(blocked)
+	}()
+	if h.stop() {
+		<-blocked
+		h.closeChannels()
+	}
+}
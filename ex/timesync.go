@@ -0,0 +1,209 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/sys/clock"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSyncSamples  = 5
+	defaultSyncInterval = time.Minute
+)
+
+type (
+	// ServerTimer is implemented by Ex which tells time of exchange server.
+	ServerTimer interface {
+		GetServerTime() (time.Time, error)
+	}
+
+	SyncOption struct {
+		Samples    int           // round trips of GetServerTime in a sync, 5 by default
+		Interval   time.Duration // interval of background sync, 1 minute by default
+		MaxDrift   time.Duration // an alert is sent if absolute offset exceeds it, zero means never
+		BufferSize int
+	}
+
+	// ClockOffset is the result of a sync
+	ClockOffset struct {
+		Offset    time.Duration // exchange time minus local time
+		RoundTrip time.Duration // of the sample taken
+		Time      time.Time     // local time of sync
+	}
+
+	// SyncedClock is the local clock adjusted to exchange time, by offset estimated NTP style:
+	// every sample takes local times before and after GetServerTime, offset is server time minus the middle of them,
+	// and the sample of the least round trip is taken. Methods other than Now are of the local clock.
+	// Set it as ExConfig.Clock to sign requests and cut candles in exchange time.
+	SyncedClock struct {
+		clock.Clock
+		st      ServerTimer
+		option  SyncOption
+		mu      sync.Mutex
+		offset  ClockOffset
+		alerts  chan ClockOffset
+		errs    chan error
+		done    chan struct{}
+		once    sync.Once
+		startMu sync.Mutex
+		started bool
+		wg      sync.WaitGroup
+	}
+)
+
+// ServerTimer of e, ErrFunctionNotSupported if e doesn't tell server time
+func ServerTimerOf(e Ex) (ServerTimer, error) {
+	if st, ok := e.(ServerTimer); ok && e.Capabilities().HasMethod(ExMethodGetServerTime) {
+		return st, nil
+	}
+	return nil, ErrFunctionNotSupported
+}
+
+// NewSyncedClock adjusts ExConfig.Clock of e, or system clock if it is nil, to server time of e.
+// It is not synced until Sync or Start. Methods other than Now need ExConfig.Clock of e to be set.
+func NewSyncedClock(e Ex, option SyncOption) (*SyncedClock, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to sync clock")
+	}
+	st, err := ServerTimerOf(e)
+	if err != nil {
+		return nil, err
+	}
+	if option.Samples <= 0 {
+		option.Samples = defaultSyncSamples
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultSyncInterval
+	}
+	return &SyncedClock{
+		Clock:  e.Config().Clock,
+		st:     st,
+		option: option,
+		alerts: make(chan ClockOffset, option.BufferSize),
+		errs:   make(chan error, 16),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// exchange time
+func (c *SyncedClock) Now() time.Time {
+	c.mu.Lock()
+	offset := c.offset.Offset
+	c.mu.Unlock()
+	return c.local().Add(offset)
+}
+
+func (c *SyncedClock) local() time.Time {
+	if c.Clock != nil {
+		return c.Clock.Now()
+	}
+	return time.Now().UTC()
+}
+
+// Offset of the last sync, zero before synced
+func (c *SyncedClock) Offset() ClockOffset {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+// Sync estimates offset by Samples round trips, failed samples are skipped.
+// An alert is sent if it exceeds MaxDrift, the offset is applied anyway.
+// The error of the first sample is returned if all of them fail.
+func (c *SyncedClock) Sync() (*ClockOffset, error) {
+	var best *ClockOffset
+	var firstErr error
+	for i := 0; i < c.option.Samples; i++ {
+		t0 := c.local()
+		server, err := c.st.GetServerTime()
+		t1 := c.local()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		rtt := t1.Sub(t0)
+		sample := ClockOffset{Offset: server.Sub(t0.Add(rtt / 2)), RoundTrip: rtt, Time: t1}
+		if best == nil || sample.RoundTrip < best.RoundTrip {
+			best = &sample
+		}
+	}
+	if best == nil {
+		// error of the first sample, offset is not changed
+		return nil, firstErr
+	}
+
+	c.mu.Lock()
+	c.offset = *best
+	c.mu.Unlock()
+	if c.Drifted(*best) {
+		select {
+		case c.alerts <- *best:
+		default:
+		}
+	}
+	return best, nil
+}
+
+// true if offset exceeds MaxDrift
+func (c *SyncedClock) Drifted(offset ClockOffset) bool {
+	if c.option.MaxDrift <= 0 {
+		return false
+	}
+	return offset.Offset > c.option.MaxDrift || offset.Offset < -c.option.MaxDrift
+}
+
+// Alerts of offsets exceeding MaxDrift, dropped if buffer is full
+func (c *SyncedClock) Alerts() <-chan ClockOffset {
+	return c.alerts
+}
+
+// errors of background sync, dropped if nobody reads
+func (c *SyncedClock) Errors() <-chan error {
+	return c.errs
+}
+
+// Start syncing every Interval in background, the first sync is done before it returns.
+// If the first sync fails nothing is started, and Start can be called again.
+func (c *SyncedClock) Start() error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.started {
+		return nil
+	}
+	if _, err := c.Sync(); err != nil {
+		return err
+	}
+	c.started = true
+	c.wg.Add(1)
+	go c.run()
+	return nil
+}
+
+func (c *SyncedClock) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
+
+func (c *SyncedClock) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.option.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := c.Sync(); err != nil {
+			select {
+			case c.errs <- err:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ex
+
+import (
+	"errors"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+// server time of fakeEx runs offset ahead of its clock, every call takes a round trip of rtts in turn
+type timerEx struct {
+	*fakeEx
+	clock  *testClock
+	offset time.Duration
+	rtts   []time.Duration
+	calls  int
+	err    error
+}
+
+func newTimerEx(offset time.Duration, rtts ...time.Duration) *timerEx {
+	f := newFakeEx()
+	c := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	f.config.Clock = c
+	return &timerEx{fakeEx: f, clock: c, offset: offset, rtts: rtts}
+}
+
+func (e *timerEx) Capabilities() Capabilities { return DefaultCapabilities(e) }
+
+// slow round trips are stamped at the end of them, only the fastest one is symmetric
+func (e *timerEx) GetServerTime() (time.Time, error) {
+	if e.err != nil {
+		return time.Time{}, e.err
+	}
+	rtt := e.rtts[e.calls%len(e.rtts)]
+	e.calls++
+	stamp := rtt / 2
+	if rtt > 10*time.Millisecond {
+		stamp = rtt
+	}
+	server := e.clock.now.Add(stamp).Add(e.offset)
+	e.clock.now = e.clock.now.Add(rtt)
+	return server, nil
+}
+
+func TestSyncedClock_Sync(t *testing.T) {
+	if _, err := NewSyncedClock(newFakeEx(), SyncOption{}); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("expected not supported error, got %v", err)
+	}
+
+	e := newTimerEx(3*time.Second, 40*time.Millisecond, 10*time.Millisecond, 30*time.Millisecond)
+	if !DefaultCapabilities(e).HasMethod(ExMethodGetServerTime) {
+		t.Fatal("GetServerTime missing in capabilities")
+	}
+	c, err := NewSyncedClock(e, SyncOption{Samples: 3, MaxDrift: time.Second, BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := c.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset.Offset != 3*time.Second || offset.RoundTrip != 10*time.Millisecond {
+		t.Fatalf("unexpected offset %+v", offset)
+	}
+	if !c.Now().Equal(e.clock.now.Add(3 * time.Second)) {
+		t.Fatalf("unexpected synced time %s", c.Now())
+	}
+	select {
+	case alert := <-c.Alerts():
+		if alert.Offset != 3*time.Second {
+			t.Fatalf("unexpected alert %+v", alert)
+		}
+	default:
+		t.Fatal("drift not alerted")
+	}
+
+	// no alert within MaxDrift
+	e.offset = -500 * time.Millisecond
+	if _, err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Offset().Offset != -500*time.Millisecond || len(c.Alerts()) != 0 {
+		t.Fatalf("unexpected offset %+v", c.Offset())
+	}
+
+	// offset is kept if all samples fail
+	e.err = ErrNetworkTransient
+	if _, err := c.Sync(); !errors.Is(err, ErrNetworkTransient) {
+		t.Fatalf("expected sync error, got %v", err)
+	}
+	if c.Offset().Offset != -500*time.Millisecond {
+		t.Fatalf("offset changed by failed sync %+v", c.Offset())
+	}
+}
+
+func TestSyncedClock_Start(t *testing.T) {
+	e := newTimerEx(time.Second, 10*time.Millisecond)
+	c, err := NewSyncedClock(e, SyncOption{Samples: 1, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// retried after a failed first sync
+	e.err = ErrNetworkTransient
+	if err := c.Start(); !errors.Is(err, ErrNetworkTransient) {
+		t.Fatalf("expected sync error, got %v", err)
+	}
+	e.err = nil
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Offset().Offset != time.Second {
+		t.Fatalf("unexpected offset %+v", c.Offset())
+	}
+	calls := e.calls
+	if err := c.Start(); err != nil || e.calls != calls {
+		t.Fatalf("started twice, err %v", err)
+	}
+}
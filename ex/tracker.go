@@ -0,0 +1,327 @@
+package ex
+
+import (
+	"encoding/json"
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	TrackEventKind string
+
+	// TrackEvent is a fill or an end of tracked order, DealDelta of OrderEvent is filled since last event
+	TrackEvent struct {
+		OrderEvent
+		Kind         TrackEventKind
+		AutoCanceled bool // canceled by tracker at deadline
+	}
+
+	TrackerOption struct {
+		BufferSize int
+		Interval   time.Duration // interval of GetOrder on tracked orders, ExConfig.RateLimit by default
+		Stream     UserStream    // optional, its order events are applied at once, polling still catches missed ones
+		StatePath  string        // optional, tracked orders are loaded from it if exists, and saved to it after each change
+	}
+
+	// TrackedOrder is an order followed by OrderTracker
+	TrackedOrder struct {
+		Order    Order     // last seen
+		Deadline time.Time // canceled by tracker after it, zero means never
+		Canceled bool      // cancel sent by tracker
+	}
+
+	// OrderTracker follows orders until they end and emits TrackEvent of fills and ends.
+	// Ended orders are not tracked any more.
+	OrderTracker struct {
+		e       Ex
+		option  TrackerOption
+		mu      sync.Mutex
+		applyMu sync.Mutex // held by apply until its event is sent, so Poll and Start never apply the same update twice
+		orders  map[OrderId]*TrackedOrder
+		events  chan TrackEvent
+		errs    chan error
+		done    chan struct{}
+		once    sync.Once
+		started sync.Once
+		wg      sync.WaitGroup
+	}
+)
+
+const (
+	TrackEventPartialFill TrackEventKind = "partial_fill"
+	TrackEventFill        TrackEventKind = "fill"
+	TrackEventCancel      TrackEventKind = "cancel"
+	TrackEventReject      TrackEventKind = "reject"
+	TrackEventExpire      TrackEventKind = "expire"
+)
+
+func (k TrackEventKind) String() string {
+	return string(k)
+}
+
+func NewOrderTracker(e Ex, option TrackerOption) (*OrderTracker, error) {
+	if e == nil || e.Config() == nil {
+		return nil, errorz.Errorf("nil exchange or config to track orders")
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultPollingInterval
+		if e.Config().RateLimit > 0 {
+			option.Interval = e.Config().RateLimit
+		}
+	}
+	t := &OrderTracker{
+		e:      e,
+		option: option,
+		orders: map[OrderId]*TrackedOrder{},
+		events: make(chan TrackEvent, option.BufferSize),
+		errs:   make(chan error, 16),
+		done:   make(chan struct{}),
+	}
+	if option.StatePath != "" {
+		b, err := ioutil.ReadFile(option.StatePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &t.orders); err != nil {
+				return nil, errorz.Errorf("invalid tracker state(%s): %s", option.StatePath, err.Error())
+			}
+		}
+	}
+	return t, nil
+}
+
+// Track order until it ends, it is canceled after timeout unless timeout is 0.
+// Tracking an order again only updates its deadline.
+func (t *OrderTracker) Track(id OrderId, timeout time.Duration) error {
+	if err := id.Verify(); err != nil {
+		return err
+	}
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = nowOf(t.e.Config()).Add(timeout)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if to, ok := t.orders[id]; ok {
+		to.Deadline = deadline
+	} else {
+		t.orders[id] = &TrackedOrder{Order: Order{Id: id}, Deadline: deadline}
+	}
+	return t.save()
+}
+
+func (t *OrderTracker) Untrack(id OrderId) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.orders, id)
+	return t.save()
+}
+
+// orders being tracked, sorted by id
+func (t *OrderTracker) Tracked() []TrackedOrder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var r []TrackedOrder
+	for _, to := range t.orders {
+		r = append(r, *to)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Order.Id < r[j].Order.Id })
+	return r
+}
+
+// Events of all tracked orders, tracker blocks on slow reader until Close
+func (t *OrderTracker) Events() <-chan TrackEvent {
+	return t.events
+}
+
+// errors of background polling, dropped if nobody reads
+func (t *OrderTracker) Errors() <-chan error {
+	return t.errs
+}
+
+// Start polling tracked orders and reading Stream in background
+func (t *OrderTracker) Start() error {
+	var err error
+	t.started.Do(func() {
+		var stream <-chan OrderEvent
+		if t.option.Stream != nil {
+			if stream, err = t.option.Stream.SubscribeOrders(); err != nil {
+				return
+			}
+		}
+		t.wg.Add(1)
+		go t.run(stream)
+	})
+	return err
+}
+
+func (t *OrderTracker) Close() error {
+	t.once.Do(func() { close(t.done) })
+	t.wg.Wait()
+	return nil
+}
+
+func (t *OrderTracker) run(stream <-chan OrderEvent) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.option.Interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Poll(); err != nil {
+			t.reportError(err)
+		}
+		for wait := true; wait; {
+			select {
+			case <-t.done:
+				return
+			case ev, ok := <-stream:
+				if !ok {
+					stream = nil
+					continue
+				}
+				if err := t.apply(ev.Order); err != nil {
+					t.reportError(err)
+				}
+			case <-ticker.C:
+				wait = false
+			}
+		}
+	}
+}
+
+// Poll gets every tracked order once and cancels the ones past deadline, it is called by Start periodically.
+// Errors of orders don't stop polling others, the first one is returned. Events are sent before it returns,
+// so Events must be read or buffered if it is called directly. It is safe to call alongside Start.
+func (t *OrderTracker) Poll() error {
+	var firstErr error
+	now := nowOf(t.e.Config())
+	for _, to := range t.Tracked() {
+		id := to.Order.Id
+		if !to.Deadline.IsZero() && !now.Before(to.Deadline) && !to.Canceled {
+			if err := t.cancel(id); err != nil {
+				// it may be ended just before canceled, GetOrder tells
+				if firstErr == nil {
+					firstErr = errorz.Errorf("auto cancel order(%s) error: %s", id, err.Error())
+				}
+			}
+		}
+		order, err := t.e.GetOrder(id)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errorz.Errorf("get order(%s) error: %s", id, err.Error())
+			}
+			continue
+		}
+		if err := t.apply(*order); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *OrderTracker) cancel(id OrderId) error {
+	if err := t.e.CancelOrder(id); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if to, ok := t.orders[id]; ok {
+		to.Canceled = true
+	}
+	return t.save()
+}
+
+// update tracked order and emit event if it is filled more or ended.
+// Stale updates are ignored, see staleOrder.
+// Event is emitted before the change is saved, so it is emitted again after restarted if saving is lost.
+func (t *OrderTracker) apply(order Order) error {
+	t.applyMu.Lock()
+	defer t.applyMu.Unlock()
+	t.mu.Lock()
+	to, ok := t.orders[order.Id]
+	if !ok || !orderChanged(to.Order, order) || staleOrder(to.Order, order) {
+		t.mu.Unlock()
+		return nil
+	}
+	prev, canceled := to.Order, to.Canceled
+	to.Order = order
+	t.mu.Unlock()
+
+	ev := TrackEvent{OrderEvent: newOrderEvent(order, &prev)}
+	switch order.Status {
+	case TradeStatusFilled:
+		ev.Kind = TrackEventFill
+	case TradeStatusCanceled:
+		ev.Kind, ev.AutoCanceled = TrackEventCancel, canceled
+	case TradeStatusRejected:
+		ev.Kind = TrackEventReject
+	case TradeStatusExpired:
+		ev.Kind = TrackEventExpire
+	default:
+		if ev.DealDelta.IsPositive() {
+			ev.Kind = TrackEventPartialFill
+		}
+	}
+	if ev.Kind != "" {
+		select {
+		case t.events <- ev:
+		case <-t.done:
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if order.Status.End() {
+		delete(t.orders, order.Id)
+	}
+	return t.save()
+}
+
+// whether next is older than last seen one: filled less, reopens an ended order,
+// or moves status back or nowhere without new deal
+func staleOrder(last, next Order) bool {
+	if next.DealAmount.LessThan(last.DealAmount) || (last.Status.End() && !next.Status.End()) {
+		return true
+	}
+	return next.DealAmount.Equal(last.DealAmount) && statusProgress(next.Status) <= statusProgress(last.Status)
+}
+
+// an order never goes back to a status of less progress
+func statusProgress(status TradeStatus) int {
+	switch {
+	case status.End():
+		return 3
+	case status == TradeStatusCanceling:
+		return 2
+	case status == TradeStatusPartiallyFilled:
+		return 1
+	case status == TradeStatusError: // not seen yet
+		return -1
+	default:
+		return 0
+	}
+}
+
+// caller holds t.mu
+func (t *OrderTracker) save() error {
+	if t.option.StatePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(t.orders)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.option.StatePath, b)
+}
+
+func (t *OrderTracker) reportError(err error) {
+	select {
+	case t.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,272 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type (
+	// UserStream pushing order events written to orders
+	chanUserStream struct {
+		UserStream
+		orders chan OrderEvent
+	}
+)
+
+func (s *chanUserStream) SubscribeOrders() (<-chan OrderEvent, error) {
+	return s.orders, nil
+}
+
+func newTestTracker(t *testing.T, option TrackerOption) (*OrderTracker, *PaperEx, *fakeEx, *testClock) {
+	p, real := newTestPaperEx(t)
+	clk := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	real.config.Clock = clk
+	if option.BufferSize == 0 {
+		option.BufferSize = 10
+	}
+	tr, err := NewOrderTracker(p, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, p, real, clk
+}
+
+func nextTrackEvent(t *testing.T, tr *OrderTracker) TrackEvent {
+	select {
+	case ev := <-tr.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no track event")
+	}
+	return TrackEvent{}
+}
+
+func TestOrderTracker(t *testing.T) {
+	tr, p, real, clk := newTestTracker(t, TrackerOption{})
+	open, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.NewFromFloat64(0.5), decimals.NewFromInt(101))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*open, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*taken, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	ev := nextTrackEvent(t, tr)
+	if ev.Kind != TrackEventFill || ev.Order.Id != *taken || !ev.DealDelta.Equal(decimals.NewFromFloat64(0.5)) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if tracked := tr.Tracked(); len(tracked) != 1 || tracked[0].Order.Id != *open {
+		t.Fatalf("unexpected tracked orders %+v", tracked)
+	}
+
+	// asks down to 95
+	real.setDepth(testPair, [][2]float64{{95, 0.4}}, [][2]float64{{94, 1}})
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	ev = nextTrackEvent(t, tr)
+	if ev.Kind != TrackEventPartialFill || !ev.DealDelta.Equal(decimals.NewFromFloat64(0.4)) || ev.PrevStatus != TradeStatusNew {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// nothing changed, depth is not crossed any more
+	real.setDepth(testPair, [][2]float64{{101, 1}}, [][2]float64{{94, 1}})
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-tr.Events():
+		t.Fatalf("unexpected event %+v", ev)
+	default:
+	}
+
+	clk.now = clk.now.Add(time.Minute)
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	ev = nextTrackEvent(t, tr)
+	if ev.Kind != TrackEventCancel || !ev.AutoCanceled || !ev.DealDelta.IsZero() || !ev.Order.DealAmount.Equal(decimals.NewFromFloat64(0.4)) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if tracked := tr.Tracked(); len(tracked) != 0 {
+		t.Fatalf("ended orders still tracked %+v", tracked)
+	}
+}
+
+func TestOrderTracker_Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.json")
+	tr, p, real, _ := newTestTracker(t, TrackerOption{StatePath: path})
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitSell, decimals.One, decimals.NewFromInt(105))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restarted
+	tr, err = NewOrderTracker(p, TrackerOption{StatePath: path, BufferSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := tr.Tracked()
+	if len(tracked) != 1 || tracked[0].Order.Id != *id || tracked[0].Order.Status != TradeStatusNew || tracked[0].Deadline.IsZero() {
+		t.Fatalf("unexpected tracked orders %+v", tracked)
+	}
+	real.setDepth(testPair, [][2]float64{{106, 1}}, [][2]float64{{105, 2}})
+	if err := tr.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextTrackEvent(t, tr); ev.Kind != TrackEventFill || ev.PrevStatus != TradeStatusNew || !ev.DealDelta.EqualInt(1) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	tr, err = NewOrderTracker(p, TrackerOption{StatePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := tr.Tracked(); len(tracked) != 0 {
+		t.Fatalf("ended orders saved %+v", tracked)
+	}
+}
+
+func TestOrderTracker_Stream(t *testing.T) {
+	stream := &chanUserStream{orders: make(chan OrderEvent)}
+	tr, p, _, _ := newTestTracker(t, TrackerOption{Stream: stream, Interval: time.Hour})
+	defer tr.Close()
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.Status = TradeStatusRejected
+	stream.orders <- OrderEvent{Order: *order}
+	if ev := nextTrackEvent(t, tr); ev.Kind != TrackEventReject || ev.Order.Id != *id {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestOrderTracker_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.json")
+	tr, p, _, _ := newTestTracker(t, TrackerOption{StatePath: path})
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*id, 0); err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *order
+	order.Status, order.DealAmount = TradeStatusPartiallyFilled, decimals.NewFromFloat64(0.5)
+	if err := tr.apply(*order); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextTrackEvent(t, tr); ev.Kind != TrackEventPartialFill || !ev.DealDelta.Equal(decimals.NewFromFloat64(0.5)) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// an event queued before the last poll arrives late
+	if err := tr.apply(stale); err != nil {
+		t.Fatal(err)
+	}
+	if tracked := tr.Tracked(); len(tracked) != 1 || !tracked[0].Order.DealAmount.Equal(decimals.NewFromFloat64(0.5)) {
+		t.Fatalf("stale update applied %+v", tracked)
+	}
+	// status goes back without new deal
+	regressed := *order
+	regressed.Status = TradeStatusNew
+	if err := tr.apply(regressed); err != nil {
+		t.Fatal(err)
+	}
+	if tracked := tr.Tracked(); len(tracked) != 1 || tracked[0].Order.Status != TradeStatusPartiallyFilled {
+		t.Fatalf("stale status applied %+v", tracked)
+	}
+	order.Status, order.DealAmount = TradeStatusFilled, decimals.One
+	if err := tr.apply(*order); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextTrackEvent(t, tr); ev.Kind != TrackEventFill || !ev.DealDelta.Equal(decimals.NewFromFloat64(0.5)) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	select {
+	case ev := <-tr.Events():
+		t.Fatalf("unexpected event %+v", ev)
+	default:
+	}
+}
+
+func TestOrderTracker_ConcurrentApply(t *testing.T) {
+	tr, p, _, _ := newTestTracker(t, TrackerOption{BufferSize: 40})
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(*id, 0); err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.GetOrder(*id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Poll and Start both apply the same updates
+	done := make(chan struct{})
+	for g := 0; g < 2; g++ {
+		go func() {
+			for i := 1; i <= 10; i++ {
+				update := *order
+				update.Status, update.DealAmount = TradeStatusPartiallyFilled, decimals.NewFromFloat64(float64(i)/10)
+				if i == 10 {
+					update.Status = TradeStatusFilled
+				}
+				if err := tr.apply(update); err != nil {
+					t.Error(err)
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+
+	total, last := decimals.Zero, decimals.Zero
+	for len(tr.Events()) > 0 {
+		ev := <-tr.Events()
+		if !ev.Order.DealAmount.GreaterThan(last) {
+			t.Fatalf("event out of order %+v", ev)
+		}
+		last, total = ev.Order.DealAmount, total.Add(ev.DealDelta)
+	}
+	if !total.Equal(decimals.One) {
+		t.Fatalf("deal deltas sum to %s, 1 expected", total.String())
+	}
+}
@@ -0,0 +1,274 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/apputil/errorz"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"sync"
+	"time"
+)
+
+type (
+	// status transition or (partial) fill of own order
+	OrderEvent struct {
+		Order      Order            // latest order, Order.Status is the new status
+		PrevStatus TradeStatus      // TradeStatusError if order is seen first time
+		DealDelta  decimals.Decimal // unit amount filled since last event
+		FeeDelta   decimals.Decimal // fee charged since last event
+	}
+
+	BalanceEvent struct {
+		Market  Market
+		Asset   string
+		Balance Balance
+		Prev    Balance
+	}
+
+	// UserStream pushes private events of the account, it is the streaming alternative of
+	// polling GetOpenOrders, GetOrder and GetAccount.
+	// Events are never dropped since deltas of orders would be lost, a subscriber not reading its channel
+	// blocks delivery to all subscribers until it reads or the stream is closed.
+	// All subscribed channels are closed after Close.
+	UserStream interface {
+		SubscribeOrders() (<-chan OrderEvent, error)
+
+		SubscribeBalances() (<-chan BalanceEvent, error)
+
+		Errors() <-chan error
+
+		Close() error
+	}
+
+	connUserStream struct {
+		*streamHub
+		keeper *connKeeper
+	}
+
+	PollingUserOption struct {
+		BufferSize int
+		Interval   time.Duration // ExConfig.RateLimit by default
+		Targets    []PairExt     // pairs with market to watch orders of, balances are always watched
+	}
+
+	// pollingUserStream diffs GetOpenOrders and GetAccount results of an Ex
+	pollingUserStream struct {
+		*streamHub
+		ex       Ex
+		option   PollingUserOption
+		once     sync.Once
+		wg       sync.WaitGroup
+		orders   map[OrderId]Order
+		balances map[Market]map[string]Balance
+	}
+)
+
+func newOrderEvent(order Order, prev *Order) OrderEvent {
+	ev := OrderEvent{Order: order, PrevStatus: TradeStatusError, DealDelta: order.DealAmount, FeeDelta: order.Fee}
+	if prev != nil {
+		ev.PrevStatus = prev.Status
+		ev.DealDelta = order.DealAmount.Sub(prev.DealAmount)
+		ev.FeeDelta = order.Fee.Sub(prev.Fee)
+	}
+	return ev
+}
+
+func orderChanged(a, b Order) bool {
+	return a.Status != b.Status || !a.DealAmount.Equal(b.DealAmount) || !a.Fee.Equal(b.Fee)
+}
+
+func balanceChanged(a, b Balance) bool {
+	return !a.Free.Equal(b.Free) || !a.Locked.Equal(b.Locked) || !a.Borrowed.Equal(b.Borrowed) || !a.Interest.Equal(b.Interest)
+}
+
+// NewConnUserStream makes a UserStream from a push connection dialer, the dialer authorizes and subscribes
+// user data of the account, connection messages are OrderEvent and BalanceEvent.
+func NewConnUserStream(dial StreamDialer, option StreamOption) (UserStream, error) {
+	if dial == nil {
+		return nil, errorz.Errorf("nil stream dialer")
+	}
+	option.setDefaults()
+	s := &connUserStream{streamHub: newStreamHub(option.BufferSize)}
+	s.keeper = &connKeeper{hub: s.streamHub, dial: dial, option: option}
+	s.keeper.start()
+	return s, nil
+}
+
+func (s *connUserStream) SubscribeOrders() (<-chan OrderEvent, error) {
+	return s.addOrder()
+}
+
+func (s *connUserStream) SubscribeBalances() (<-chan BalanceEvent, error) {
+	return s.addBalance()
+}
+
+func (s *connUserStream) Close() error {
+	s.keeper.close()
+	return nil
+}
+
+// NewPollingUserStream makes a UserStream by polling e, it is the fallback if exchange has no user data push API.
+// Orders and balances at first poll are the baseline, only changes after it are pushed.
+// Orders opened and ended between two polls are not seen, balance events still reflect them.
+func NewPollingUserStream(e Ex, option PollingUserOption) (UserStream, error) {
+	if e == nil {
+		return nil, errorz.Errorf("nil exchange to poll")
+	}
+	for _, target := range option.Targets {
+		if target.Pair() == PairErr || target.Market() == MarketError {
+			return nil, errorz.Errorf("polling target(%s) requires pair and market", target.String())
+		}
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultPollingInterval
+		if e.Config() != nil && e.Config().RateLimit > 0 {
+			option.Interval = e.Config().RateLimit
+		}
+	}
+	return &pollingUserStream{
+		streamHub: newStreamHub(option.BufferSize),
+		ex:        e,
+		option:    option,
+	}, nil
+}
+
+func (s *pollingUserStream) SubscribeOrders() (<-chan OrderEvent, error) {
+	ch, err := s.addOrder()
+	if err != nil {
+		return nil, err
+	}
+	s.start()
+	return ch, nil
+}
+
+func (s *pollingUserStream) SubscribeBalances() (<-chan BalanceEvent, error) {
+	ch, err := s.addBalance()
+	if err != nil {
+		return nil, err
+	}
+	s.start()
+	return ch, nil
+}
+
+func (s *pollingUserStream) Close() error {
+	if !s.stop() {
+		return nil
+	}
+	s.wg.Wait()
+	s.closeChannels()
+	return nil
+}
+
+// polling starts with first subscription
+func (s *pollingUserStream) start() {
+	s.once.Do(func() {
+		s.wg.Add(1)
+		go s.poll()
+	})
+}
+
+func (s *pollingUserStream) poll() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.option.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.pollOrders(); err != nil {
+			s.reportError(errorz.Errorf("poll orders error: %s", err.Error()))
+		}
+		if err := s.pollBalances(); err != nil {
+			s.reportError(errorz.Errorf("poll account error: %s", err.Error()))
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *pollingUserStream) pollOrders() error {
+	open := map[OrderId]Order{}
+	for _, target := range s.option.Targets {
+		orders, err := s.ex.GetOpenOrders(target.Market(), target.Pair())
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			open[order.Id] = order
+		}
+	}
+
+	if s.orders == nil {
+		s.orders = open
+		return nil
+	}
+
+	for id, order := range open {
+		prev, ok := s.orders[id]
+		if !ok {
+			s.publish(newOrderEvent(order, nil))
+		} else if orderChanged(prev, order) {
+			s.publish(newOrderEvent(order, &prev))
+		}
+		s.orders[id] = order
+	}
+
+	// orders no longer open are filled, canceled or expired, ask for their final state
+	for id, prev := range s.orders {
+		if _, ok := open[id]; ok {
+			continue
+		}
+		order, err := s.ex.GetOrder(id)
+		if err != nil {
+			return err // checked again in next poll
+		}
+		if !order.Status.End() {
+			continue // not yet visible in open orders
+		}
+		if orderChanged(prev, *order) {
+			s.publish(newOrderEvent(*order, &prev))
+		}
+		delete(s.orders, id)
+	}
+	return nil
+}
+
+func (s *pollingUserStream) pollBalances() error {
+	acc, err := s.ex.GetAccount()
+	if err != nil {
+		return err
+	}
+	curr := map[Market]map[string]Balance{MarketSpot: acc.Spot, MarketMargin: acc.Margin}
+	if s.balances == nil {
+		s.balances = copyBalances(curr)
+		return nil
+	}
+
+	for _, market := range []Market{MarketSpot, MarketMargin} {
+		for asset, blc := range curr[market] {
+			prev := s.balances[market][asset]
+			if balanceChanged(prev, blc) {
+				s.publish(BalanceEvent{Market: market, Asset: asset, Balance: blc, Prev: prev})
+			}
+		}
+		// asset removed from account means nothing left
+		for asset, prev := range s.balances[market] {
+			if _, ok := curr[market][asset]; !ok && balanceChanged(prev, Balance{}) {
+				s.publish(BalanceEvent{Market: market, Asset: asset, Prev: prev})
+			}
+		}
+	}
+	s.balances = copyBalances(curr)
+	return nil
+}
+
+func copyBalances(src map[Market]map[string]Balance) map[Market]map[string]Balance {
+	r := map[Market]map[string]Balance{}
+	for market, blcs := range src {
+		r[market] = map[string]Balance{}
+		for asset, blc := range blcs {
+			r[market][asset] = blc
+		}
+	}
+	return r
+}
@@ -0,0 +1,67 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+)
+
+func TestPollingUserStream(t *testing.T) {
+	p, real := newTestPaperEx(t)
+	us, err := NewPollingUserStream(p, PollingUserOption{Targets: []PairExt{testPair.SetMarket(MarketSpot)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drive polls by hand instead of the polling goroutine
+	s := us.(*pollingUserStream)
+	orders, _ := s.addOrder()
+	balances, _ := s.addBalance()
+	poll := func() {
+		if err := s.pollOrders(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.pollBalances(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poll()
+	if len(orders) != 0 || len(balances) != 0 {
+		t.Fatal("baseline should not be pushed")
+	}
+
+	// resting limit buy
+	id, err := p.Trade(MarketSpot, testPair, TradeTypeSideLimitBuy, decimals.One, decimals.NewFromInt(99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	ev := <-orders
+	if ev.Order.Id != *id || ev.PrevStatus != TradeStatusError || ev.Order.Status != TradeStatusNew {
+		t.Fatalf("unexpected new order event %s -> %s", ev.PrevStatus, ev.Order.Status)
+	}
+	blc := <-balances
+	if blc.Asset != "USDT" || !blc.Balance.Locked.Equal(decimals.NewFromInt(99)) || !blc.Prev.Locked.IsZero() {
+		t.Fatalf("unexpected balance event of %s, locked %s", blc.Asset, blc.Balance.Locked.String())
+	}
+
+	// book trades through the order, it fills as maker and leaves open orders
+	real.setDepth(testPair, [][2]float64{{98, 2}}, [][2]float64{{97, 1}})
+	poll()
+	ev = <-orders
+	if ev.PrevStatus != TradeStatusNew || ev.Order.Status != TradeStatusFilled {
+		t.Fatalf("unexpected fill event %s -> %s", ev.PrevStatus, ev.Order.Status)
+	}
+	if !ev.DealDelta.Equal(decimals.One) || !ev.FeeDelta.Equal(decimals.NewFromFloat64(0.001)) {
+		t.Fatalf("unexpected deal delta %s fee delta %s", ev.DealDelta.String(), ev.FeeDelta.String())
+	}
+	if len(balances) != 2 {
+		t.Fatalf("BTC and USDT balance events expected, got %d", len(balances))
+	}
+
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-orders; ok {
+		t.Fatal("channel should be closed")
+	}
+}
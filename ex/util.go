@@ -0,0 +1,61 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// current time of exchange, local UTC time if no clock configured
+func nowOf(config *ExConfig) time.Time {
+	if config == nil || config.Clock == nil {
+		return time.Now().UTC()
+	}
+	return config.Clock.Now()
+}
+
+// truncate unit amount by lot step and unit precision of PairInfo
+func roundAmount(amount decimals.Decimal, info *PairInfo) decimals.Decimal {
+	if info == nil {
+		return amount
+	}
+	return info.RoundAmount(amount)
+}
+
+// truncate price by quote precision of PairInfo
+func roundPrice(price decimals.Decimal, info *PairInfo) decimals.Decimal {
+	if info == nil {
+		return price
+	}
+	return info.RoundPrice(price)
+}
+
+// deep copy spot and margin balances
+func copyAccount(acc *Account) *Account {
+	r := NewEmptyAccount()
+	if acc == nil {
+		return r
+	}
+	r.Add(*acc)
+	return r
+}
+
+// write to a temporary file and rename it, the old file is never broken by an interrupted write
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
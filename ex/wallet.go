@@ -0,0 +1,35 @@
+package ex
+
+import (
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"time"
+)
+
+type (
+	// WalletEx is implemented by Ex which moves assets in and out of exchange.
+	WalletEx interface {
+		// network empty means default network of asset
+		GetDepositAddress(asset, network string) (*DepositAddress, error)
+
+		// amount excludes fee, id of withdrawal is returned
+		Withdraw(amount decimals.Decimal, to DepositAddress) (string, error)
+
+		// deposits of asset since time, all assets if asset is empty, oldest first
+		GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error)
+
+		// withdrawals of asset since time like GetDeposits
+		GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error)
+
+		// transfers between markets since time like GetDeposits
+		GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error)
+	}
+)
+
+// WalletEx of e, ErrFunctionNotSupported if e doesn't move assets in or out
+func WalletOf(e Ex) (WalletEx, error) {
+	if we, ok := e.(WalletEx); ok && e.Capabilities().HasMethod(ExMethodGetDeposits) {
+		return we, nil
+	}
+	return nil, ErrFunctionNotSupported
+}
@@ -0,0 +1,82 @@
+package ex
+
+import (
+	"errors"
+	"github.com/shawnwyckoff/commpkg/dsa/decimals"
+	. "github.com/shawnwyckoff/fintypes/comm"
+	"testing"
+	"time"
+)
+
+type (
+	fakeWalletEx struct {
+		*fakeEx
+		withdrawals []AssetTransfer
+	}
+)
+
+func newFakeWalletEx(platform Platform) *fakeWalletEx {
+	f := &fakeWalletEx{fakeEx: newFakeEx()}
+	f.config.Name = platform
+	return f
+}
+
+func (f *fakeWalletEx) Capabilities() Capabilities { return DefaultCapabilities(f) }
+
+func (f *fakeWalletEx) GetDepositAddress(asset, network string) (*DepositAddress, error) {
+	return &DepositAddress{Asset: asset, Network: network, Address: f.config.Name.String() + "-" + asset}, nil
+}
+
+func (f *fakeWalletEx) Withdraw(amount decimals.Decimal, to DepositAddress) (string, error) {
+	f.withdrawals = append(f.withdrawals, AssetTransfer{Kind: TransferKindWithdrawal, Asset: to.Asset, Amount: amount, Network: to.Network, Address: to.Address})
+	return "1", nil
+}
+
+func (f *fakeWalletEx) GetDeposits(asset string, since *time.Time) ([]AssetTransfer, error) {
+	return nil, nil
+}
+
+func (f *fakeWalletEx) GetWithdrawals(asset string, since *time.Time) ([]AssetTransfer, error) {
+	return f.withdrawals, nil
+}
+
+func (f *fakeWalletEx) GetTransfers(asset string, since *time.Time) ([]AssetTransfer, error) {
+	return nil, nil
+}
+
+func TestMultiEx_Move(t *testing.T) {
+	binance, kraken := newFakeWalletEx(Binance), newFakeWalletEx(Kraken)
+	// decorators keep wallet functions available
+	rk, _ := newTestRetryEx(t, kraken, RetryOption{})
+	m, err := NewMultiEx(binance, rk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Move("USDT", "TRX", decimals.NewFromInt(100), Binance, Binance); err == nil {
+		t.Fatal("move to the same platform should fail")
+	}
+	if _, err := m.Move("USDT", "TRX", decimals.NewFromInt(100), Binance, Kraken); err != nil {
+		t.Fatal(err)
+	}
+	withdrawals, _ := binance.GetWithdrawals("USDT", nil)
+	if len(withdrawals) != 1 || withdrawals[0].Address != Kraken.String()+"-USDT" || withdrawals[0].Network != "TRX" || !withdrawals[0].Amount.EqualInt(100) {
+		t.Fatalf("withdrawal to kraken deposit address expected, got %v", withdrawals)
+	}
+
+	plain := newFakeEx()
+	plain.config.Name = Kraken
+	m, _ = NewMultiEx(binance, plain)
+	if _, err := m.Move("USDT", "TRX", decimals.NewFromInt(100), Binance, Kraken); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}
+
+func TestWalletOf(t *testing.T) {
+	if _, err := WalletOf(newFakeEx()); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+	r, _ := newTestRetryEx(t, newFakeEx(), RetryOption{})
+	if _, err := r.GetDeposits("", nil); !errors.Is(err, ErrFunctionNotSupported) {
+		t.Fatalf("ErrFunctionNotSupported expected, got %v", err)
+	}
+}